@@ -0,0 +1,300 @@
+// Package api implements the JSON endpoints of daisser.
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"geo"
+	"ingest"
+	"middleware"
+	"owntracks"
+	"storage"
+)
+
+// API serves the data held in Store.
+type API struct {
+	Store    storage.Store
+	Logger   *log.Logger
+	NotFound http.HandlerFunc
+	// Matcher snaps tracks to roads, it is nil if map matching is disabled.
+	Matcher *Matcher
+	// Geocoder searches addresses for the map, it is nil if address search
+	// is disabled.
+	Geocoder *Geocoder
+	// PlusCodes adds the Plus Code to the details of positions.
+	PlusCodes bool
+	// What3Words adds the what3words address to the details of positions,
+	// it is nil if what3words is disabled.
+	What3Words *What3Words
+	// SpeedLimits looks up the speed limits of roads, speeding reports are
+	// not available if it is nil.
+	SpeedLimits *SpeedLimits
+	// Preferences holds the settings of the users. If nil, every user gets
+	// storage.DefaultPreferences.
+	Preferences *storage.PreferenceStore
+	// DeviceInfos holds the labels and flags of the devices. If nil, no
+	// device has any.
+	DeviceInfos *storage.DeviceInfoStore
+	// PlaceStore holds the places of the users. Places are not available if
+	// it is nil.
+	PlaceStore *storage.PlaceStore
+	// POIStore holds the POIs of the users. POIs are not available if it is
+	// nil.
+	POIStore *storage.POIStore
+	// Broker receives all accepted positions for the live streams. Live
+	// shares are not available if it is nil.
+	Broker *Broker
+	// PollTokens maps the tokens of the poll endpoints to their users.
+	PollTokens map[string]string
+	// DeviceSetup is handed to new devices as configuration file. The
+	// configurations are not available if it is nil.
+	DeviceSetup *DeviceSetup
+	// Exports runs the export jobs. Export jobs are not available if it is
+	// nil.
+	Exports *Exports
+	// Stats counts the positions the devices sent. The stats are not
+	// available if it is nil.
+	Stats *storage.DeviceStatsStore
+	// Protocols are the names of the enabled ingest protocols.
+	Protocols []string
+	// MQTT tells whether devices can send their positions over MQTT.
+	MQTT bool
+	// Import stores imported positions after the policies and privacy
+	// rules of received ones. Imports are not available if it is nil.
+	Import ingest.Sink
+
+	sharesMu sync.Mutex
+	shares   map[string]Share
+}
+
+// RegisterRoutes registers the endpoints of the API with r.
+func (a *API) RegisterRoutes(r *middleware.Router) {
+	r.HandleFunc("/api/positions", a.Positions)
+	r.HandleFunc("/api/last", a.Positions)
+	r.HandleFunc("/api/track", a.Track)
+	r.HandleFunc("/api/compare", a.Compare)
+	r.HandleFunc("/api/interpolate", a.Interpolate)
+	r.HandleFunc("/api/speeding", a.Speeding)
+	r.HandleFunc("/api/driving", a.Driving)
+	r.HandleFunc("/api/gaps", a.Gaps)
+	r.HandleFunc("/api/distance", a.Distance)
+	r.HandleFunc("/api/me/preferences", a.MyPreferences)
+	r.HandleFunc("/api/devices", a.Devices)
+	r.HandleFunc("/api/devices/", a.Device)
+	r.HandleFunc("/api/places", a.Places)
+	r.HandleFunc("/api/places/", a.Place)
+	r.HandleFunc("/api/timesheet", a.Timesheet)
+	r.HandleFunc("/api/pois", a.POIs)
+	r.HandleFunc("/api/pois/", a.POI)
+	r.HandleFunc("/api/layers/pois", a.POILayer)
+	r.HandleFunc("/api/shares", a.Shares)
+	r.HandleFunc("/api/shares/", a.StopShare)
+	r.HandleFunc("/api/geocode", a.Geocode)
+	r.HandleFunc("/api/exports", a.ExportJobs)
+	r.HandleFunc("/api/exports/", a.ExportJob)
+	r.HandleFunc("/api/bundle", a.Bundle)
+	r.HandleFunc("/api/bootstrap", a.Bootstrap)
+	r.HandleFunc("/api/capabilities", a.Capabilities)
+}
+
+// RegisterImportRoutes registers the imports with r, which must not limit
+// the time of handlers, since uploads may be large.
+func (a *API) RegisterImportRoutes(r *middleware.Router) {
+	r.HandleFunc("/api/import/gpx", a.ImportGPX)
+}
+
+// RegisterGrafanaRoutes registers the endpoints of the Grafana datasource
+// with r.
+func (a *API) RegisterGrafanaRoutes(r *middleware.Router) {
+	r.HandleFunc("/grafana/", a.GrafanaTest)
+	r.HandleFunc("/grafana/search", a.GrafanaSearch)
+	r.HandleFunc("/grafana/query", a.GrafanaQuery)
+}
+
+type Feature struct {
+	Type       string            `json:"type"`
+	Properties map[string]string `json:"properties"`
+	Geometry   struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Positions returns the latest position of every device as GeoJSON, or in
+// one of the binary encodings if the client asks for it. The parameter
+// geohash restricts the result to positions whose geohash starts with it.
+// Only the users in the VisibleUsers preference are included, if it is set,
+// and times are given in the preferred time zone. Archived devices are left
+// out unless the parameter archived is true.
+func (a *API) Positions(w http.ResponseWriter, r *http.Request) {
+	devices, err := a.Store.Devices()
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	prefs := a.preferences(r)
+	prefix := r.FormValue("geohash")
+	archived := r.FormValue("archived") == "true"
+	var l []owntracks.LocationUpdate
+	for _, d := range devices {
+		if !archived && a.deviceInfo(d.Name()).Archived {
+			continue
+		}
+		if strings.HasPrefix(d.Last.Geohash, prefix) && visible(prefs, d.User) {
+			l = append(l, d.Last)
+		}
+	}
+	loc := a.location(r)
+	if accepts(r, contentTypeProtobuf) {
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		w.Write(marshalPositionsProto(l))
+		return
+	}
+	var fc FeatureCollection
+	fc.Type = "FeatureCollection"
+	for _, v := range l {
+		var f Feature
+		f.Type = "Feature"
+		f.Properties = make(map[string]string)
+		f.Properties["Time"] = v.T.In(loc).String()
+		f.Properties["User"] = v.User
+		f.Properties["Client"] = v.ClientID
+		f.Properties["Tracker"] = v.TrackerID
+		f.Properties["Accuracy"] = strconv.Itoa(v.Accuracy)
+		f.Properties["Description"] = v.Description
+		f.Properties["Geohash"] = v.Geohash
+		plus, words := a.LocationCodes(v.Latitude, v.Longitude)
+		if plus != "" {
+			f.Properties["PlusCode"] = plus
+		}
+		if words != "" {
+			f.Properties["What3Words"] = words
+		}
+		if di := a.deviceInfo(storage.DeviceName(v)); di != (storage.DeviceInfo{}) {
+			f.Properties["Label"] = di.Label
+			f.Properties["Icon"] = di.Icon
+			f.Properties["Color"] = di.Color
+			f.Properties["Group"] = di.Group
+		}
+		f.Geometry.Type = "Point"
+		f.Geometry.Coordinates = make([]float64, 2)
+		f.Geometry.Coordinates[0] = v.Longitude
+		f.Geometry.Coordinates[1] = v.Latitude
+		fc.Features = append(fc.Features, f)
+	}
+	writeEncoded(w, r, fc)
+}
+
+// LocationCodes returns the Plus Code and the what3words address of the
+// coordinates lat and lon, which are easier to pass on by phone. They are
+// empty if disabled. A failed what3words lookup is logged.
+func (a *API) LocationCodes(lat, lon float64) (plus, words string) {
+	if a.PlusCodes {
+		plus = geo.PlusCode(lat, lon)
+	}
+	if a.What3Words != nil {
+		var err error
+		if words, err = a.What3Words.Words(lat, lon); err != nil {
+			a.Logger.Println(err)
+		}
+	}
+	return plus, words
+}
+
+// visible reports whether the positions of user are shown to the owner of
+// prefs.
+func visible(prefs storage.Preferences, user string) bool {
+	if len(prefs.VisibleUsers) == 0 {
+		return true
+	}
+	for _, u := range prefs.VisibleUsers {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// serverError logs err and reports an internal error to the client, without
+// the details of err.
+func (a *API) serverError(w http.ResponseWriter, err error) {
+	a.Logger.Println(err)
+	Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// Error replies to the request with the error message msg and the status
+// code as JSON, like http.Error does as plain text.
+func Error(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	}{code, msg})
+}
+
+// Binary encodings that clients may request with the Accept header.
+const (
+	contentTypeMsgpack  = "application/msgpack"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// accepts reports whether the Accept header of r lists contentType.
+func accepts(r *http.Request, contentType string) bool {
+	for _, h := range r.Header["Accept"] {
+		for _, t := range strings.Split(h, ",") {
+			if i := strings.Index(t, ";"); i >= 0 {
+				t = t[:i]
+			}
+			t = strings.TrimSpace(t)
+			if t == contentType || (contentType == contentTypeMsgpack && t == "application/x-msgpack") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeEncoded sends v MessagePack-encoded to w if the client accepts it,
+// and JSON-encoded otherwise.
+func writeEncoded(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if !accepts(r, contentTypeMsgpack) {
+		writeJSON(w, v)
+		return
+	}
+	b, err := marshalMsgpack(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeMsgpack)
+	w.Write(b)
+}
+
+// writeJSON sends v JSON-encoded to w.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus sends v JSON-encoded to w with the status code.
+func writeJSONStatus(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
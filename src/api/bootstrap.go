@@ -0,0 +1,88 @@
+package api
+
+import (
+	"hash/fnv"
+	"net/http"
+
+	"auth"
+	"storage"
+)
+
+// deviceColors are given to devices without a color of their own, picked by
+// their name, so that a device keeps its color across page loads.
+var deviceColors = []string{"#3388ff", "#e41a1c", "#4daf4a", "#984ea3", "#ff7f00", "#a65628", "#f781bf"}
+
+// Bootstrap is everything the map page needs to start, see API.Bootstrap.
+type Bootstrap struct {
+	// User is the authenticated user, it is empty without authentication.
+	User        string              `json:"user"`
+	Preferences storage.Preferences `json:"preferences"`
+	// Users are the users shown on the map.
+	Users   []string          `json:"users"`
+	Devices []BootstrapDevice `json:"devices"`
+	// Shares are the active live shares of the user.
+	Shares       []Share      `json:"shares"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// BootstrapDevice is a device shown on the map with its last position.
+// Color is always set, devices without a color get one of deviceColors.
+type BootstrapDevice struct {
+	DeviceResource
+	Position livePosition `json:"position"`
+}
+
+// Bootstrap serves GET /api/bootstrap, which returns the current user, the
+// preferences, the visible users and their devices with their last
+// positions, the active shares and the capabilities of the server in one
+// response, instead of one request each. Like Positions, only the users in
+// the VisibleUsers preference are included, if it is set, archived devices
+// are left out and times are given in the preferred time zone.
+func (a *API) Bootstrap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	devices, err := a.Store.Devices()
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	user := auth.User(r)
+	prefs := a.preferences(r)
+	loc := a.location(r)
+	b := Bootstrap{
+		User:         user,
+		Preferences:  prefs,
+		Users:        []string{},
+		Devices:      []BootstrapDevice{},
+		Shares:       a.ownShares(user),
+		Capabilities: a.capabilities(),
+	}
+	for _, d := range devices {
+		if !visible(prefs, d.User) || a.deviceInfo(d.Name()).Archived {
+			continue
+		}
+		if len(b.Users) == 0 || b.Users[len(b.Users)-1] != d.User {
+			b.Users = append(b.Users, d.User)
+		}
+		t := d.Last.T.In(loc)
+		bd := BootstrapDevice{
+			DeviceResource: a.deviceResource(d.User, d.TrackerID, &t),
+			Position:       livePosition{t, d.Last.Latitude, d.Last.Longitude, d.Last.Accuracy, d.Last.Velocity},
+		}
+		if bd.Color == "" {
+			bd.Color = defaultColor(bd.Name)
+		}
+		b.Devices = append(b.Devices, bd)
+	}
+	writeJSON(w, b)
+}
+
+// defaultColor returns the color of the device name if it has none.
+func defaultColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return deviceColors[h.Sum32()%uint32(len(deviceColors))]
+}
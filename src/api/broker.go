@@ -0,0 +1,71 @@
+package api
+
+import (
+	"sync"
+
+	"owntracks"
+	"storage"
+)
+
+// Broker passes accepted positions on to the handlers that stream them to
+// clients.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[chan owntracks.LocationUpdate]string
+	closed bool
+}
+
+// NewBroker returns a Broker without subscribers.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan owntracks.LocationUpdate]string)}
+}
+
+// Subscribe returns a channel receiving the positions of the device with the
+// given name, or of all devices if name is empty. The channel is closed when
+// cancel is called or the Broker is closed. Positions are dropped if the
+// subscriber does not keep up.
+func (b *Broker) Subscribe(name string) (updates <-chan owntracks.LocationUpdate, cancel func()) {
+	c := make(chan owntracks.LocationUpdate, 16)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(c)
+		return c, func() {}
+	}
+	b.subs[c] = name
+	return c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[c]; ok {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+}
+
+// Publish sends lu to all subscribers of its device.
+func (b *Broker) Publish(lu owntracks.LocationUpdate) {
+	name := storage.DeviceName(lu)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c, n := range b.subs {
+		if n != "" && n != name {
+			continue
+		}
+		select {
+		case c <- lu:
+		default:
+		}
+	}
+}
+
+// Close ends all subscriptions.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for c := range b.subs {
+		delete(b.subs, c)
+		close(c)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+
+	"publish"
+)
+
+// Version is the version of the API. It is increased on changes that break
+// existing clients, additions keep it.
+const Version = 1
+
+// Capabilities tells clients which features the server has enabled, see
+// API.Capabilities.
+type Capabilities struct {
+	Version int `json:"version"`
+	// Protocols are the enabled ingest protocols.
+	Protocols []string `json:"protocols"`
+	// MQTT tells whether devices can send their positions over MQTT.
+	MQTT bool `json:"mqtt"`
+	// Geofences tells whether users can define places, which report the
+	// visits of their devices.
+	Geofences bool `json:"geofences"`
+	// ExportFormats are the formats of /api/exports, it is empty if export
+	// jobs are disabled.
+	ExportFormats []string `json:"exportFormats"`
+	// Live are the transports of the live feeds, it is empty if live
+	// shares are disabled.
+	Live []string `json:"live"`
+	// Features tells which of the further optional features are enabled.
+	Features map[string]bool `json:"features"`
+}
+
+// Capabilities serves GET /api/capabilities, which returns the
+// capabilities of the server, so that other frontends and apps can adapt
+// to it without a copy of its config.
+func (a *API) Capabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, a.capabilities())
+}
+
+func (a *API) capabilities() Capabilities {
+	c := Capabilities{
+		Version:       Version,
+		Protocols:     append([]string{}, a.Protocols...),
+		MQTT:          a.MQTT,
+		Geofences:     a.PlaceStore != nil,
+		ExportFormats: []string{},
+		Live:          []string{},
+		Features: map[string]bool{
+			"mapMatching": a.Matcher != nil,
+			"geocoding":   a.Geocoder != nil,
+			"plusCodes":   a.PlusCodes,
+			"what3words":  a.What3Words != nil,
+			"speeding":    a.SpeedLimits != nil,
+			"preferences": a.Preferences != nil,
+			"deviceInfos": a.DeviceInfos != nil,
+			"pois":        a.POIStore != nil,
+			"deviceSetup": a.DeviceSetup != nil,
+			"deviceStats": a.Stats != nil,
+		},
+	}
+	if a.Exports != nil {
+		c.ExportFormats = publish.Formats()
+	}
+	if a.Broker != nil {
+		c.Live = append(c.Live, "sse")
+	}
+	return c
+}
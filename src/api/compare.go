@@ -0,0 +1,180 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+// compareStep is the interval at which the tracks are aligned.
+const compareStep = time.Minute
+
+// compareMaxGap is the longest time without positions over which a track is
+// interpolated. A user has no position in longer gaps.
+const compareMaxGap = 15 * time.Minute
+
+// Meeting is a time span in which the compared users were close to each
+// other. If they moved together, Path holds the way they went.
+type Meeting struct {
+	Start     time.Time    `json:"start"`
+	End       time.Time    `json:"end"`
+	Latitude  float64      `json:"latitude"`
+	Longitude float64      `json:"longitude"`
+	Path      [][2]float64 `json:"path,omitempty"`
+}
+
+// Comparison is the response of Compare.
+type Comparison struct {
+	Users  []string      `json:"users"`
+	Tracks []LineFeature `json:"tracks"`
+	// Times are the instants at which the tracks are aligned, Positions
+	// holds the [longitude, latitude] of every user at these times, or null
+	// if the position of a user is unknown.
+	Times     []time.Time              `json:"times"`
+	Positions map[string][]*[2]float64 `json:"positions"`
+	Meetings  []Meeting                `json:"meetings"`
+	Shared    []Meeting                `json:"shared"`
+}
+
+// Compare returns the tracks of two users on the day given by the parameter
+// date (YYYY-MM-DD in the preferred time zone, default today), aligned to a
+// common time grid. Times at which the users were closer than radius meters
+// (default 100) are reported as meetings if they stayed in place and as
+// shared segments if they moved together.
+func (a *API) Compare(w http.ResponseWriter, r *http.Request) {
+	users := strings.Split(r.FormValue("users"), ",")
+	if len(users) != 2 || users[0] == "" || users[1] == "" {
+		http.Error(w, "users must name two users, e.g. users=a,b", http.StatusBadRequest)
+		return
+	}
+	loc := a.location(r)
+	day := time.Now().In(loc)
+	if v := r.FormValue("date"); v != "" {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+			http.Error(w, fmt.Sprintf("invalid date: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 1)
+	radius := 100.0
+	if v := r.FormValue("radius"); v != "" {
+		var err error
+		if radius, err = strconv.ParseFloat(v, 64); err != nil || radius <= 0 {
+			http.Error(w, fmt.Sprintf("invalid radius: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c := Comparison{
+		Users:     users,
+		Tracks:    []LineFeature{},
+		Positions: make(map[string][]*[2]float64),
+		Meetings:  []Meeting{},
+		Shared:    []Meeting{},
+	}
+	for t := from; t.Before(to); t = t.Add(compareStep) {
+		c.Times = append(c.Times, t)
+	}
+	aligned := make([][]*owntracks.LocationUpdate, len(users))
+	for i, u := range users {
+		t, err := a.Store.QueryPositions(storage.Query{User: u, From: from, To: to})
+		if err != nil {
+			a.serverError(w, err)
+			return
+		}
+		// the positions of all devices of the user form one track
+		sort.SliceStable(t, func(i, j int) bool { return t[i].T.Before(t[j].T) })
+		if len(t) > 0 {
+			c.Tracks = append(c.Tracks, lineFeature(t, loc))
+		}
+		aligned[i] = align(t, c.Times, compareMaxGap)
+		pos := make([]*[2]float64, len(c.Times))
+		for j, lu := range aligned[i] {
+			if lu != nil {
+				pos[j] = &[2]float64{lu.Longitude, lu.Latitude}
+			}
+		}
+		c.Positions[u] = pos
+	}
+
+	for _, m := range meetings(c.Times, aligned[0], aligned[1], radius) {
+		if m.Path == nil {
+			c.Meetings = append(c.Meetings, m)
+		} else {
+			c.Shared = append(c.Shared, m)
+		}
+	}
+	writeEncoded(w, r, c)
+}
+
+// align interpolates the positions of track t at the given times, both must
+// be sorted by time. The result is nil at times before the first or after
+// the last position and within gaps longer than maxGap.
+func align(t []owntracks.LocationUpdate, times []time.Time, maxGap time.Duration) []*owntracks.LocationUpdate {
+	res := make([]*owntracks.LocationUpdate, len(times))
+	j := 0
+	for i, at := range times {
+		for j < len(t) && t[j].T.Before(at) {
+			j++
+		}
+		switch {
+		case j < len(t) && t[j].T.Equal(at):
+			lu := t[j]
+			res[i] = &lu
+		case j == 0 || j == len(t):
+		case t[j].T.Sub(t[j-1].T) <= maxGap:
+			lu := interpolate(t[j-1], t[j], at)
+			res[i] = &lu
+		}
+	}
+	return res
+}
+
+// meetings finds the spans in which the aligned positions a and b are closer
+// than radius. A span in which the users covered more than twice radius is a
+// shared segment and gets its Path.
+func meetings(times []time.Time, a, b []*owntracks.LocationUpdate, radius float64) []Meeting {
+	var res []Meeting
+	var span []owntracks.LocationUpdate // midpoints of the current span
+	flush := func() {
+		if len(span) == 0 {
+			return
+		}
+		m := Meeting{Start: span[0].T, End: span[len(span)-1].T}
+		var moved float64
+		for i, p := range span {
+			m.Latitude += p.Latitude / float64(len(span))
+			m.Longitude += p.Longitude / float64(len(span))
+			if i > 0 {
+				moved += distance(span[i-1], p)
+			}
+		}
+		if moved > 2*radius {
+			for _, p := range span {
+				m.Path = append(m.Path, [2]float64{p.Longitude, p.Latitude})
+			}
+		}
+		res = append(res, m)
+		span = nil
+	}
+	for i := range times {
+		if a[i] == nil || b[i] == nil || distance(*a[i], *b[i]) > radius {
+			flush()
+			continue
+		}
+		mid := *a[i]
+		mid.Latitude = (a[i].Latitude + b[i].Latitude) / 2
+		mid.Longitude = (a[i].Longitude + b[i].Longitude) / 2
+		span = append(span, mid)
+	}
+	flush()
+	return res
+}
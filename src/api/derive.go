@@ -0,0 +1,43 @@
+package api
+
+import (
+	"math"
+
+	"owntracks"
+)
+
+// DerivedValues holds values computed for every point of a track, in the
+// order of its coordinates. They are nil for the first point, which has no
+// predecessor.
+type DerivedValues struct {
+	// SpeedKmh is the average speed since the previous point, or the speed
+	// reported by the device if both have the same time.
+	SpeedKmh []*float64 `json:"speed_kmh"`
+	// DistanceFromPrevM is the distance to the previous point in meters.
+	DistanceFromPrevM []*float64 `json:"distance_from_prev_m"`
+	// TimeFromPrevS is the time since the previous point in seconds.
+	TimeFromPrevS []*float64 `json:"time_from_prev_s"`
+}
+
+// deriveValues computes the DerivedValues of the points of track t.
+func deriveValues(t []owntracks.LocationUpdate) DerivedValues {
+	d := DerivedValues{
+		SpeedKmh:          make([]*float64, len(t)),
+		DistanceFromPrevM: make([]*float64, len(t)),
+		TimeFromPrevS:     make([]*float64, len(t)),
+	}
+	round := func(v float64) *float64 {
+		v = math.Round(v*100) / 100
+		return &v
+	}
+	for i := 1; i < len(t); i++ {
+		dist := distance(t[i-1], t[i])
+		dt := t[i].T.Sub(t[i-1].T).Seconds()
+		speed := float64(t[i].Velocity)
+		if dt > 0 {
+			speed = dist / dt * 3.6
+		}
+		d.SpeedKmh[i], d.DistanceFromPrevM[i], d.TimeFromPrevS[i] = round(speed), round(dist), round(dt)
+	}
+	return d
+}
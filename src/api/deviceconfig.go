@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/url"
+
+	"auth"
+	"middleware"
+	"owntracks"
+	"qr"
+)
+
+// qrScale is the size of the modules of the QR codes in pixels.
+const qrScale = 6
+
+// DeviceSetup holds what the tracking apps of new devices need to send
+// their positions to daisser.
+type DeviceSetup struct {
+	// MQTTHost and MQTTPort are the broker the OwnTracks app publishes to,
+	// as reachable from the phones. OwnTracks configurations are not
+	// available if MQTTHost is empty.
+	MQTTHost string
+	MQTTPort uint16
+	// MQTTTLS makes the app connect to the broker with TLS.
+	MQTTTLS bool
+	// URL is the base URL of daisser as reachable from the phones, e.g.
+	// "https://example.com/daisser". It defaults to the host and the URL
+	// base of the request.
+	URL string
+	// OsmAndIDs maps the device names "<user>/<tracker>" to the ids they
+	// send with the osmand protocol.
+	OsmAndIDs map[string]string `json:"-"`
+}
+
+// baseURL returns the URL of daisser as seen by the client of r.
+func (s *DeviceSetup) baseURL(r *http.Request) string {
+	if s.URL != "" {
+		return s.URL
+	}
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host + middleware.Base(r)
+}
+
+// otrc is an OwnTracks configuration file, which the app imports when it
+// is opened.
+type otrc struct {
+	Type     string `json:"_type"`
+	Mode     int    `json:"mode"`
+	Host     string `json:"host"`
+	Port     uint16 `json:"port"`
+	TLS      bool   `json:"tls"`
+	Auth     bool   `json:"auth"`
+	Username string `json:"username"`
+	DeviceID string `json:"deviceId"`
+	ClientID string `json:"clientId"`
+}
+
+// DeviceConfig serves /api/devices/<user>/<tracker>/config, the settings a
+// new phone imports instead of typing them. By default it is an OwnTracks
+// .otrc file for the MQTT mode, the password of the broker is left to the
+// user. With app=osmand it is the online tracking URL for OsmAnd.
+//
+// Users only get the settings of their own devices.
+func (a *API) DeviceConfig(w http.ResponseWriter, r *http.Request, user, tracker string) {
+	app, b, ok := a.deviceConfig(w, r, user, tracker)
+	if !ok {
+		return
+	}
+	if app == "osmand" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", tracker+".otrc"))
+	}
+	w.Write(b)
+}
+
+// DeviceQR serves /api/devices/<user>/<tracker>/qr, the configuration of
+// DeviceConfig as PNG image of a QR code for scanning with the phone. The
+// OwnTracks configuration is encoded as owntracks:///config URL, which the
+// app imports when it is opened.
+func (a *API) DeviceQR(w http.ResponseWriter, r *http.Request, user, tracker string) {
+	app, b, ok := a.deviceConfig(w, r, user, tracker)
+	if !ok {
+		return
+	}
+	if app == "owntracks" {
+		// keep the QR code small
+		var c bytes.Buffer
+		json.Compact(&c, b)
+		b = []byte("owntracks:///config?inline=" + url.QueryEscape(base64.StdEncoding.EncodeToString(c.Bytes())))
+	}
+	code, err := qr.Encode(bytes.TrimSpace(b))
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, code.Image(qrScale))
+}
+
+// deviceConfig returns the configuration of the device for the app of the
+// parameter app. If it is not ok, the error has been sent to w.
+func (a *API) deviceConfig(w http.ResponseWriter, r *http.Request, user, tracker string) (app string, b []byte, ok bool) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", nil, false
+	}
+	if u := auth.User(r); u != "" && u != user {
+		a.NotFound(w, r)
+		return "", nil, false
+	}
+	if a.DeviceSetup == nil {
+		http.Error(w, "Device configurations are not available", http.StatusNotImplemented)
+		return "", nil, false
+	}
+	s := a.DeviceSetup
+	switch app = r.FormValue("app"); app {
+	case "", "owntracks":
+		if s.MQTTHost == "" {
+			http.Error(w, "OwnTracks configurations are not available", http.StatusNotImplemented)
+			return "", nil, false
+		}
+		c := otrc{
+			Type:     "configuration",
+			Host:     s.MQTTHost,
+			Port:     s.MQTTPort,
+			TLS:      s.MQTTTLS,
+			Auth:     true,
+			Username: user,
+			DeviceID: tracker,
+			ClientID: user + "-" + tracker,
+		}
+		if c.Port == 0 {
+			c.Port = owntracks.DefaultPort
+		}
+		b, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			a.serverError(w, err)
+			return "", nil, false
+		}
+		return "owntracks", b, true
+	case "osmand":
+		id, ok := s.OsmAndIDs[user+"/"+tracker]
+		if !ok {
+			http.Error(w, "The device has no id for the osmand protocol", http.StatusNotFound)
+			return "", nil, false
+		}
+		u := fmt.Sprintf("%s/ingest/osmand?id=%s&lat={0}&lon={1}&timestamp={2}&accuracy={3}&altitude={4}&speed={5}&bearing={6}\n",
+			s.baseURL(r), url.QueryEscape(id))
+		return app, []byte(u), true
+	default:
+		http.Error(w, fmt.Sprintf("invalid app: %q, must be owntracks or osmand", app), http.StatusBadRequest)
+		return "", nil, false
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth"
+	"storage"
+)
+
+// DeviceResource is a device as returned by the devices endpoints.
+type DeviceResource struct {
+	Name    string `json:"name"`
+	User    string `json:"user"`
+	Tracker string `json:"tracker"`
+	// Last is the time of the latest position, it is absent for devices that
+	// did not send any positions yet.
+	Last *time.Time `json:"last,omitempty"`
+	storage.DeviceInfo
+}
+
+// deviceInfo returns the info of the device with the given name.
+func (a *API) deviceInfo(name string) storage.DeviceInfo {
+	if a.DeviceInfos == nil {
+		return storage.DeviceInfo{}
+	}
+	return a.DeviceInfos.Get(name)
+}
+
+// Devices lists all devices that sent positions together with their labels,
+// icons, colors, groups and archived flags.
+func (a *API) Devices(w http.ResponseWriter, r *http.Request) {
+	devices, err := a.Store.Devices()
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	resp := []DeviceResource{}
+	for _, d := range devices {
+		t := d.Last.T
+		resp = append(resp, a.deviceResource(d.User, d.TrackerID, &t))
+	}
+	writeJSON(w, resp)
+}
+
+func (a *API) deviceResource(user, tracker string, last *time.Time) DeviceResource {
+	name := user + "/" + tracker
+	return DeviceResource{
+		Name:       name,
+		User:       user,
+		Tracker:    tracker,
+		Last:       last,
+		DeviceInfo: a.deviceInfo(name),
+	}
+}
+
+// Device serves /api/devices/<user>/<tracker>: GET returns the device, PUT
+// replaces its info with the JSON body and DELETE resets the info. Only
+// the user of the device may PUT and DELETE. The positions of the device
+// are never changed. The configuration of the
+// device is served by DeviceConfig and DeviceQR, its stats by DeviceStats.
+func (a *API) Device(w http.ResponseWriter, r *http.Request) {
+	user, tracker, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/devices/"), "/")
+	if t, sub, ok := strings.Cut(tracker, "/"); ok && user != "" && t != "" {
+		switch sub {
+		case "config":
+			a.DeviceConfig(w, r, user, t)
+			return
+		case "qr":
+			a.DeviceQR(w, r, user, t)
+			return
+		case "stats":
+			a.DeviceStats(w, r, user, t)
+			return
+		}
+	}
+	if !ok || user == "" || tracker == "" || strings.Contains(tracker, "/") {
+		a.NotFound(w, r)
+		return
+	}
+	var last *time.Time
+	devices, err := a.Store.Devices()
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	for _, d := range devices {
+		if d.User == user && d.TrackerID == tracker {
+			t := d.Last.T
+			last = &t
+		}
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		if last == nil {
+			a.NotFound(w, r)
+			return
+		}
+	case "PUT", "DELETE":
+		// like the list, GET shows the devices of all users, but only their
+		// own user may change them
+		if u := auth.User(r); u != "" && u != user {
+			a.NotFound(w, r)
+			return
+		}
+		if a.DeviceInfos == nil {
+			http.Error(w, "Device settings are not available", http.StatusNotImplemented)
+			return
+		}
+		name := user + "/" + tracker
+		if r.Method == "DELETE" {
+			err = a.DeviceInfos.Delete(name)
+		} else {
+			var di storage.DeviceInfo
+			if err := json.NewDecoder(r.Body).Decode(&di); err != nil {
+				http.Error(w, "Bad device: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = a.DeviceInfos.Set(name, di)
+		}
+		if err != nil {
+			a.serverError(w, err)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, a.deviceResource(user, tracker, last))
+}
+
+// DeviceStats serves /api/devices/<user>/<tracker>/stats, the counters of
+// the positions the device sent: how many were accepted today and in
+// total, the average time between them, and how many were rejected, with
+// the reason of the last rejection.
+func (a *API) DeviceStats(w http.ResponseWriter, r *http.Request, user, tracker string) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if u := auth.User(r); u != "" && u != user {
+		a.NotFound(w, r)
+		return
+	}
+	if a.Stats == nil {
+		http.Error(w, "Device stats are not available", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, a.Stats.Get(user+"/"+tracker, time.Now()))
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth"
+	"geo"
+	"storage"
+)
+
+// maxDistanceEndpoints is the largest number of endpoints on each side of
+// a distance matrix.
+const maxDistanceEndpoints = 25
+
+// Endpoint is a point a distance is measured from or to. Time is the time
+// of the position of users and devices, and absent for places.
+type Endpoint struct {
+	ID        string     `json:"id"`
+	Latitude  float64    `json:"latitude"`
+	Longitude float64    `json:"longitude"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+// DistanceMatrix holds the distances from every endpoint of From, the rows,
+// to every endpoint of To, the columns. Distances are great-circle
+// distances in [m]. RoadDistances in [m] and Durations in [s] follow the
+// road network, they are only set if requested and nil for pairs without a
+// route.
+type DistanceMatrix struct {
+	From          []Endpoint   `json:"from"`
+	To            []Endpoint   `json:"to"`
+	Distances     [][]float64  `json:"distances"`
+	RoadDistances [][]*float64 `json:"roadDistances,omitempty"`
+	Durations     [][]*float64 `json:"durations,omitempty"`
+}
+
+// Distance serves GET /api/distance, the distances between the current
+// positions of users and devices and the places of the authenticated user,
+// e.g. for showing how far everyone is from home. The parameters from and
+// to list the endpoints, separated by "|" or repeated, each one of
+//
+//	user:NAME              the latest position of any device of the user
+//	device:USER/TRACKER    the latest position of the device
+//	place:NAME             the place with that name
+//
+// With routed=true, the distances and travel times on the road network are
+// added, computed by the configured OSRM server.
+func (a *API) Distance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	routed := r.FormValue("routed") == "true"
+	if routed && a.Matcher == nil {
+		http.Error(w, "Routed distances are not available", http.StatusNotImplemented)
+		return
+	}
+	devices, err := a.Store.Devices()
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	var m DistanceMatrix
+	for _, side := range []struct {
+		param string
+		l     *[]Endpoint
+	}{{"from", &m.From}, {"to", &m.To}} {
+		var ids []string
+		for _, v := range r.Form[side.param] {
+			ids = append(ids, strings.Split(v, "|")...)
+		}
+		if len(ids) == 0 || len(ids) > maxDistanceEndpoints {
+			http.Error(w, fmt.Sprintf("%s needs 1 to %d endpoints", side.param, maxDistanceEndpoints), http.StatusBadRequest)
+			return
+		}
+		for _, id := range ids {
+			e, err := a.endpoint(r, id, devices)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*side.l = append(*side.l, e)
+		}
+	}
+	m.Distances = make([][]float64, len(m.From))
+	for i, f := range m.From {
+		m.Distances[i] = make([]float64, len(m.To))
+		for j, t := range m.To {
+			m.Distances[i][j] = geo.Distance(f.Latitude, f.Longitude, t.Latitude, t.Longitude)
+		}
+	}
+	if routed {
+		m.RoadDistances, m.Durations, err = a.Matcher.Table(coordinates(m.From), coordinates(m.To))
+		if err != nil {
+			a.Logger.Println(err)
+			http.Error(w, "Routing failed", http.StatusBadGateway)
+			return
+		}
+	}
+	writeJSON(w, m)
+}
+
+// endpoint returns the Endpoint given by id for the request r, with the
+// positions of devices. Users must be visible to the user of r, places
+// must belong to them.
+func (a *API) endpoint(r *http.Request, id string, devices []storage.Device) (Endpoint, error) {
+	kind, name, _ := strings.Cut(id, ":")
+	e := Endpoint{ID: id}
+	switch kind {
+	case "user", "device":
+		user, tracker := name, ""
+		if kind == "device" {
+			var ok bool
+			if user, tracker, ok = strings.Cut(name, "/"); !ok {
+				return e, fmt.Errorf("invalid device %q", name)
+			}
+		}
+		if !visible(a.preferences(r), user) {
+			return e, fmt.Errorf("unknown %s %q", kind, name)
+		}
+		for _, d := range devices {
+			if d.User == user && (tracker == "" || d.TrackerID == tracker) && (e.Time == nil || d.Last.T.After(*e.Time)) {
+				t := d.Last.T.In(a.location(r))
+				e.Latitude, e.Longitude, e.Time = d.Last.Latitude, d.Last.Longitude, &t
+			}
+		}
+		if e.Time == nil {
+			return e, fmt.Errorf("unknown %s %q", kind, name)
+		}
+	case "place":
+		if a.PlaceStore == nil {
+			return e, fmt.Errorf("places are not available")
+		}
+		found := false
+		for _, p := range a.PlaceStore.List(auth.User(r)) {
+			if p.Name == name {
+				e.Latitude, e.Longitude, found = p.Latitude, p.Longitude, true
+				break
+			}
+		}
+		if !found {
+			return e, fmt.Errorf("unknown place %q", name)
+		}
+	default:
+		return e, fmt.Errorf("invalid endpoint %q, must be user:, device: or place:", id)
+	}
+	return e, nil
+}
+
+// coordinates returns the longitude and latitude of every endpoint of l.
+func coordinates(l []Endpoint) [][2]float64 {
+	c := make([][2]float64, len(l))
+	for i, e := range l {
+		c[i] = [2]float64{e.Longitude, e.Latitude}
+	}
+	return c
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"geo"
+	"owntracks"
+	"storage"
+)
+
+// Thresholds of harsh driving events in [m/s²], common values of fleet
+// telematics.
+const (
+	harshAcceleration = 3.0
+	harshBraking      = 3.5
+	harshCornering    = 3.0
+)
+
+const (
+	// drivingMaxStep is the longest time between two positions that are
+	// compared, longer steps average out any harsh maneuver.
+	drivingMaxStep = 10 * time.Second
+	// drivingMinCorneringSpeed is the speed in [km/h] below which heading
+	// changes are not counted as cornering, e.g. when parking.
+	drivingMinCorneringSpeed = 15
+	// drivingPenalty is the number of points an event per 100 km costs.
+	drivingPenalty = 5
+	// drivingMinDistance is the distance in [m] the events are related to
+	// at least, so that a single event on a short drive is not fatal.
+	drivingMinDistance = 10000
+)
+
+// Kinds of DrivingEvents.
+const (
+	EventAcceleration = "acceleration"
+	EventBraking      = "braking"
+	EventCornering    = "cornering"
+)
+
+// DrivingEvent is a harsh maneuver. Value is the acceleration in [m/s²],
+// Speed is in [km/h].
+type DrivingEvent struct {
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Value     float64   `json:"value"`
+	Speed     int       `json:"speed"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+}
+
+// DrivingTrack holds the events of one track. Distance is in [m].
+type DrivingTrack struct {
+	Tracker  string         `json:"tracker"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Distance float64        `json:"distance"`
+	Events   []DrivingEvent `json:"events"`
+}
+
+// DriverScore rates the driving of a user. Score starts at 100 and loses
+// drivingPenalty points per event and 100 km. Distance is in [m].
+type DriverScore struct {
+	User     string         `json:"user"`
+	Distance float64        `json:"distance"`
+	Score    float64        `json:"score"`
+	Counts   map[string]int `json:"counts"`
+	Tracks   []DrivingTrack `json:"tracks"`
+}
+
+// Driving finds harsh acceleration, braking and cornering in the tracks
+// selected by the parameters user, tracker, from and to, from the changes of
+// speed and heading between positions, and scores every user.
+func (a *API) Driving(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tracks, err := storage.Tracks(a.Store, q)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	loc := a.location(r)
+	scores := make(map[string]*DriverScore)
+	for _, t := range tracks {
+		s, ok := scores[t[0].User]
+		if !ok {
+			s = &DriverScore{User: t[0].User, Counts: make(map[string]int), Tracks: []DrivingTrack{}}
+			scores[t[0].User] = s
+		}
+		dt := DrivingTrack{
+			Tracker: t[0].TrackerID,
+			Start:   t[0].T.In(loc),
+			End:     t[len(t)-1].T.In(loc),
+			Events:  drivingEvents(t),
+		}
+		for i := 1; i < len(t); i++ {
+			dt.Distance += distance(t[i-1], t[i])
+		}
+		for i := range dt.Events {
+			dt.Events[i].Time = dt.Events[i].Time.In(loc)
+			s.Counts[dt.Events[i].Kind]++
+		}
+		s.Distance += dt.Distance
+		s.Tracks = append(s.Tracks, dt)
+	}
+	res := []DriverScore{}
+	for _, s := range scores {
+		events := 0
+		for _, n := range s.Counts {
+			events += n
+		}
+		per100km := math.Max(s.Distance, drivingMinDistance) / 100000
+		s.Score = math.Max(0, 100-drivingPenalty*float64(events)/per100km)
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].User < res[j].User })
+	writeEncoded(w, r, res)
+}
+
+// drivingEvents returns the harsh maneuvers between the positions of track
+// t. Consecutive steps above a threshold form a single event with the
+// largest value.
+func drivingEvents(t []owntracks.LocationUpdate) []DrivingEvent {
+	events := []DrivingEvent{}
+	var last *DrivingEvent
+	for i := 1; i < len(t); i++ {
+		a, b := t[i-1], t[i]
+		step := b.T.Sub(a.T)
+		if step <= 0 || step > drivingMaxStep {
+			last = nil
+			continue
+		}
+		sec := step.Seconds()
+		accel := float64(b.Velocity-a.Velocity) / 3.6 / sec
+		var e DrivingEvent
+		switch {
+		case accel >= harshAcceleration:
+			e = DrivingEvent{Kind: EventAcceleration, Value: accel}
+		case -accel >= harshBraking:
+			e = DrivingEvent{Kind: EventBraking, Value: -accel}
+		default:
+			// the lateral acceleration is the speed times the turn rate
+			speed := float64(a.Velocity+b.Velocity) / 2
+			turn := headingChange(courseOf(t, i-1), courseOf(t, i)) * math.Pi / 180 / sec
+			if lateral := speed / 3.6 * turn; speed >= drivingMinCorneringSpeed && lateral >= harshCornering {
+				e = DrivingEvent{Kind: EventCornering, Value: lateral}
+			}
+		}
+		if e.Kind == "" {
+			last = nil
+			continue
+		}
+		if last != nil && last.Kind == e.Kind {
+			if e.Value > last.Value {
+				last.Value = e.Value
+			}
+			continue
+		}
+		e.Time, e.Speed, e.Latitude, e.Longitude = b.T, b.Velocity, b.Latitude, b.Longitude
+		events = append(events, e)
+		last = &events[len(events)-1]
+	}
+	for i := range events {
+		events[i].Value = math.Round(events[i].Value*100) / 100
+	}
+	return events
+}
+
+// courseOf returns the heading of the i-th position of t, as reported by the
+// tracker or else towards the next position.
+func courseOf(t []owntracks.LocationUpdate, i int) float64 {
+	if t[i].Course > 0 {
+		return float64(t[i].Course)
+	}
+	a, b := t[i], t[i]
+	if i+1 < len(t) {
+		b = t[i+1]
+	} else if i > 0 {
+		a = t[i-1]
+	}
+	return geo.Bearing(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+}
+
+// headingChange returns the absolute difference of two headings in degrees.
+func headingChange(h1, h2 float64) float64 {
+	d := math.Mod(math.Abs(h2-h1), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
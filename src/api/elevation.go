@@ -0,0 +1,79 @@
+package api
+
+import (
+	"math"
+
+	"owntracks"
+)
+
+const (
+	// elevationWindow is the number of points the altitudes are averaged
+	// over before the ascent is computed, to even out GPS noise.
+	elevationWindow = 5
+	// elevationThreshold is the change of the smoothed altitude in [m]
+	// that counts as ascent or descent. Smaller wiggles are ignored.
+	elevationThreshold = 3
+	// gradeMinDistance is the horizontal distance in [m] a grade is
+	// measured over at least, short distances yield absurd grades.
+	gradeMinDistance = 50
+)
+
+// ElevationStats sums up the altitude profile of a track.
+type ElevationStats struct {
+	// Ascent and Descent are the cumulated altitude gain and loss in [m].
+	Ascent  int `json:"ascent"`
+	Descent int `json:"descent"`
+	// MaxGrade is the steepest grade in percent, uphill or downhill.
+	MaxGrade float64 `json:"maxGrade"`
+}
+
+// elevationStats computes the ElevationStats of the track t from the
+// smoothed altitudes of its points.
+func elevationStats(t []owntracks.LocationUpdate) ElevationStats {
+	var es ElevationStats
+	if len(t) < 2 {
+		return es
+	}
+	alt := make([]float64, len(t))
+	for i := range t {
+		lo, hi := i-elevationWindow/2, i+elevationWindow/2+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(t) {
+			hi = len(t)
+		}
+		for _, lu := range t[lo:hi] {
+			alt[i] += float64(lu.Altitude)
+		}
+		alt[i] /= float64(hi - lo)
+	}
+
+	var ascent, descent float64
+	ref := alt[0]
+	for _, a := range alt[1:] {
+		switch d := a - ref; {
+		case d >= elevationThreshold:
+			ascent += d
+			ref = a
+		case d <= -elevationThreshold:
+			descent -= d
+			ref = a
+		}
+	}
+	es.Ascent = int(math.Round(ascent))
+	es.Descent = int(math.Round(descent))
+
+	// grades over stretches of at least gradeMinDistance
+	start, dist, maxGrade := 0, 0.0, 0.0
+	for i := 1; i < len(t); i++ {
+		dist += distance(t[i-1], t[i])
+		for dist >= gradeMinDistance {
+			maxGrade = math.Max(maxGrade, math.Abs(alt[i]-alt[start])/dist*100)
+			dist -= distance(t[start], t[start+1])
+			start++
+		}
+	}
+	es.MaxGrade = math.Round(maxGrade*10) / 10
+	return es
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"auth"
+	"middleware"
+	"publish"
+	"storage"
+)
+
+const (
+	// exportTTL is how long the file of a finished export job and its
+	// download link are kept.
+	exportTTL = time.Hour
+	// exportWorkers is the number of export jobs that run at the same time.
+	exportWorkers = 2
+	// exportFilePrefix starts the names of the files of export jobs.
+	exportFilePrefix = "daisser-export-"
+)
+
+// States of an ExportJob.
+const (
+	ExportPending = "pending"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportJob is an export generated in the background.
+type ExportJob struct {
+	ID      string     `json:"id"`
+	Owner   string     `json:"owner"`
+	Format  string     `json:"format"`
+	User    string     `json:"user"`
+	Tracker string     `json:"tracker"`
+	From    time.Time  `json:"from"`
+	To      time.Time  `json:"to"`
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Size    int        `json:"size,omitempty"`
+	// URL is the path of the signed download link relative to the URL
+	// base, it is set once the job is done.
+	URL string `json:"url,omitempty"`
+}
+
+// Exports runs export jobs and keeps their files in Dir.
+type Exports struct {
+	Dir string
+
+	mu      sync.Mutex
+	jobs    map[string]*ExportJob
+	secret  []byte
+	workers chan struct{}
+}
+
+// NewExports returns Exports keeping the files in dir, which is created if
+// needed. Files of earlier runs are removed.
+func NewExports(dir string) (*Exports, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	old, _ := filepath.Glob(filepath.Join(dir, exportFilePrefix+"*"))
+	for _, f := range old {
+		os.Remove(f)
+	}
+	e := &Exports{
+		Dir:     dir,
+		jobs:    make(map[string]*ExportJob),
+		secret:  make([]byte, 32),
+		workers: make(chan struct{}, exportWorkers),
+	}
+	if _, err := rand.Read(e.secret); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Exports) file(id string) string {
+	return filepath.Join(e.Dir, exportFilePrefix+id)
+}
+
+// signature returns the signature of the download link of the job id that
+// expires at expires.
+func (e *Exports) signature(id string, expires int64) string {
+	m := hmac.New(sha256.New, e.secret)
+	fmt.Fprintf(m, "%s|%d", id, expires)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// sweep removes the expired jobs and their files.
+func (e *Exports) sweep(now time.Time) {
+	for id, j := range e.jobs {
+		if j.Expires != nil && now.After(*j.Expires) {
+			os.Remove(e.file(id))
+			delete(e.jobs, id)
+		}
+	}
+}
+
+// ownJobs returns copies of the jobs of owner, or of all jobs if owner is
+// empty because authentication is disabled.
+func (e *Exports) ownJobs(owner string) []ExportJob {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sweep(time.Now())
+	l := []ExportJob{}
+	for _, j := range e.jobs {
+		if owner == "" || j.Owner == owner {
+			l = append(l, *j)
+		}
+	}
+	return l
+}
+
+// ExportJobs lists the export jobs of the authenticated user on GET. On POST,
+// it starts a job for the JSON body {"format": "gpx", "user": "...",
+// "tracker": "...", "from": "<RFC 3339>", "to": "<RFC 3339>"} and returns it
+// with status 202. The formats are those of the publish jobs.
+func (a *API) ExportJobs(w http.ResponseWriter, r *http.Request) {
+	if a.Exports == nil {
+		http.Error(w, "Export jobs are not available", http.StatusNotImplemented)
+		return
+	}
+	owner := auth.User(r)
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, a.Exports.ownJobs(owner))
+	case "POST":
+		var req struct {
+			Format  string    `json:"format"`
+			User    string    `json:"user"`
+			Tracker string    `json:"tracker"`
+			From    time.Time `json:"from"`
+			To      time.Time `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, _, ok := publish.FileType(req.Format); !ok {
+			http.Error(w, fmt.Sprintf("invalid format: %q", req.Format), http.StatusBadRequest)
+			return
+		}
+		if req.From.IsZero() {
+			http.Error(w, "from is required", http.StatusBadRequest)
+			return
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			a.serverError(w, err)
+			return
+		}
+		j := &ExportJob{
+			ID:      base64.RawURLEncoding.EncodeToString(b),
+			Owner:   owner,
+			Format:  req.Format,
+			User:    req.User,
+			Tracker: req.Tracker,
+			From:    req.From,
+			To:      req.To,
+			Status:  ExportPending,
+			Created: time.Now(),
+		}
+		a.Exports.mu.Lock()
+		a.Exports.sweep(time.Now())
+		a.Exports.jobs[j.ID] = j
+		job := *j
+		a.Exports.mu.Unlock()
+		go a.runExport(j)
+		w.Header().Set("Location", middleware.Base(r)+"/api/exports/"+j.ID)
+		writeJSONStatus(w, http.StatusAccepted, job)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runExport generates the file of j.
+func (a *API) runExport(j *ExportJob) {
+	e := a.Exports
+	e.workers <- struct{}{}
+	defer func() { <-e.workers }()
+
+	e.mu.Lock()
+	q := storage.Query{User: j.User, TrackerID: j.Tracker, From: j.From, To: j.To}
+	format, id := j.Format, j.ID
+	e.mu.Unlock()
+
+	data, err := a.encodeExport(q, format)
+	if err == nil {
+		err = os.WriteFile(e.file(id), data, 0600)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	expires := time.Now().Add(exportTTL)
+	j.Expires = &expires
+	if err != nil {
+		a.Logger.Printf("Export %s failed: %v", id, err)
+		j.Status, j.Error = ExportFailed, err.Error()
+		return
+	}
+	j.Status, j.Size = ExportDone, len(data)
+	j.URL = fmt.Sprintf("/api/download/%s?expires=%d&sig=%s", id, expires.Unix(), e.signature(id, expires.Unix()))
+}
+
+// encodeExport returns the positions selected by q in format.
+func (a *API) encodeExport(q storage.Query, format string) ([]byte, error) {
+	tracks, err := storage.Tracks(a.Store, q)
+	if err != nil {
+		return nil, err
+	}
+	return publish.Encode(format, exportName(q), tracks, time.Now())
+}
+
+// exportName returns the name of the export of the positions selected by q,
+// like "fabian-phone-2024-05-01", without extension.
+func exportName(q storage.Query) string {
+	parts := []string{"daisser"}
+	if q.User != "" {
+		parts = []string{q.User}
+		if q.TrackerID != "" {
+			parts = append(parts, q.TrackerID)
+		}
+	}
+	return strings.Join(append(parts, q.From.Format("2006-01-02")), "-")
+}
+
+// Bundle sends the positions selected by the parameters user, tracker, from
+// and to as zip archive with GPX, GeoJSON, CSV, a map as PNG and an HTML
+// summary, for archiving or sharing a whole trip. Larger trips are better
+// exported with a job of format zip at /api/exports.
+func (a *API) Bundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.From.IsZero() {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	b, err := a.encodeExport(q, "zip")
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportName(q)+".zip"))
+	w.Write(b)
+}
+
+// ExportJob serves /api/exports/<id>: GET returns the job, DELETE cancels
+// the download and removes the file.
+func (a *API) ExportJob(w http.ResponseWriter, r *http.Request) {
+	if a.Exports == nil {
+		http.Error(w, "Export jobs are not available", http.StatusNotImplemented)
+		return
+	}
+	e := a.Exports
+	id := strings.TrimPrefix(r.URL.Path, "/api/exports/")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sweep(time.Now())
+	j, ok := e.jobs[id]
+	if !ok || (auth.User(r) != "" && j.Owner != auth.User(r)) {
+		a.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, j)
+	case "DELETE":
+		os.Remove(e.file(id))
+		delete(e.jobs, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RegisterDownloadRoutes registers the download links of export jobs with r.
+// They are authenticated by their signature instead of the login of the
+// server, so that they can be handed to other programs.
+func (a *API) RegisterDownloadRoutes(r *middleware.Router) {
+	r.HandleFunc("/api/download/", a.ExportDownload)
+}
+
+// ExportDownload serves the file of a finished export job at
+// /api/download/<id>, if the parameters expires and sig are those of its
+// URL.
+func (a *API) ExportDownload(w http.ResponseWriter, r *http.Request) {
+	if a.Exports == nil {
+		http.Error(w, "Export jobs are not available", http.StatusNotImplemented)
+		return
+	}
+	e := a.Exports
+	id := strings.TrimPrefix(r.URL.Path, "/api/download/")
+	expires, err := strconv.ParseInt(r.FormValue("expires"), 10, 64)
+	sig := r.FormValue("sig")
+	if err != nil || !hmac.Equal([]byte(sig), []byte(e.signature(id, expires))) {
+		http.Error(w, "Invalid download link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(w, "The download link has expired", http.StatusGone)
+		return
+	}
+	e.mu.Lock()
+	j, ok := e.jobs[id]
+	var format, name string
+	var created time.Time
+	if ok {
+		format, created = j.Format, j.Created
+		name = exportName(storage.Query{User: j.User, TrackerID: j.Tracker, From: j.From})
+	}
+	e.mu.Unlock()
+	f, err := os.Open(e.file(id))
+	if !ok || err != nil {
+		http.Error(w, "The export has been removed", http.StatusGone)
+		return
+	}
+	defer f.Close()
+	ext, contentType, _ := publish.FileType(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+ext))
+	http.ServeContent(w, r, "", created, f)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+const (
+	// gapMinDefault is the shortest time without positions that counts as
+	// gap, unless the parameter min gives another.
+	gapMinDefault = 15 * time.Minute
+	// gapMovingSpeed is the speed in [km/h] from which a device counts as
+	// moving. Devices that stand still may legitimately stop reporting.
+	gapMovingSpeed = 5
+	// gapContext is the number of positions returned before and after a
+	// gap.
+	gapContext = 3
+)
+
+// Gap is a time in which a device that was moving sent no positions.
+// Distance is in [m] between the positions around the gap.
+type Gap struct {
+	User      string         `json:"user"`
+	Tracker   string         `json:"tracker"`
+	Start     time.Time      `json:"start"`
+	End       time.Time      `json:"end"`
+	DurationS float64        `json:"durationS"`
+	Distance  float64        `json:"distance"`
+	Before    []livePosition `json:"before"`
+	After     []livePosition `json:"after"`
+}
+
+// Gaps lists the times in which the devices selected by the parameters
+// user, tracker, from and to sent no positions for longer than the
+// parameter min, "15m" by default, although they were moving before, to
+// find when and where a tracker died. Every gap comes with the positions
+// around it.
+func (a *API) Gaps(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minGap := gapMinDefault
+	if v := r.FormValue("min"); v != "" {
+		if minGap, err = time.ParseDuration(v); err != nil || minGap <= 0 {
+			http.Error(w, fmt.Sprintf("invalid min: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	tracks, err := storage.Tracks(a.Store, q)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	loc := a.location(r)
+	gaps := []Gap{}
+	for _, t := range tracks {
+		gaps = append(gaps, findGaps(t, minGap, loc)...)
+	}
+	writeJSON(w, gaps)
+}
+
+// findGaps returns the gaps longer than minGap in the track t with times in
+// loc.
+func findGaps(t []owntracks.LocationUpdate, minGap time.Duration, loc *time.Location) []Gap {
+	var gaps []Gap
+	for i := 1; i < len(t); i++ {
+		prev, next := t[i-1], t[i]
+		if next.T.Sub(prev.T) <= minGap || !moving(t, i-1) {
+			continue
+		}
+		g := Gap{
+			User:      prev.User,
+			Tracker:   prev.TrackerID,
+			Start:     prev.T.In(loc),
+			End:       next.T.In(loc),
+			DurationS: next.T.Sub(prev.T).Seconds(),
+			Distance:  distance(prev, next),
+		}
+		for _, lu := range t[max(0, i-gapContext):i] {
+			g.Before = append(g.Before, livePosition{lu.T.In(loc), lu.Latitude, lu.Longitude, lu.Accuracy, lu.Velocity})
+		}
+		for _, lu := range t[i:min(len(t), i+gapContext)] {
+			g.After = append(g.After, livePosition{lu.T.In(loc), lu.Latitude, lu.Longitude, lu.Accuracy, lu.Velocity})
+		}
+		gaps = append(gaps, g)
+	}
+	return gaps
+}
+
+// moving reports whether the device was moving at the position i of t,
+// by the velocity it reported or else by the speed from its previous
+// position.
+func moving(t []owntracks.LocationUpdate, i int) bool {
+	if t[i].Velocity > 0 || i == 0 {
+		return t[i].Velocity >= gapMovingSpeed
+	}
+	dt := t[i].T.Sub(t[i-1].T).Seconds()
+	return dt > 0 && distance(t[i-1], t[i])/dt*3.6 >= gapMovingSpeed
+}
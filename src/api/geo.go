@@ -0,0 +1,11 @@
+package api
+
+import (
+	"geo"
+	"owntracks"
+)
+
+// distance returns the great-circle distance between a and b in [m].
+func distance(a, b owntracks.LocationUpdate) float64 {
+	return geo.Distance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+}
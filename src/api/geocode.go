@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxGeocodes is the number of search results a Geocoder keeps.
+	maxGeocodes = 1000
+	// geocodeTTL is how long search results are kept.
+	geocodeTTL = 24 * time.Hour
+	// geocodeMaxWait is the longest a search waits for its turn at the
+	// geocoder before it is rejected.
+	geocodeMaxWait = 5 * time.Second
+	// geocodeDefaultLimit and geocodeMaxLimit bound the results of a search.
+	geocodeDefaultLimit = 5
+	geocodeMaxLimit     = 20
+)
+
+// errGeocodeBusy is returned by Search if the rate limit of the geocoder
+// is exhausted.
+var errGeocodeBusy = errors.New("too many searches, try again later")
+
+// GeocodeResult is a place found by the search of a Geocoder.
+type GeocodeResult struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// BoundingBox is the extent of the place as [south, west, north, east].
+	BoundingBox [4]float64 `json:"boundingBox"`
+}
+
+// Geocoder searches addresses with the search service of a Nominatim
+// server, or of a commercial service with a compatible API. Results are
+// kept, and requests to the server are spaced by Interval, as the public
+// Nominatim servers demand.
+type Geocoder struct {
+	// URL is the base URL of the server, e.g.
+	// "https://nominatim.openstreetmap.org".
+	URL string
+	// Key is sent as parameter key if it is not empty, e.g. for LocationIQ.
+	Key string
+	// Interval is the least time between two requests to the server.
+	Interval time.Duration
+	Client   *http.Client
+
+	mu    sync.Mutex
+	next  time.Time
+	cache map[string]geocodeEntry
+}
+
+type geocodeEntry struct {
+	results []GeocodeResult
+	expires time.Time
+}
+
+// NewGeocoder returns a Geocoder for the server at url.
+func NewGeocoder(url, key string) *Geocoder {
+	return &Geocoder{
+		URL:      strings.TrimSuffix(url, "/"),
+		Key:      key,
+		Interval: time.Second,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		cache:    make(map[string]geocodeEntry),
+	}
+}
+
+type nominatimPlace struct {
+	DisplayName string   `json:"display_name"`
+	Lat         string   `json:"lat"`
+	Lon         string   `json:"lon"`
+	BoundingBox []string `json:"boundingbox"`
+}
+
+// Search returns up to limit places matching the free-form query q.
+func (g *Geocoder) Search(q string, limit int) ([]GeocodeResult, error) {
+	key := fmt.Sprintf("%d %s", limit, strings.ToLower(strings.Join(strings.Fields(q), " ")))
+	now := time.Now()
+	g.mu.Lock()
+	e, ok := g.cache[key]
+	if ok && now.Before(e.expires) {
+		g.mu.Unlock()
+		return e.results, nil
+	}
+	// reserve the next free slot of the rate limit
+	slot := g.next
+	if slot.Before(now) {
+		slot = now
+	}
+	if slot.Sub(now) > geocodeMaxWait {
+		g.mu.Unlock()
+		return nil, errGeocodeBusy
+	}
+	g.next = slot.Add(g.Interval)
+	g.mu.Unlock()
+	time.Sleep(slot.Sub(now))
+
+	results, err := g.search(q, limit)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	if len(g.cache) >= maxGeocodes {
+		g.cache = make(map[string]geocodeEntry)
+	}
+	g.cache[key] = geocodeEntry{results, time.Now().Add(geocodeTTL)}
+	g.mu.Unlock()
+	return results, nil
+}
+
+// search sends a single request to the search service.
+func (g *Geocoder) search(q string, limit int) ([]GeocodeResult, error) {
+	v := url.Values{
+		"q":      {q},
+		"format": {"jsonv2"},
+		"limit":  {strconv.Itoa(limit)},
+	}
+	if g.Key != "" {
+		v.Set("key", g.Key)
+	}
+	req, err := http.NewRequest("GET", g.URL+"/search?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// the usage policy of Nominatim requires an identifying user agent
+	req.Header.Set("User-Agent", "daisser")
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geocoder: %s", resp.Status)
+	}
+	var places []nominatimPlace
+	if err := json.NewDecoder(resp.Body).Decode(&places); err != nil {
+		return nil, err
+	}
+	results := []GeocodeResult{}
+	for _, p := range places {
+		r := GeocodeResult{Name: p.DisplayName}
+		var err1, err2 error
+		r.Latitude, err1 = strconv.ParseFloat(p.Lat, 64)
+		r.Longitude, err2 = strconv.ParseFloat(p.Lon, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// Nominatim orders the box as south, north, west, east
+		if len(p.BoundingBox) == 4 {
+			for i, j := range []int{0, 2, 1, 3} {
+				r.BoundingBox[i], _ = strconv.ParseFloat(p.BoundingBox[j], 64)
+			}
+		} else {
+			r.BoundingBox = [4]float64{r.Latitude, r.Longitude, r.Latitude, r.Longitude}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Geocode returns the places matching the parameter q, for the search box
+// of the map. The parameter limit caps the number of places.
+func (a *API) Geocode(w http.ResponseWriter, r *http.Request) {
+	if a.Geocoder == nil {
+		http.Error(w, "Address search is not available", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := strings.TrimSpace(r.FormValue("q"))
+	if q == "" {
+		http.Error(w, "missing parameter q", http.StatusBadRequest)
+		return
+	}
+	limit := geocodeDefaultLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > geocodeMaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", geocodeMaxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	results, err := a.Geocoder.Search(q, limit)
+	if err == errGeocodeBusy {
+		w.Header().Set("Retry-After", strconv.Itoa(int(geocodeMaxWait/time.Second)))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		a.Logger.Println(err)
+		http.Error(w, "Address search failed", http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, results)
+}
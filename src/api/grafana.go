@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+// The handlers in this file implement the conventions of the Grafana
+// simple-JSON datasource (which the Infinity datasource can consume as well),
+// so that the tracked data can be put on a dashboard directly. They need
+// the same authentication as the rest of the API, so with header auth the
+// datasource has to go through the authenticating proxy.
+
+// grafanaMetrics are the per-device time series offered to Grafana.
+var grafanaMetrics = []string{"speed", "altitude", "battery", "distance"}
+
+// grafanaTable is the name of the target that returns all positions as a table
+// suitable for the geomap panel.
+const grafanaTable = "positions"
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	MaxDataPoints int `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTableResponse struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// GrafanaTest answers the connection test of the datasource.
+func (a *API) GrafanaTest(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/grafana/" {
+		a.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GrafanaSearch returns the names of all available targets.
+func (a *API) GrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	devices, err := a.Store.Devices()
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	targets := []string{grafanaTable}
+	for _, d := range devices {
+		for _, m := range grafanaMetrics {
+			targets = append(targets, m+" "+d.Name())
+		}
+	}
+	writeJSON(w, targets)
+}
+
+// GrafanaQuery returns the time series or tables for the requested targets.
+// A target is either the name of the position table, a metric name (which
+// selects the series of all devices) or a metric name followed by a device
+// name as returned by GrafanaSearch.
+func (a *API) GrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var q grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		http.Error(w, "Bad query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Range.To.IsZero() {
+		q.Range.To = time.Now()
+	}
+	tracks, err := storage.Tracks(a.Store, storage.Query{From: q.Range.From, To: q.Range.To})
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+
+	resp := []interface{}{}
+	for _, target := range q.Targets {
+		if target.Type == "table" || target.Target == grafanaTable {
+			resp = append(resp, grafanaPositionTable(tracks))
+			continue
+		}
+		metric, device := target.Target, ""
+		if i := strings.Index(metric, " "); i >= 0 {
+			metric, device = metric[:i], metric[i+1:]
+		}
+		for _, t := range tracks {
+			if device != "" && device != storage.DeviceName(t[0]) {
+				continue
+			}
+			series := grafanaSeries{
+				Target:     metric + " " + storage.DeviceName(t[0]),
+				Datapoints: grafanaDatapoints(metric, t, q.MaxDataPoints),
+			}
+			if series.Datapoints != nil {
+				resp = append(resp, series)
+			}
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// grafanaDatapoints computes the series of metric for track t and thins it
+// out to at most max points. It returns nil for unknown metrics.
+func grafanaDatapoints(metric string, t []owntracks.LocationUpdate, max int) [][2]float64 {
+	var value func(i int) float64
+	var total float64
+	switch metric {
+	case "speed":
+		value = func(i int) float64 { return float64(t[i].Velocity) }
+	case "altitude":
+		value = func(i int) float64 { return float64(t[i].Altitude) }
+	case "battery":
+		value = func(i int) float64 { return float64(t[i].Battery) }
+	case "distance":
+		value = func(i int) float64 {
+			if i > 0 {
+				total += distance(t[i-1], t[i])
+			}
+			return total
+		}
+	default:
+		return nil
+	}
+	step := 1
+	if max > 0 && len(t) > max {
+		step = (len(t) + max - 1) / max
+	}
+	dp := make([][2]float64, 0, len(t)/step+1)
+	for i := range t {
+		// the value is computed for every point, as the distance accumulates
+		v := value(i)
+		if i%step == 0 || i == len(t)-1 {
+			dp = append(dp, [2]float64{v, float64(t[i].T.UnixNano() / int64(time.Millisecond))})
+		}
+	}
+	return dp
+}
+
+func grafanaPositionTable(tracks [][]owntracks.LocationUpdate) grafanaTableResponse {
+	tr := grafanaTableResponse{
+		Type: "table",
+		Columns: []grafanaColumn{
+			{"Time", "time"},
+			{"Device", "string"},
+			{"Latitude", "number"},
+			{"Longitude", "number"},
+			{"Accuracy", "number"},
+			{"Speed", "number"},
+			{"Altitude", "number"},
+			{"Battery", "number"},
+		},
+		Rows: [][]interface{}{},
+	}
+	for _, t := range tracks {
+		for _, lu := range t {
+			tr.Rows = append(tr.Rows, []interface{}{
+				lu.T.UnixNano() / int64(time.Millisecond),
+				storage.DeviceName(lu),
+				lu.Latitude,
+				lu.Longitude,
+				lu.Accuracy,
+				lu.Velocity,
+				lu.Altitude,
+				lu.Battery,
+			})
+		}
+	}
+	return tr
+}
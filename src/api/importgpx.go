@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth"
+	"owntracks"
+	"storage"
+)
+
+// gpxImportPoint is a track point or waypoint of an imported GPX file.
+type gpxImportPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  float64   `xml:"ele"`
+	Time time.Time `xml:"time"`
+	Name string    `xml:"name"`
+	// Speed in [m/s] and Course are only part of GPX 1.0.
+	Speed  float64 `xml:"speed"`
+	Course float64 `xml:"course"`
+}
+
+// ImportResult reports the outcome of an import.
+type ImportResult struct {
+	Imported int `json:"imported"`
+	// Skipped positions were already stored.
+	Skipped int `json:"skipped"`
+	// Rejected positions have no time, fail the validation or a policy,
+	// or cannot be stored.
+	Rejected int `json:"rejected"`
+}
+
+// ImportGPX serves POST /api/import/gpx, which imports the track points and
+// waypoints of the GPX files uploaded as "file" fields of a multipart form
+// as positions of the authenticated user, with the name of a waypoint as
+// description. The parameter tracker gives the device, "gpx" by default,
+// and without authentication the parameter user the user. The files are
+// parsed while they are uploaded, so they may be large, and the read and
+// write timeouts of the server do not apply. Positions already stored at the
+// same second are skipped, so that an import can be repeated. The others
+// pass the privacy rules and policies like received positions, with "gpx"
+// as protocol.
+func (a *API) ImportGPX(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.Import == nil {
+		http.Error(w, "Imports are not available", http.StatusNotImplemented)
+		return
+	}
+	q := r.URL.Query()
+	user := auth.User(r)
+	if user == "" {
+		user = q.Get("user")
+	}
+	tracker := q.Get("tracker")
+	if tracker == "" {
+		tracker = "gpx"
+	}
+	if user == "" || strings.Contains(user, "/") || strings.Contains(tracker, "/") {
+		http.Error(w, "invalid user or tracker", http.StatusBadRequest)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Bad upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := a.Store.QueryPositions(storage.Query{User: user, TrackerID: tracker})
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	known := make(map[int64]bool, len(stored))
+	for _, lu := range stored {
+		known[lu.T.Unix()] = true
+	}
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	var res ImportResult
+	add := func(p gpxImportPoint) {
+		lu := owntracks.LocationUpdate{
+			T:           p.Time,
+			Trigger:     owntracks.UnknownTrigger,
+			User:        user,
+			ClientID:    "gpx",
+			TrackerID:   tracker,
+			Latitude:    p.Lat,
+			Longitude:   p.Lon,
+			Altitude:    int(p.Ele + 0.5),
+			Velocity:    int(p.Speed*3.6 + 0.5),
+			Course:      int(p.Course + 0.5),
+			Description: p.Name,
+		}
+		if p.Time.IsZero() {
+			res.Rejected++
+			return
+		}
+		if known[lu.T.Unix()] {
+			res.Skipped++
+			return
+		}
+		if err := a.Import(lu); err != nil {
+			res.Rejected++
+			return
+		}
+		known[lu.T.Unix()] = true
+		res.Imported++
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Bad upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		if err := readGPX(part, add); err != nil {
+			http.Error(w, fmt.Sprintf("Bad GPX file %q: %v", part.FileName(), err), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, res)
+}
+
+// readGPX passes the track points and waypoints of the GPX document in r to
+// add one by one.
+func readGPX(r io.Reader, add func(gpxImportPoint)) error {
+	d := xml.NewDecoder(r)
+	root := true
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root {
+			if se.Name.Local != "gpx" {
+				return errors.New("no GPX document")
+			}
+			root = false
+			continue
+		}
+		if se.Name.Local != "trkpt" && se.Name.Local != "wpt" {
+			continue
+		}
+		var p gpxImportPoint
+		if err := d.DecodeElement(&p, &se); err != nil {
+			return err
+		}
+		add(p)
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth"
+	"owntracks"
+	"storage"
+)
+
+const (
+	// interpolateMaxTimes is the most times one request may ask for.
+	interpolateMaxTimes = 10000
+	// interpolateDefaultGap is the default of the parameter maxgap.
+	interpolateDefaultGap = 15 * time.Minute
+)
+
+// Point is a position without metadata. Altitude is in [m].
+type Point struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  int     `json:"altitude"`
+}
+
+// InterpolatedPosition is where a user was at Time. Position is null if it
+// is unknown.
+type InterpolatedPosition struct {
+	Time     time.Time `json:"time"`
+	Position *Point    `json:"position"`
+}
+
+// Interpolate returns the positions of a user at the times in the parameter
+// times, interpolated between the recorded positions, to geotag the photos
+// of a camera. It is usually POSTed as form, since there are many times.
+//
+// The times are separated by commas and given as RFC 3339, as seconds since
+// the Unix epoch, or as local time like the EXIF "2006:01:02 15:04:05" in
+// the preferred time zone. The parameter offset, a duration like "-1m30s",
+// is added to all of them to make up for the drift of the camera clock.
+// The parameter user defaults to the authenticated user, tracker restricts
+// the positions to one device. Times in gaps between positions longer than
+// maxgap (default 15m) have no position.
+func (a *API) Interpolate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := r.FormValue("user")
+	if user == "" {
+		user = auth.User(r)
+	}
+	var offset time.Duration
+	maxGap := interpolateDefaultGap
+	for _, p := range []struct {
+		name string
+		d    *time.Duration
+	}{{"offset", &offset}, {"maxgap", &maxGap}} {
+		if v := r.FormValue(p.name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %q", p.name, v), http.StatusBadRequest)
+				return
+			}
+			*p.d = d
+		}
+	}
+	loc := a.location(r)
+	var times []time.Time
+	for _, v := range strings.Split(r.FormValue("times"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		t, err := parseLocalTime(v, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		times = append(times, t.Add(offset))
+	}
+	if len(times) == 0 || len(times) > interpolateMaxTimes {
+		http.Error(w, fmt.Sprintf("times must list 1 to %d times", interpolateMaxTimes), http.StatusBadRequest)
+		return
+	}
+
+	// align needs the times sorted, the response keeps the order of the
+	// request
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return times[order[i]].Before(times[order[j]]) })
+	sorted := make([]time.Time, len(times))
+	for i, k := range order {
+		sorted[i] = times[k]
+	}
+	t, err := a.Store.QueryPositions(storage.Query{
+		User:      user,
+		TrackerID: r.FormValue("tracker"),
+		From:      sorted[0].Add(-maxGap),
+		To:        sorted[len(sorted)-1].Add(maxGap),
+	})
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	// the positions of all devices of the user form one track
+	sort.SliceStable(t, func(i, j int) bool { return t[i].T.Before(t[j].T) })
+
+	res := make([]InterpolatedPosition, len(times))
+	for i, lu := range align(t, sorted, maxGap) {
+		k := order[i]
+		res[k].Time = times[k].In(loc)
+		if lu != nil {
+			res[k].Position = &Point{lu.Latitude, lu.Longitude, lu.Altitude}
+		}
+	}
+	writeEncoded(w, r, res)
+}
+
+// parseLocalTime parses v as RFC 3339 timestamp, as seconds since the Unix
+// epoch, or as date and time without zone in loc.
+func parseLocalTime(v string, loc *time.Location) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006:01:02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %q", v)
+}
+
+// interpolate returns the position between a and b at the time at, which
+// must lie between theirs.
+func interpolate(a, b owntracks.LocationUpdate, at time.Time) owntracks.LocationUpdate {
+	f := float64(at.Sub(a.T)) / float64(b.T.Sub(a.T))
+	lu := a
+	lu.T = at
+	lu.Latitude += (b.Latitude - a.Latitude) * f
+	lu.Longitude += (b.Longitude - a.Longitude) * f
+	lu.Altitude += int(math.Round(float64(b.Altitude-a.Altitude) * f))
+	return lu
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth"
+	"owntracks"
+)
+
+// maxShareDuration is the longest time a live share can be valid.
+const maxShareDuration = 24 * time.Hour
+
+// liveKeepAlive is the interval of the comments sent to keep idle live
+// streams open through proxies.
+const liveKeepAlive = 25 * time.Second
+
+// Share makes the live position of a device visible to everybody who knows
+// its token, until it expires.
+type Share struct {
+	Token   string    `json:"token"`
+	User    string    `json:"user"`
+	Tracker string    `json:"tracker"`
+	Expires time.Time `json:"expires"`
+	// URL is the path of the live page relative to the UrlBase.
+	URL string `json:"url"`
+}
+
+// livePosition is the data of the position events of a live stream.
+type livePosition struct {
+	Time      time.Time `json:"time"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Accuracy  int       `json:"accuracy"`
+	Velocity  int       `json:"velocity"`
+}
+
+// LiveShare returns the share with the given token if it has not expired.
+func (a *API) LiveShare(token string) (Share, bool) {
+	a.sharesMu.Lock()
+	defer a.sharesMu.Unlock()
+	sh, ok := a.shares[token]
+	if ok && time.Now().After(sh.Expires) {
+		delete(a.shares, token)
+		return Share{}, false
+	}
+	return sh, ok
+}
+
+// ownShares returns the active shares of user, or of all users if user is
+// empty because authentication is disabled.
+func (a *API) ownShares(user string) []Share {
+	a.sharesMu.Lock()
+	defer a.sharesMu.Unlock()
+	l := []Share{}
+	now := time.Now()
+	for t, sh := range a.shares {
+		if now.After(sh.Expires) {
+			delete(a.shares, t)
+		} else if user == "" || sh.User == user {
+			l = append(l, sh)
+		}
+	}
+	return l
+}
+
+// Shares lists the active live shares of the authenticated user on GET. On
+// POST, it starts a share of the device given in the JSON body as
+// {"device": "<user>/<tracker>", "duration": "1h"}.
+func (a *API) Shares(w http.ResponseWriter, r *http.Request) {
+	user := auth.User(r)
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, a.ownShares(user))
+	case "POST":
+		var req struct {
+			Device   string `json:"device"`
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad share: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > maxShareDuration {
+			http.Error(w, fmt.Sprintf("duration must be between 0 and %s", maxShareDuration), http.StatusBadRequest)
+			return
+		}
+		devUser, tracker, _ := strings.Cut(req.Device, "/")
+		if user != "" && devUser != user {
+			http.Error(w, "Only your own devices can be shared", http.StatusForbidden)
+			return
+		}
+		if _, ok, err := a.lastPosition(devUser, tracker); err != nil {
+			a.serverError(w, err)
+			return
+		} else if !ok {
+			http.Error(w, "Unknown device", http.StatusBadRequest)
+			return
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			a.serverError(w, err)
+			return
+		}
+		token := base64.RawURLEncoding.EncodeToString(b)
+		sh := Share{
+			Token:   token,
+			User:    devUser,
+			Tracker: tracker,
+			Expires: time.Now().Add(d),
+			URL:     "/live/" + token,
+		}
+		a.sharesMu.Lock()
+		if a.shares == nil {
+			a.shares = make(map[string]Share)
+		}
+		a.shares[token] = sh
+		a.sharesMu.Unlock()
+		writeJSONStatus(w, http.StatusCreated, sh)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// StopShare serves DELETE /api/shares/<token>, which ends a share early.
+func (a *API) StopShare(w http.ResponseWriter, r *http.Request) {
+	sh, ok := a.LiveShare(strings.TrimPrefix(r.URL.Path, "/api/shares/"))
+	if !ok || (auth.User(r) != "" && sh.User != auth.User(r)) {
+		a.NotFound(w, r)
+		return
+	}
+	if r.Method != "DELETE" {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a.sharesMu.Lock()
+	delete(a.shares, sh.Token)
+	a.sharesMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lastPosition returns the latest position of a device.
+func (a *API) lastPosition(user, tracker string) (owntracks.LocationUpdate, bool, error) {
+	devices, err := a.Store.Devices()
+	if err != nil {
+		return owntracks.LocationUpdate{}, false, err
+	}
+	for _, d := range devices {
+		if d.User == user && d.TrackerID == tracker {
+			return d.Last, true, nil
+		}
+	}
+	return owntracks.LocationUpdate{}, false, nil
+}
+
+// LiveEvents streams the positions of the device shared as sh as
+// server-sent events. Every position is a "position" event, an "expired"
+// event ends the stream when the share expires or is stopped.
+func (a *API) LiveEvents(w http.ResponseWriter, r *http.Request, sh Share) {
+	if a.Broker == nil {
+		http.Error(w, "Live positions are not available", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// the stream lives as long as the share, not as long as WriteTimeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	updates, cancel := a.Broker.Subscribe(sh.User + "/" + sh.Tracker)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(lu owntracks.LocationUpdate) {
+		b, _ := json.Marshal(livePosition{lu.T, lu.Latitude, lu.Longitude, lu.Accuracy, lu.Velocity})
+		fmt.Fprintf(w, "event: position\ndata: %s\n\n", b)
+		flusher.Flush()
+	}
+	if lu, ok, err := a.lastPosition(sh.User, sh.Tracker); err == nil && ok {
+		send(lu)
+	}
+	expired := func() {
+		fmt.Fprint(w, "event: expired\ndata: {}\n\n")
+		flusher.Flush()
+	}
+	expiry := time.NewTimer(time.Until(sh.Expires))
+	defer expiry.Stop()
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case lu, ok := <-updates:
+			if !ok {
+				return
+			}
+			send(lu)
+		case <-expiry.C:
+			expired()
+			return
+		case <-keepAlive.C:
+			// the share may have been stopped early
+			if _, ok := a.LiveShare(sh.Token); !ok {
+				expired()
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
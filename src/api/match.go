@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+// osrmMaxCoordinates is the default limit of coordinates per request of the
+// OSRM match service.
+const osrmMaxCoordinates = 100
+
+// maxMatches is the number of matched geometries a Matcher keeps.
+const maxMatches = 1000
+
+// Matcher snaps tracks to the road network using the match service of an
+// OSRM server. Matched geometries are kept, so every track is only sent to
+// the server once.
+type Matcher struct {
+	// URL is the base URL of the OSRM server, e.g. "http://localhost:5000".
+	URL string
+	// Profile is the routing profile, e.g. "driving".
+	Profile string
+	Client  *http.Client
+
+	mu    sync.Mutex
+	cache map[string][][2]float64
+}
+
+// NewMatcher returns a Matcher for the OSRM server at url.
+func NewMatcher(url, profile string) *Matcher {
+	if profile == "" {
+		profile = "driving"
+	}
+	return &Matcher{
+		URL:     strings.TrimSuffix(url, "/"),
+		Profile: profile,
+		Client:  &http.Client{Timeout: 20 * time.Second},
+		cache:   make(map[string][][2]float64),
+	}
+}
+
+type osrmMatchResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Matchings []struct {
+		Geometry struct {
+			Coordinates [][2]float64 `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"matchings"`
+}
+
+// Match returns the coordinates of the track t snapped to the road network.
+func (m *Matcher) Match(t []owntracks.LocationUpdate) ([][2]float64, error) {
+	key := fmt.Sprintf("%s %d %d %d", storage.Key(t[0]), t[0].T.Unix(), t[len(t)-1].T.Unix(), len(t))
+	m.mu.Lock()
+	coords, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok {
+		return coords, nil
+	}
+	for start := 0; start < len(t); start += osrmMaxCoordinates - 1 {
+		end := start + osrmMaxCoordinates
+		if end > len(t) {
+			end = len(t)
+		}
+		c, err := m.match(t[start:end])
+		if err != nil {
+			return nil, err
+		}
+		coords = append(coords, c...)
+		if end == len(t) {
+			break
+		}
+	}
+	m.mu.Lock()
+	if len(m.cache) >= maxMatches {
+		m.cache = make(map[string][][2]float64)
+	}
+	m.cache[key] = coords
+	m.mu.Unlock()
+	return coords, nil
+}
+
+// match sends a single request to the match service.
+func (m *Matcher) match(t []owntracks.LocationUpdate) ([][2]float64, error) {
+	if len(t) < 2 {
+		return [][2]float64{{t[0].Longitude, t[0].Latitude}}, nil
+	}
+	points := make([]string, len(t))
+	stamps := make([]string, len(t))
+	radiuses := make([]string, len(t))
+	for i, lu := range t {
+		points[i] = fmt.Sprintf("%f,%f", lu.Longitude, lu.Latitude)
+		stamps[i] = fmt.Sprint(lu.T.Unix())
+		acc := lu.Accuracy
+		if acc <= 0 {
+			acc = 5
+		}
+		radiuses[i] = fmt.Sprint(acc)
+	}
+	url := fmt.Sprintf("%s/match/v1/%s/%s?geometries=geojson&overview=full&timestamps=%s&radiuses=%s",
+		m.URL, m.Profile, strings.Join(points, ";"), strings.Join(stamps, ";"), strings.Join(radiuses, ";"))
+	resp, err := m.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var mr osrmMatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&mr); err != nil {
+		return nil, err
+	}
+	if mr.Code != "Ok" {
+		return nil, fmt.Errorf("osrm: %s: %s", mr.Code, mr.Message)
+	}
+	var coords [][2]float64
+	for _, mt := range mr.Matchings {
+		coords = append(coords, mt.Geometry.Coordinates...)
+	}
+	return coords, nil
+}
+
+type osrmTableResponse struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Distances [][]*float64 `json:"distances"`
+	Durations [][]*float64 `json:"durations"`
+}
+
+// Table returns the road distances in [m] and travel times in [s] from
+// every source to every destination, both given as longitude and latitude,
+// using the table service of the OSRM server. Pairs without a route are
+// nil.
+func (m *Matcher) Table(sources, destinations [][2]float64) (distances, durations [][]*float64, err error) {
+	var points, src, dst []string
+	for i, c := range append(append([][2]float64{}, sources...), destinations...) {
+		points = append(points, fmt.Sprintf("%f,%f", c[0], c[1]))
+		if i < len(sources) {
+			src = append(src, fmt.Sprint(i))
+		} else {
+			dst = append(dst, fmt.Sprint(i))
+		}
+	}
+	url := fmt.Sprintf("%s/table/v1/%s/%s?sources=%s&destinations=%s&annotations=distance,duration",
+		m.URL, m.Profile, strings.Join(points, ";"), strings.Join(src, ";"), strings.Join(dst, ";"))
+	resp, err := m.Client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	var tr osrmTableResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, nil, err
+	}
+	if tr.Code != "Ok" {
+		return nil, nil, fmt.Errorf("osrm: %s: %s", tr.Code, tr.Message)
+	}
+	return tr.Distances, tr.Durations, nil
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// marshalMsgpack encodes v in the MessagePack format, as the same value
+// encoding/json would encode: structs become maps with the same keys,
+// omitempty, omitzero and embedded structs are honored, and values with a
+// MarshalJSON or MarshalText method, like time.Time, are encoded as what
+// those return.
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var b []byte
+	return appendMsgpack(b, reflect.ValueOf(v))
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonNumberType    = reflect.TypeOf(json.Number(""))
+)
+
+func appendMsgpack(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, 0xc0), nil
+	}
+	// fields promoted from unexported embedded structs cannot be passed to
+	// their methods
+	if !v.CanInterface() {
+		return appendMsgpackKind(b, v)
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PointerTo(v.Type()).Implements(jsonMarshalerType) {
+		v = v.Addr()
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		j, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		d := json.NewDecoder(bytes.NewReader(j))
+		d.UseNumber()
+		var e interface{}
+		if err := d.Decode(&e); err != nil {
+			return nil, err
+		}
+		return appendMsgpack(b, reflect.ValueOf(e))
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PointerTo(v.Type()).Implements(textMarshalerType) {
+		v = v.Addr()
+	}
+	if v.Type().Implements(textMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		t, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return appendMsgpackString(b, string(t)), nil
+	}
+	if v.Type() == jsonNumberType {
+		n := json.Number(v.String())
+		if i, err := n.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+	}
+	return appendMsgpackKind(b, v)
+}
+
+// appendMsgpackKind appends v encoded by its kind.
+func appendMsgpackKind(b []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return appendMsgpack(b, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := v.Uint()
+		if u <= math.MaxInt64 {
+			return appendMsgpackInt(b, int64(u)), nil
+		}
+		b = append(b, 0xcf)
+		return binary.BigEndian.AppendUint64(b, u), nil
+	case reflect.Float32, reflect.Float64:
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendMsgpackString(b, v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			// like encoding/json
+			return appendMsgpackString(b, base64.StdEncoding.EncodeToString(v.Bytes())), nil
+		}
+		b = appendMsgpackHeader(b, v.Len(), 0x90, 0xdc)
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendMsgpack(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("msgpack: unsupported map key type %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		b = appendMsgpackHeader(b, v.Len(), 0x80, 0xde)
+		var err error
+		for _, k := range v.MapKeys() {
+			b = appendMsgpackString(b, k.String())
+			if b, err = appendMsgpack(b, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		var names []string
+		var fields []reflect.Value
+		for _, f := range msgpackFields(v.Type()) {
+			fv, err := v.FieldByIndexErr(f.index)
+			if err != nil {
+				// behind a nil embedded pointer
+				continue
+			}
+			if (f.omitEmpty && isEmptyValue(fv)) || (f.omitZero && isZeroValue(fv)) {
+				continue
+			}
+			names = append(names, f.name)
+			fields = append(fields, fv)
+		}
+		b = appendMsgpackHeader(b, len(names), 0x80, 0xde)
+		var err error
+		for i, n := range names {
+			b = appendMsgpackString(b, n)
+			if b, err = appendMsgpack(b, fields[i]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+// msgpackField is a struct field as encoding/json sees it.
+type msgpackField struct {
+	name      string
+	index     []int
+	depth     int
+	tagged    bool
+	omitEmpty bool
+	omitZero  bool
+}
+
+// msgpackFields returns the fields of the struct type t that encoding/json
+// encodes, in their order, with the fields of embedded structs promoted by
+// the rules of encoding/json.
+func msgpackFields(t reflect.Type) []msgpackField {
+	var all []msgpackField
+	var walk func(t reflect.Type, index []int, visited map[reflect.Type]bool)
+	walk = func(t reflect.Type, index []int, visited map[reflect.Type]bool) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		defer delete(visited, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if f.Anonymous {
+				if !f.IsExported() && ft.Kind() != reflect.Struct {
+					continue
+				}
+			} else if !f.IsExported() {
+				continue
+			}
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			idx := append(append([]int(nil), index...), i)
+			if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				walk(ft, idx, visited)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			mf := msgpackField{name: name, index: idx, depth: len(idx), tagged: name != ""}
+			if name == "" {
+				mf.name = f.Name
+			}
+			for _, o := range strings.Split(opts, ",") {
+				mf.omitEmpty = mf.omitEmpty || o == "omitempty"
+				mf.omitZero = mf.omitZero || o == "omitzero"
+			}
+			all = append(all, mf)
+		}
+	}
+	walk(t, nil, make(map[reflect.Type]bool))
+
+	// of the fields with the same name, the shallowest wins, and of those
+	// at the same depth the only tagged one, otherwise all are left out
+	var fields []msgpackField
+	for i, f := range all {
+		dominant, ties := true, 0
+		for j, g := range all {
+			if g.name != f.name || i == j {
+				continue
+			}
+			switch {
+			case g.depth < f.depth, g.depth == f.depth && g.tagged && !f.tagged:
+				dominant = false
+			case g.depth == f.depth && g.tagged == f.tagged:
+				ties++
+			}
+		}
+		if dominant && ties == 0 {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// isEmptyValue reports whether v is empty in the sense of omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Ptr:
+		return v.IsZero()
+	}
+	return false
+}
+
+// isZeroValue reports whether v is zero in the sense of omitzero, using
+// its IsZero method if it has one, like time.Time.
+func isZeroValue(v reflect.Value) bool {
+	if !v.CanInterface() {
+		return v.IsZero()
+	}
+	if z, ok := v.Interface().(interface{ IsZero() bool }); ok {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return true
+		}
+		return z.IsZero()
+	}
+	return v.IsZero()
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b = append(b, 0xd2)
+		return binary.BigEndian.AppendUint32(b, uint32(i))
+	}
+	b = append(b, 0xd3)
+	return binary.BigEndian.AppendUint64(b, uint64(i))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackHeader appends the header of an array or map with n elements.
+// fix is the type byte of the short form, long the one of the 16 bit form.
+func appendMsgpackHeader(b []byte, n int, fix, long byte) []byte {
+	switch {
+	case n <= 15:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, long)
+		return binary.BigEndian.AppendUint16(b, uint16(n))
+	}
+	b = append(b, long+1)
+	return binary.BigEndian.AppendUint32(b, uint32(n))
+}
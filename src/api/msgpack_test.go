@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"middleware"
+	"owntracks"
+	"storage"
+)
+
+// TestMsgpackMatchesJSON checks that the MessagePack encoding of the
+// responses decodes to the same value as their JSON encoding.
+func TestMsgpackMatchesJSON(t *testing.T) {
+	now := time.Date(2026, 10, 16, 22, 5, 7, 123456789, time.FixedZone("CEST", 2*3600))
+	type inner struct {
+		Shadowed string
+		Deep     int `json:"deep"`
+	}
+	type hidden struct {
+		Promoted int
+	}
+	type payload struct {
+		inner
+		*hidden
+		Start    time.Time            `json:"start"`
+		End      *time.Time           `json:"end,omitempty"`
+		Zero     time.Time            `json:"zero,omitzero"`
+		Empty    string               `json:"empty,omitempty"`
+		Nothing  []int                `json:"nothing,omitempty"`
+		Shadowed string               `json:"shadowed"`
+		Raw      []byte               `json:"raw"`
+		Duration time.Duration        `json:"duration"`
+		Skipped  int                  `json:"-"`
+		Map      map[string]time.Time `json:"map"`
+		private  int
+	}
+	for _, v := range []interface{}{
+		payload{
+			inner:    inner{Shadowed: "inner", Deep: 3},
+			Start:    now,
+			Shadowed: "outer",
+			Raw:      []byte{1, 2, 3},
+			Duration: time.Minute,
+			Map:      map[string]time.Time{"a": now},
+		},
+		payload{hidden: &hidden{Promoted: 5}, End: &now},
+		Gap{User: "u", Start: now, End: now.Add(time.Hour), Before: []livePosition{{now, 50, 8, 10, 3}}},
+		storage.DeviceStats{Day: "2026-10-16", LastReceived: now},
+		BootstrapDevice{Position: livePosition{now, 1.5, -2.25, 0, 0}},
+		[]Endpoint{{ID: "user:a", Time: &now}, {ID: "place:home"}},
+	} {
+		j, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want interface{}
+		if err := json.Unmarshal(j, &want); err != nil {
+			t.Fatal(err)
+		}
+		m, err := marshalMsgpack(v)
+		if err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		got, rest, err := decodeMsgpack(m)
+		if err != nil || len(rest) > 0 {
+			t.Fatalf("%T: decoding % x: %v, %d bytes left", v, m, err, len(rest))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%T: MessagePack decodes to\n%v\nJSON to\n%v", v, got, want)
+		}
+	}
+}
+
+// TestMsgpackResponses checks that the endpoints offering MessagePack send
+// the same times and values in it as in JSON.
+func TestMsgpackResponses(t *testing.T) {
+	store, err := storage.Open("memory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	for _, user := range []string{"alice", "bob"} {
+		for i := 0; i < 30; i++ {
+			lu := owntracks.LocationUpdate{
+				T:         start.Add(time.Duration(i) * time.Minute),
+				User:      user,
+				TrackerID: "phone",
+				Latitude:  50 + float64(i)*0.002,
+				Longitude: 8,
+				Velocity:  (i % 5) * 30,
+				Course:    (i % 3) * 90,
+			}
+			if err := store.InsertPosition(lu); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	places, _ := storage.OpenPlaces("")
+	places.Put(storage.Place{User: "", Name: "office", Latitude: 50.02, Longitude: 8, Radius: 2000})
+	pois, _ := storage.OpenPOIs("")
+	pois.Put(storage.POI{User: "", Name: "bakery", Latitude: 50.01, Longitude: 8})
+	a := &API{
+		Store:      store,
+		Logger:     log.New(io.Discard, "", 0),
+		PlaceStore: places,
+		POIStore:   pois,
+	}
+	mux := http.NewServeMux()
+	a.RegisterRoutes(middleware.NewRouter(mux))
+	at := start.Add(10 * time.Minute).Format(time.RFC3339)
+	for _, u := range []string{
+		"/api/track?user=alice&values=speed,time",
+		"/api/compare?users=alice,bob&date=" + start.Format("2006-01-02"),
+		"/api/interpolate?user=alice&times=" + at,
+		"/api/driving?user=alice",
+		"/api/timesheet?period=day&from=" + start.Format(time.RFC3339) + "&to=" + start.Add(time.Hour).Format(time.RFC3339),
+		"/api/layers/pois",
+	} {
+		var res [2]interface{}
+		for i, accept := range []string{"application/json", contentTypeMsgpack} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", u, nil)
+			r.Header.Set("Accept", accept)
+			mux.ServeHTTP(w, r)
+			if w.Code != 200 {
+				t.Fatalf("%s as %s: %d %s", u, accept, w.Code, w.Body)
+			}
+			if i == 0 {
+				err = json.Unmarshal(w.Body.Bytes(), &res[i])
+			} else {
+				var rest []byte
+				if res[i], rest, err = decodeMsgpack(w.Body.Bytes()); err == nil && len(rest) > 0 {
+					err = fmt.Errorf("%d bytes left", len(rest))
+				}
+			}
+			if err != nil {
+				t.Fatalf("%s as %s: %v", u, accept, err)
+			}
+		}
+		if !reflect.DeepEqual(res[0], res[1]) {
+			t.Errorf("%s: MessagePack decodes to\n%v\nJSON to\n%v", u, res[1], res[0])
+		}
+	}
+}
+
+// decodeMsgpack decodes the first value of b into the types encoding/json
+// decodes to, and returns the remaining bytes.
+func decodeMsgpack(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end")
+	}
+	c, b := b[0], b[1:]
+	n := 0
+	switch {
+	case c <= 0x7f:
+		return float64(c), b, nil
+	case c >= 0xe0:
+		return float64(int8(c)), b, nil
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+		return string(b[:n]), b[n:], nil
+	case c&0xf0 == 0x90:
+		return decodeMsgpackArray(b, int(c&0x0f))
+	case c&0xf0 == 0x80:
+		return decodeMsgpackMap(b, int(c&0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xd2:
+		return float64(int32(binary.BigEndian.Uint32(b))), b[4:], nil
+	case 0xd3:
+		return float64(int64(binary.BigEndian.Uint64(b))), b[8:], nil
+	case 0xcf:
+		return float64(binary.BigEndian.Uint64(b)), b[8:], nil
+	case 0xcb:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	case 0xd9:
+		n, b = int(b[0]), b[1:]
+		return string(b[:n]), b[n:], nil
+	case 0xda:
+		n, b = int(binary.BigEndian.Uint16(b)), b[2:]
+		return string(b[:n]), b[n:], nil
+	case 0xdb:
+		n, b = int(binary.BigEndian.Uint32(b)), b[4:]
+		return string(b[:n]), b[n:], nil
+	case 0xdc:
+		return decodeMsgpackArray(b[2:], int(binary.BigEndian.Uint16(b)))
+	case 0xdd:
+		return decodeMsgpackArray(b[4:], int(binary.BigEndian.Uint32(b)))
+	case 0xde:
+		return decodeMsgpackMap(b[2:], int(binary.BigEndian.Uint16(b)))
+	case 0xdf:
+		return decodeMsgpackMap(b[4:], int(binary.BigEndian.Uint32(b)))
+	}
+	return nil, nil, fmt.Errorf("unexpected type byte %#x", c)
+}
+
+func decodeMsgpackArray(b []byte, n int) (interface{}, []byte, error) {
+	a := make([]interface{}, n)
+	var err error
+	for i := range a {
+		if a[i], b, err = decodeMsgpack(b); err != nil {
+			return nil, nil, err
+		}
+	}
+	return a, b, nil
+}
+
+func decodeMsgpackMap(b []byte, n int) (interface{}, []byte, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		var k, e interface{}
+		var err error
+		if k, b, err = decodeMsgpack(b); err != nil {
+			return nil, nil, err
+		}
+		if e, b, err = decodeMsgpack(b); err != nil {
+			return nil, nil, err
+		}
+		m[k.(string)] = e
+	}
+	return m, b, nil
+}
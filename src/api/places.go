@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"auth"
+	"owntracks"
+	"storage"
+)
+
+// minVisit is the shortest stay inside a place that counts as a visit.
+const minVisit = 5 * time.Minute
+
+// Visit is a stay of a user inside one of their places.
+type Visit struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Places lists the places of the authenticated user on GET and adds the
+// place in the JSON body on POST.
+func (a *API) Places(w http.ResponseWriter, r *http.Request) {
+	if a.PlaceStore == nil {
+		http.Error(w, "Places are not available", http.StatusNotImplemented)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, a.PlaceStore.List(auth.User(r)))
+	case "POST":
+		var p storage.Place
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "Bad place: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.ID, p.User = "", auth.User(r)
+		a.putPlace(w, p, http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Place serves /api/places/<id>: GET returns the place, PUT replaces it
+// with the JSON body and DELETE removes it. GET /api/places/<id>/visits
+// returns the visits of the place between the parameters from and to.
+func (a *API) Place(w http.ResponseWriter, r *http.Request) {
+	if a.PlaceStore == nil {
+		http.Error(w, "Places are not available", http.StatusNotImplemented)
+		return
+	}
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/places/"), "/")
+	p, ok := a.PlaceStore.Get(id)
+	if !ok || p.User != auth.User(r) || (sub != "" && sub != "visits") {
+		a.NotFound(w, r)
+		return
+	}
+	if sub == "visits" {
+		a.placeVisits(w, r, p)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, p)
+	case "PUT":
+		var np storage.Place
+		if err := json.NewDecoder(r.Body).Decode(&np); err != nil {
+			http.Error(w, "Bad place: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		np.ID, np.User = p.ID, p.User
+		a.putPlace(w, np, http.StatusOK)
+	case "DELETE":
+		if err := a.PlaceStore.Delete(p.ID); err != nil {
+			a.serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putPlace validates and stores p and sends it back with status code.
+func (a *API) putPlace(w http.ResponseWriter, p storage.Place, code int) {
+	if err := validatePlace(p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := a.PlaceStore.Put(p)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	writeJSONStatus(w, code, p)
+}
+
+func validatePlace(p storage.Place) error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("name must not be empty")
+	}
+	if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+		return errors.New("center out of range")
+	}
+	if p.Radius <= 0 || p.Radius > 100000 {
+		return errors.New("radius must be between 0 and 100000 m")
+	}
+	return nil
+}
+
+// placeVisits sends the visits of place p between the parameters from and
+// to together with their count.
+func (a *API) placeVisits(w http.ResponseWriter, r *http.Request, p storage.Place) {
+	q := storage.Query{User: p.User}
+	var err error
+	if q.From, err = parseTime(r, "from"); err == nil {
+		q.To, err = parseTime(r, "to")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, err := a.Store.QueryPositions(q)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	// the positions of all devices of the user count
+	sort.SliceStable(t, func(i, j int) bool { return t[i].T.Before(t[j].T) })
+	visits := matchVisits(t, p)
+	writeJSON(w, struct {
+		Place  storage.Place `json:"place"`
+		Count  int           `json:"count"`
+		Visits []Visit       `json:"visits"`
+	}{p, len(visits), visits})
+}
+
+// matchVisits returns the stays of at least minVisit inside p in track t,
+// which must be sorted by time.
+func matchVisits(t []owntracks.LocationUpdate, p storage.Place) []Visit {
+	center := owntracks.LocationUpdate{Latitude: p.Latitude, Longitude: p.Longitude}
+	visits := []Visit{}
+	var cur *Visit
+	for _, lu := range t {
+		inside := distance(center, lu) <= p.Radius
+		switch {
+		case inside && cur == nil:
+			cur = &Visit{Start: lu.T, End: lu.T}
+		case inside:
+			cur.End = lu.T
+		case cur != nil:
+			if cur.End.Sub(cur.Start) >= minVisit {
+				visits = append(visits, *cur)
+			}
+			cur = nil
+		}
+	}
+	if cur != nil && cur.End.Sub(cur.Start) >= minVisit {
+		visits = append(visits, *cur)
+	}
+	return visits
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"auth"
+	"storage"
+)
+
+// POIs lists the POIs of the authenticated user on GET and adds the POI in
+// the JSON body on POST.
+func (a *API) POIs(w http.ResponseWriter, r *http.Request) {
+	if a.POIStore == nil {
+		http.Error(w, "POIs are not available", http.StatusNotImplemented)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, a.POIStore.List(auth.User(r)))
+	case "POST":
+		var p storage.POI
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "Bad POI: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.ID, p.User = "", auth.User(r)
+		a.putPOI(w, p, http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// POI serves /api/pois/<id>: GET returns the POI, PUT replaces it with the
+// JSON body and DELETE removes it.
+func (a *API) POI(w http.ResponseWriter, r *http.Request) {
+	if a.POIStore == nil {
+		http.Error(w, "POIs are not available", http.StatusNotImplemented)
+		return
+	}
+	p, ok := a.POIStore.Get(strings.TrimPrefix(r.URL.Path, "/api/pois/"))
+	if !ok || p.User != auth.User(r) {
+		a.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, p)
+	case "PUT":
+		var np storage.POI
+		if err := json.NewDecoder(r.Body).Decode(&np); err != nil {
+			http.Error(w, "Bad POI: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		np.ID, np.User = p.ID, p.User
+		a.putPOI(w, np, http.StatusOK)
+	case "DELETE":
+		if err := a.POIStore.Delete(p.ID); err != nil {
+			a.serverError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putPOI validates and stores p and sends it back with status code.
+func (a *API) putPOI(w http.ResponseWriter, p storage.POI, code int) {
+	if strings.TrimSpace(p.Name) == "" {
+		http.Error(w, "name must not be empty", http.StatusBadRequest)
+		return
+	}
+	if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+		http.Error(w, "coordinates out of range", http.StatusBadRequest)
+		return
+	}
+	p, err := a.POIStore.Put(p)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	writeJSONStatus(w, code, p)
+}
+
+// POILayer returns the POIs of the authenticated user as a GeoJSON
+// FeatureCollection of points for the map.
+func (a *API) POILayer(w http.ResponseWriter, r *http.Request) {
+	fc := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	if a.POIStore != nil {
+		for _, p := range a.POIStore.List(auth.User(r)) {
+			var f Feature
+			f.Type = "Feature"
+			f.Properties = map[string]string{
+				"ID":    p.ID,
+				"Name":  p.Name,
+				"Icon":  p.Icon,
+				"Notes": p.Notes,
+			}
+			f.Geometry.Type = "Point"
+			f.Geometry.Coordinates = []float64{p.Longitude, p.Latitude}
+			fc.Features = append(fc.Features, f)
+		}
+	}
+	writeEncoded(w, r, fc)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"middleware"
+	"owntracks"
+	"storage"
+)
+
+const (
+	// pollDefaultLimit and pollMaxLimit bound the items of one poll.
+	pollDefaultLimit = 50
+	pollMaxLimit     = 500
+	// pollFirstWindow is how far the first poll without cursor looks back.
+	pollFirstWindow = 24 * time.Hour
+)
+
+// PollItem is a position as returned to automation platforms. ID is stable
+// for a position, so that platforms can drop items they have seen before.
+type PollItem struct {
+	ID        string    `json:"id"`
+	Timestamp int64     `json:"timestamp"`
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Tracker   string    `json:"tracker"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Accuracy  int       `json:"accuracy"`
+	Velocity  int       `json:"velocity"`
+	Battery   int       `json:"battery"`
+}
+
+// PollPage is the response of a poll endpoint. Cursor is passed as cursor
+// parameter of the next poll to get only newer items.
+type PollPage struct {
+	Items  []PollItem `json:"items"`
+	Cursor string     `json:"cursor"`
+}
+
+// RegisterPollRoutes registers the endpoints for automation platforms like
+// Zapier or IFTTT with r. They authenticate with the tokens in PollTokens
+// instead of the login of the server.
+func (a *API) RegisterPollRoutes(r *middleware.Router) {
+	r.HandleFunc("/api/poll/positions", a.PollPositions)
+}
+
+// pollUser returns the user of the token of r, given as bearer token or as
+// parameter token.
+func (a *API) pollUser(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.FormValue("token")
+	}
+	user, ok := a.PollTokens[token]
+	return user, ok && token != ""
+}
+
+// PollPositions returns the positions of the user of the token that are
+// newer than the parameter cursor, newest first. Without cursor, the latest
+// positions of the last day are returned. The parameter limit caps the
+// number of items.
+func (a *API) PollPositions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, ok := a.pollUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit := pollDefaultLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > pollMaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", pollMaxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var since time.Time
+	if v := r.FormValue("cursor"); v != "" {
+		ns, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		since = time.Unix(0, ns)
+	}
+
+	q := storage.Query{User: user, From: since}
+	if since.IsZero() {
+		q.From = time.Now().Add(-pollFirstWindow)
+	}
+	positions, err := a.Store.QueryPositions(q)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	var newer []owntracks.LocationUpdate
+	for _, lu := range positions {
+		if lu.T.After(since) {
+			newer = append(newer, lu)
+		}
+	}
+	// positions are sorted by device, the cursor needs them by time
+	sort.SliceStable(newer, func(i, j int) bool { return newer[i].T.Before(newer[j].T) })
+	if len(newer) > limit {
+		if since.IsZero() {
+			// the first poll starts at the latest positions
+			newer = newer[len(newer)-limit:]
+		} else {
+			// positions sharing the time of the last item must not be
+			// split up, the cursor would skip the rest of them
+			n := limit
+			for n < len(newer) && newer[n].T.Equal(newer[limit-1].T) {
+				n++
+			}
+			newer = newer[:n]
+		}
+	}
+
+	page := PollPage{Items: []PollItem{}, Cursor: r.FormValue("cursor")}
+	for i := len(newer) - 1; i >= 0; i-- {
+		lu := newer[i]
+		page.Items = append(page.Items, PollItem{
+			ID:        fmt.Sprintf("%s/%d", storage.DeviceName(lu), lu.T.UnixNano()),
+			Timestamp: lu.T.Unix(),
+			Time:      lu.T,
+			User:      lu.User,
+			Tracker:   lu.TrackerID,
+			Latitude:  lu.Latitude,
+			Longitude: lu.Longitude,
+			Accuracy:  lu.Accuracy,
+			Velocity:  lu.Velocity,
+			Battery:   lu.Battery,
+		})
+	}
+	if len(newer) > 0 {
+		page.Cursor = strconv.FormatInt(newer[len(newer)-1].T.UnixNano(), 10)
+	}
+	writeJSON(w, page)
+}
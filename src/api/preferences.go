@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"auth"
+	"i18n"
+	"ingest"
+	"storage"
+)
+
+// preferences returns the preferences of the user of r.
+func (a *API) preferences(r *http.Request) storage.Preferences {
+	if a.Preferences == nil {
+		return storage.DefaultPreferences
+	}
+	return a.Preferences.Get(auth.User(r))
+}
+
+// location returns the time zone preferred by the user of r.
+func (a *API) location(r *http.Request) *time.Location {
+	if loc, err := time.LoadLocation(a.preferences(r).Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// MyPreferences returns the preferences of the authenticated user on GET
+// and replaces them with the JSON body on PUT.
+func (a *API) MyPreferences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, a.preferences(r))
+	case "PUT":
+		if a.Preferences == nil {
+			http.Error(w, "Preferences are not available", http.StatusNotImplemented)
+			return
+		}
+		p := storage.DefaultPreferences
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "Bad preferences: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.validatePreferences(p, auth.User(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.Preferences.Set(auth.User(r), p); err != nil {
+			a.serverError(w, err)
+			return
+		}
+		writeJSON(w, p)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validatePreferences checks the preferences p of user. Privacy rules must
+// be able to apply, so they need a user and existing places.
+func (a *API) validatePreferences(p storage.Preferences, user string) error {
+	if p.Units != storage.Metric && p.Units != storage.Imperial {
+		return fmt.Errorf("units must be %q or %q", storage.Metric, storage.Imperial)
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", p.Timezone)
+	}
+	if p.Center[0] < -90 || p.Center[0] > 90 || p.Center[1] < -180 || p.Center[1] > 180 {
+		return fmt.Errorf("center out of range")
+	}
+	if p.Language != "" && !i18n.IsSupported(p.Language) {
+		return fmt.Errorf("unsupported language %q", p.Language)
+	}
+	if p.Zoom < 0 || p.Zoom > 20 {
+		return fmt.Errorf("zoom must be between 0 and 20")
+	}
+	if len(p.PrivacyRules) > 0 && user == "" {
+		// positions always have a user, so the rules would never apply
+		return fmt.Errorf("privacy rules need an authenticated user")
+	}
+	for _, r := range p.PrivacyRules {
+		if err := ingest.CheckPrivacyRule(r); err != nil {
+			return err
+		}
+		if r.Place != "" && !a.hasPlace(user, r.Place) {
+			return fmt.Errorf("privacy rule refers to unknown place %q", r.Place)
+		}
+	}
+	return nil
+}
+
+// hasPlace reports whether user has a place with the given name.
+func (a *API) hasPlace(user, name string) bool {
+	if a.PlaceStore == nil {
+		return false
+	}
+	for _, p := range a.PlaceStore.List(user) {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
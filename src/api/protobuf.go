@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"owntracks"
+)
+
+// The protobuf messages are encoded by hand, following the schema in
+// positions.proto.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendProtoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendProtoInt(b []byte, field int, i int64) []byte {
+	if i == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(i))
+}
+
+func appendProtoDouble(b []byte, field int, f float64) []byte {
+	if f == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+// marshalPositionProto encodes lu as a Position message.
+func marshalPositionProto(lu owntracks.LocationUpdate) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, lu.User)
+	b = appendProtoString(b, 2, lu.TrackerID)
+	b = appendProtoString(b, 3, lu.ClientID)
+	b = appendProtoInt(b, 4, lu.T.UnixNano()/int64(time.Millisecond))
+	b = appendProtoDouble(b, 5, lu.Latitude)
+	b = appendProtoDouble(b, 6, lu.Longitude)
+	b = appendProtoInt(b, 7, int64(lu.Accuracy))
+	b = appendProtoInt(b, 8, int64(lu.Battery))
+	b = appendProtoInt(b, 9, int64(lu.Altitude))
+	b = appendProtoInt(b, 10, int64(lu.Velocity))
+	b = appendProtoString(b, 11, lu.Description)
+	b = appendProtoString(b, 12, lu.Geohash)
+	return b
+}
+
+// marshalPositionsProto encodes l as a Positions message.
+func marshalPositionsProto(l []owntracks.LocationUpdate) []byte {
+	var b []byte
+	for _, lu := range l {
+		p := marshalPositionProto(lu)
+		b = appendTag(b, 1, wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(p)))
+		b = append(b, p...)
+	}
+	return b
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"owntracks"
+)
+
+// SegmentValues holds one value for every segment of a track, the line
+// between two consecutive points, for coloring the track with a gradient.
+type SegmentValues struct {
+	Unit string `json:"unit"`
+	// Min and Max are the values mapped to 0 and 1.
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// Normalized holds the values of the segments scaled to [0, 1].
+	Normalized []float64 `json:"normalized"`
+}
+
+// segmentKinds are the values that can be requested for segments, with
+// their units.
+var segmentKinds = map[string]string{
+	"speed":     "km/h",
+	"elevation": "m",
+	"time":      "h",
+}
+
+// parseSegmentKinds parses the comma separated list of segment values v.
+func parseSegmentKinds(v string) ([]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+	kinds := strings.Split(v, ",")
+	for _, k := range kinds {
+		if _, ok := segmentKinds[k]; !ok {
+			return nil, fmt.Errorf("invalid values %q, must be speed, elevation or time", k)
+		}
+	}
+	return kinds, nil
+}
+
+// segmentValues computes the values of kind for the segments of the track
+// t, with the time of day in loc.
+//
+// Speed and elevation are normalized between their 5th and 95th percentile,
+// so that a few GPS outliers do not flatten the gradient. The time of day is
+// always normalized to a full day, so that tracks of different days compare.
+func segmentValues(kind string, t []owntracks.LocationUpdate, loc *time.Location) SegmentValues {
+	sv := SegmentValues{Unit: segmentKinds[kind]}
+	if len(t) < 2 {
+		sv.Normalized = []float64{}
+		return sv
+	}
+	raw := make([]float64, len(t)-1)
+	for i := range raw {
+		a, b := t[i], t[i+1]
+		switch kind {
+		case "speed":
+			if dt := b.T.Sub(a.T).Hours(); dt > 0 {
+				raw[i] = distance(a, b) / 1000 / dt
+			} else {
+				raw[i] = float64(a.Velocity+b.Velocity) / 2
+			}
+		case "elevation":
+			raw[i] = float64(a.Altitude+b.Altitude) / 2
+		case "time":
+			mid := a.T.Add(b.T.Sub(a.T) / 2).In(loc)
+			h, m, s := mid.Clock()
+			raw[i] = float64(h) + float64(m)/60 + float64(s)/3600
+		}
+	}
+	if kind == "time" {
+		sv.Min, sv.Max = 0, 24
+	} else {
+		sorted := append([]float64(nil), raw...)
+		sort.Float64s(sorted)
+		sv.Min = sorted[len(sorted)*5/100]
+		sv.Max = sorted[(len(sorted)-1)*95/100]
+	}
+	sv.Normalized = make([]float64, len(raw))
+	for i, v := range raw {
+		if sv.Max > sv.Min {
+			v = (v - sv.Min) / (sv.Max - sv.Min)
+		} else {
+			v = 0
+		}
+		// rounded to keep the response small
+		sv.Normalized[i] = math.Round(math.Max(0, math.Min(1, v))*1000) / 1000
+	}
+	return sv
+}
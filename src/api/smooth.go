@@ -0,0 +1,41 @@
+package api
+
+import "owntracks"
+
+// smoothWindow is the number of points averaged by smoothTrack.
+const smoothWindow = 5
+
+// smoothTrack returns the coordinates of the track t with GPS jitter evened
+// out by averaging every point with its neighbours, weighted by accuracy.
+// The first and last point are kept, so that the track still starts and ends
+// where it was recorded. t is not modified.
+func smoothTrack(t []owntracks.LocationUpdate) [][2]float64 {
+	coords := make([][2]float64, len(t))
+	for i, lu := range t {
+		if i == 0 || i == len(t)-1 {
+			coords[i] = [2]float64{lu.Longitude, lu.Latitude}
+			continue
+		}
+		lo, hi := i-smoothWindow/2, i+smoothWindow/2+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(t) {
+			hi = len(t)
+		}
+		var lat, lon, sum float64
+		for _, n := range t[lo:hi] {
+			// points without accuracy count like ones with 10 m
+			acc := float64(n.Accuracy)
+			if acc <= 0 {
+				acc = 10
+			}
+			w := 1 / (acc * acc)
+			lat += n.Latitude * w
+			lon += n.Longitude * w
+			sum += w
+		}
+		coords[i] = [2]float64{lon / sum, lat / sum}
+	}
+	return coords
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"geo"
+	"owntracks"
+	"storage"
+)
+
+const (
+	// speedTileSize is the size in degrees of the tiles in which the roads
+	// are fetched and cached.
+	speedTileSize = 0.02
+	// maxSpeedTiles is the number of tiles a SpeedLimits keeps.
+	maxSpeedTiles = 500
+	// maxReportTiles is the number of tiles one report may need.
+	maxReportTiles = 100
+	// speedRoadDistance is how far in [m] a position may be from a road to
+	// be on it, unless its accuracy is worse.
+	speedRoadDistance = 25
+	// mphToKmh converts speed limits given in mph.
+	mphToKmh = 1.609344
+)
+
+// errTooManyTiles is returned if a report covers too large an area.
+var errTooManyTiles = errors.New("the tracks cover too large an area, select a shorter time span")
+
+// SpeedLimits looks up the speed limits of roads from OpenStreetMap, with
+// the Overpass API. The roads are fetched in tiles, which are kept, so
+// that every area is only queried once.
+type SpeedLimits struct {
+	// URL is the interpreter endpoint of an Overpass server, e.g.
+	// "https://overpass-api.de/api/interpreter".
+	URL    string
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[[2]int][]road
+}
+
+// road is an OSM way with a speed limit, in [km/h].
+type road struct {
+	name  string
+	limit int
+	nodes [][2]float64 // latitude, longitude
+}
+
+// NewSpeedLimits returns a SpeedLimits for the Overpass server at url.
+func NewSpeedLimits(url string) *SpeedLimits {
+	return &SpeedLimits{
+		URL:    url,
+		Client: &http.Client{Timeout: 60 * time.Second},
+		cache:  make(map[[2]int][]road),
+	}
+}
+
+// parseMaxspeed returns the limit in [km/h] of an OSM maxspeed tag, or 0 if
+// it is not a number, like "none" or "DE:urban".
+func parseMaxspeed(v string) int {
+	v = strings.TrimSpace(v)
+	factor := 1.0
+	if n, ok := strings.CutSuffix(v, "mph"); ok {
+		v, factor = strings.TrimSpace(n), mphToKmh
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0
+	}
+	return int(math.Round(f * factor))
+}
+
+// tile returns the roads with speed limits in the tile k.
+func (s *SpeedLimits) tile(k [2]int) ([]road, error) {
+	s.mu.Lock()
+	roads, ok := s.cache[k]
+	s.mu.Unlock()
+	if ok {
+		return roads, nil
+	}
+	south, west := float64(k[0])*speedTileSize, float64(k[1])*speedTileSize
+	q := fmt.Sprintf(`[out:json][timeout:50];way["highway"]["maxspeed"](%f,%f,%f,%f);out tags geom;`,
+		south, west, south+speedTileSize, west+speedTileSize)
+	resp, err := s.Client.PostForm(s.URL, url.Values{"data": {q}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("overpass: %s", resp.Status)
+	}
+	var r struct {
+		Elements []struct {
+			Tags     map[string]string `json:"tags"`
+			Geometry []struct {
+				Lat float64 `json:"lat"`
+				Lon float64 `json:"lon"`
+			} `json:"geometry"`
+		} `json:"elements"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	roads = []road{}
+	for _, e := range r.Elements {
+		rd := road{name: e.Tags["name"], limit: parseMaxspeed(e.Tags["maxspeed"])}
+		if rd.limit == 0 || len(e.Geometry) < 2 {
+			continue
+		}
+		for _, g := range e.Geometry {
+			rd.nodes = append(rd.nodes, [2]float64{g.Lat, g.Lon})
+		}
+		roads = append(roads, rd)
+	}
+	s.mu.Lock()
+	if len(s.cache) >= maxSpeedTiles {
+		s.cache = make(map[[2]int][]road)
+	}
+	s.cache[k] = roads
+	s.mu.Unlock()
+	return roads, nil
+}
+
+// tileKey returns the key of the tile containing lat, lon.
+func tileKey(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / speedTileSize)), int(math.Floor(lon / speedTileSize))}
+}
+
+// limits returns the road and its speed limit for every position of t that
+// is moving, or nil if it is not on a road with known limit.
+func (s *SpeedLimits) limits(t []owntracks.LocationUpdate) ([]*road, error) {
+	tiles := make(map[[2]int][]road)
+	for _, lu := range t {
+		if lu.Velocity > 0 {
+			tiles[tileKey(lu.Latitude, lu.Longitude)] = nil
+		}
+	}
+	if len(tiles) > maxReportTiles {
+		return nil, errTooManyTiles
+	}
+	for k := range tiles {
+		roads, err := s.tile(k)
+		if err != nil {
+			return nil, err
+		}
+		tiles[k] = roads
+	}
+	res := make([]*road, len(t))
+	for i, lu := range t {
+		if lu.Velocity <= 0 {
+			continue
+		}
+		best := math.Max(speedRoadDistance, float64(lu.Accuracy))
+		roads := tiles[tileKey(lu.Latitude, lu.Longitude)]
+		for j := range roads {
+			if d := roadDistance(lu.Latitude, lu.Longitude, roads[j].nodes); d <= best {
+				best, res[i] = d, &roads[j]
+			}
+		}
+	}
+	return res, nil
+}
+
+// roadDistance returns the distance in [m] between the point lat, lon and
+// the polyline nodes. The earth is treated as flat around the point.
+func roadDistance(lat, lon float64, nodes [][2]float64) float64 {
+	kx := math.Cos(lat*math.Pi/180) * math.Pi / 180 * geo.EarthRadius
+	ky := math.Pi / 180 * geo.EarthRadius
+	best := math.Inf(1)
+	for i := 1; i < len(nodes); i++ {
+		ax, ay := (nodes[i-1][1]-lon)*kx, (nodes[i-1][0]-lat)*ky
+		bx, by := (nodes[i][1]-lon)*kx, (nodes[i][0]-lat)*ky
+		dx, dy := bx-ax, by-ay
+		f := 0.0
+		if l := dx*dx + dy*dy; l > 0 {
+			f = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+		}
+		best = math.Min(best, math.Hypot(ax+f*dx, ay+f*dy))
+	}
+	return best
+}
+
+// SpeedingSegment is a part of a track in which the speed limit was
+// exceeded. Speeds are in [km/h].
+type SpeedingSegment struct {
+	Start    time.Time    `json:"start"`
+	End      time.Time    `json:"end"`
+	Road     string       `json:"road"`
+	Limit    int          `json:"limit"`
+	MaxSpeed int          `json:"maxSpeed"`
+	Path     [][2]float64 `json:"path"`
+}
+
+// SpeedingReport lists the speeding of a device on one track.
+type SpeedingReport struct {
+	User    string    `json:"user"`
+	Tracker string    `json:"tracker"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Checked is the share of moving positions on roads with known limit.
+	Checked  float64           `json:"checked"`
+	Segments []SpeedingSegment `json:"segments"`
+}
+
+// Speeding compares the recorded speed of the tracks selected by the
+// parameters user, tracker, from and to with the speed limits of the roads
+// in OpenStreetMap, and reports the segments in which the limit was
+// exceeded by more than tolerance [km/h] (default 5).
+func (a *API) Speeding(w http.ResponseWriter, r *http.Request) {
+	if a.SpeedLimits == nil {
+		http.Error(w, "Speed limits are not available", http.StatusNotImplemented)
+		return
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tolerance := 5
+	if v := r.FormValue("tolerance"); v != "" {
+		if tolerance, err = strconv.Atoi(v); err != nil || tolerance < 0 {
+			http.Error(w, fmt.Sprintf("invalid tolerance: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	tracks, err := storage.Tracks(a.Store, q)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	loc := a.location(r)
+	reports := []SpeedingReport{}
+	for _, t := range tracks {
+		roads, err := a.SpeedLimits.limits(t)
+		if err == errTooManyTiles {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			a.Logger.Println(err)
+			http.Error(w, "Looking up the speed limits failed", http.StatusBadGateway)
+			return
+		}
+		reports = append(reports, speedingReport(t, roads, tolerance, loc))
+	}
+	writeEncoded(w, r, reports)
+}
+
+// speedingReport finds the runs of positions of t faster than the limit of
+// their road plus tolerance.
+func speedingReport(t []owntracks.LocationUpdate, roads []*road, tolerance int, loc *time.Location) SpeedingReport {
+	rep := SpeedingReport{
+		User:     t[0].User,
+		Tracker:  t[0].TrackerID,
+		Start:    t[0].T.In(loc),
+		End:      t[len(t)-1].T.In(loc),
+		Segments: []SpeedingSegment{},
+	}
+	var seg *SpeedingSegment
+	moving, checked := 0, 0
+	for i, lu := range t {
+		if lu.Velocity > 0 {
+			moving++
+		}
+		rd := roads[i]
+		if rd != nil {
+			checked++
+		}
+		if rd == nil || lu.Velocity <= rd.limit+tolerance {
+			seg = nil
+			continue
+		}
+		if seg == nil || seg.Limit != rd.limit {
+			rep.Segments = append(rep.Segments, SpeedingSegment{Start: lu.T.In(loc), Road: rd.name, Limit: rd.limit})
+			seg = &rep.Segments[len(rep.Segments)-1]
+		}
+		seg.End = lu.T.In(loc)
+		if lu.Velocity > seg.MaxSpeed {
+			seg.MaxSpeed = lu.Velocity
+		}
+		seg.Path = append(seg.Path, [2]float64{lu.Longitude, lu.Latitude})
+	}
+	if moving > 0 {
+		rep.Checked = float64(checked) / float64(moving)
+	}
+	return rep
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"auth"
+	"storage"
+)
+
+// timesheetDefaultWeeks is the number of weeks a time sheet covers without
+// the parameter from.
+const timesheetDefaultWeeks = 4
+
+// Presence is a visit of a place in a time sheet. Hours is its duration.
+type Presence struct {
+	Place string    `json:"place"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Hours float64   `json:"hours"`
+}
+
+// PresenceTotal is the time spent at a place in the day or week starting at
+// Start.
+type PresenceTotal struct {
+	Place string    `json:"place"`
+	Start time.Time `json:"start"`
+	Hours float64   `json:"hours"`
+}
+
+// Timesheet is the response of Timesheet.
+type Timesheet struct {
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Intervals []Presence      `json:"intervals"`
+	Totals    []PresenceTotal `json:"totals"`
+}
+
+// Timesheet turns the visits of the places of the authenticated user into
+// a time sheet, e.g. of the time spent at the office. The parameter places
+// lists the IDs of the places, separated by commas, and defaults to all of
+// them. The time sheet covers the parameters from and to, by default the
+// last four weeks, and sums up the hours per day or week as given by the
+// parameter period (default week) in the preferred time zone.
+//
+// With format=csv, the intervals are sent as CSV, with format=csv-totals the
+// totals.
+func (a *API) Timesheet(w http.ResponseWriter, r *http.Request) {
+	if a.PlaceStore == nil {
+		http.Error(w, "Places are not available", http.StatusNotImplemented)
+		return
+	}
+	user := auth.User(r)
+	loc := a.location(r)
+	var places []storage.Place
+	if v := r.FormValue("places"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			p, ok := a.PlaceStore.Get(strings.TrimSpace(id))
+			if !ok || p.User != user {
+				http.Error(w, fmt.Sprintf("unknown place %q", id), http.StatusBadRequest)
+				return
+			}
+			places = append(places, p)
+		}
+	} else {
+		places = a.PlaceStore.List(user)
+	}
+	period := r.FormValue("period")
+	switch period {
+	case "":
+		period = "week"
+	case "day", "week":
+	default:
+		http.Error(w, fmt.Sprintf("invalid period: %q, must be day or week", period), http.StatusBadRequest)
+		return
+	}
+	q := storage.Query{User: user}
+	var err error
+	if q.From, err = parseTime(r, "from"); err == nil {
+		q.To, err = parseTime(r, "to")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		q.From = periodStart(q.To.In(loc), "week").AddDate(0, 0, -7*(timesheetDefaultWeeks-1))
+	}
+	t, err := a.Store.QueryPositions(q)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	// the positions of all devices of the user count
+	sort.SliceStable(t, func(i, j int) bool { return t[i].T.Before(t[j].T) })
+
+	ts := Timesheet{From: q.From.In(loc), To: q.To.In(loc), Intervals: []Presence{}, Totals: []PresenceTotal{}}
+	totals := make(map[string]map[time.Time]time.Duration)
+	for _, p := range places {
+		totals[p.Name] = make(map[time.Time]time.Duration)
+		for _, v := range matchVisits(t, p) {
+			start, end := v.Start.In(loc), v.End.In(loc)
+			ts.Intervals = append(ts.Intervals, Presence{p.Name, start, end, hours(end.Sub(start))})
+			// visits across midnight count for both days
+			for s := start; s.Before(end); {
+				ps := periodStart(s, period)
+				next := nextPeriod(ps, period)
+				e := end
+				if next.Before(e) {
+					e = next
+				}
+				totals[p.Name][ps] += e.Sub(s)
+				s = e
+			}
+		}
+	}
+	sort.SliceStable(ts.Intervals, func(i, j int) bool { return ts.Intervals[i].Start.Before(ts.Intervals[j].Start) })
+	for name, byPeriod := range totals {
+		for start, d := range byPeriod {
+			ts.Totals = append(ts.Totals, PresenceTotal{name, start, hours(d)})
+		}
+	}
+	sort.Slice(ts.Totals, func(i, j int) bool {
+		a, b := ts.Totals[i], ts.Totals[j]
+		return a.Start.Before(b.Start) || (a.Start.Equal(b.Start) && a.Place < b.Place)
+	})
+
+	switch r.FormValue("format") {
+	case "csv":
+		rows := [][]string{{"place", "start", "end", "hours"}}
+		for _, p := range ts.Intervals {
+			rows = append(rows, []string{p.Place, p.Start.Format(time.RFC3339), p.End.Format(time.RFC3339), fmt.Sprint(p.Hours)})
+		}
+		writeCSV(w, "timesheet.csv", rows)
+	case "csv-totals":
+		rows := [][]string{{"place", period, "hours"}}
+		for _, p := range ts.Totals {
+			rows = append(rows, []string{p.Place, p.Start.Format("2006-01-02"), fmt.Sprint(p.Hours)})
+		}
+		writeCSV(w, "timesheet-totals.csv", rows)
+	default:
+		writeEncoded(w, r, ts)
+	}
+}
+
+// hours returns d in hours, rounded to two decimals.
+func hours(d time.Duration) float64 {
+	return math.Round(d.Hours()*100) / 100
+}
+
+// periodStart returns the start of the day or the week, starting on Monday,
+// containing t, in the location of t.
+func periodStart(t time.Time, period string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == "week" {
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// nextPeriod returns the start of the period following the one starting at
+// start.
+func nextPeriod(start time.Time, period string) time.Time {
+	if period == "week" {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// writeCSV sends rows as CSV file named name.
+func writeCSV(w http.ResponseWriter, name string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	cw := csv.NewWriter(w)
+	cw.WriteAll(rows)
+}
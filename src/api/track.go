@@ -0,0 +1,179 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+// parseTime parses the request parameter name as RFC 3339 timestamp or as
+// seconds since the Unix epoch. Missing parameters yield the zero time.
+func parseTime(r *http.Request, name string) (time.Time, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return t, nil
+}
+
+// parseQuery builds a storage.Query from the parameters user, tracker, from,
+// to, geohash and every of r. every, like "60s" or "5m", keeps only the last
+// position of each device per interval, which thins out long time spans for
+// charts more cheaply than simplifying the geometry.
+func parseQuery(r *http.Request) (storage.Query, error) {
+	q := storage.Query{
+		User:      r.FormValue("user"),
+		TrackerID: r.FormValue("tracker"),
+		Geohash:   r.FormValue("geohash"),
+	}
+	var err error
+	if q.From, err = parseTime(r, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTime(r, "to"); err != nil {
+		return q, err
+	}
+	if v := r.FormValue("every"); v != "" {
+		if q.Every, err = time.ParseDuration(v); err != nil || q.Every < time.Second {
+			return q, fmt.Errorf("invalid every: %q, must be a duration of at least 1s", v)
+		}
+	}
+	return q, nil
+}
+
+// LineFeature is a GeoJSON feature with a LineString geometry.
+type LineFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   struct {
+		Type        string       `json:"type"`
+		Coordinates [][2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// lineFeature returns the track t as a LineFeature with times in loc.
+func lineFeature(t []owntracks.LocationUpdate, loc *time.Location) LineFeature {
+	var f LineFeature
+	f.Type = "Feature"
+	f.Properties = map[string]interface{}{
+		"User":    t[0].User,
+		"Tracker": t[0].TrackerID,
+		"Start":   t[0].T.In(loc),
+		"End":     t[len(t)-1].T.In(loc),
+		// from the recorded points, also if the geometry gets matched
+		"Elevation": elevationStats(t),
+	}
+	f.Geometry.Type = "LineString"
+	f.Geometry.Coordinates = make([][2]float64, len(t))
+	for i, lu := range t {
+		f.Geometry.Coordinates[i] = [2]float64{lu.Longitude, lu.Latitude}
+	}
+	return f
+}
+
+// Track returns the tracks of all devices selected by the parameters user,
+// tracker, from, to, geohash and every as a GeoJSON FeatureCollection of
+// LineStrings.
+//
+// The parameter variant selects the geometry of the tracks. It is either raw,
+// the recorded positions, which is the default, smoothed, with the GPS
+// jitter evened out, or matched, snapped to the road network by the
+// configured map matching service. matched=true is the same as
+// variant=matched. Only the returned geometry is derived, the stored
+// positions are never changed.
+//
+// The parameter values, a comma separated list of speed, elevation and time,
+// adds the SegmentValues of every requested kind to the property Values of
+// each track. They are computed from the recorded positions. It cannot be
+// combined with variant=matched, because the matched geometry has different
+// segments.
+//
+// With derive=1, the property coordinateProperties holds the DerivedValues
+// of each track, speed, distance and time from the previous point, as arrays
+// parallel to the coordinates. Like values, it is computed from the recorded
+// positions and cannot be combined with variant=matched.
+func (a *API) Track(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kinds, err := parseSegmentKinds(r.FormValue("values"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	derive := r.FormValue("derive") == "1" || r.FormValue("derive") == "true"
+	variant := r.FormValue("variant")
+	switch {
+	case r.FormValue("matched") == "true":
+		variant = "matched"
+	case variant == "":
+		variant = "raw"
+	}
+	switch variant {
+	case "raw", "smoothed":
+	case "matched":
+		if a.Matcher == nil {
+			http.Error(w, "Map matching is not configured", http.StatusNotImplemented)
+			return
+		}
+		if len(kinds) > 0 || derive {
+			http.Error(w, "values and derive cannot be combined with variant=matched", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("invalid variant %q, must be raw, smoothed or matched", variant), http.StatusBadRequest)
+		return
+	}
+	tracks, err := storage.Tracks(a.Store, q)
+	if err != nil {
+		a.serverError(w, err)
+		return
+	}
+	fc := struct {
+		Type     string        `json:"type"`
+		Features []LineFeature `json:"features"`
+	}{Type: "FeatureCollection", Features: []LineFeature{}}
+	loc := a.location(r)
+	for _, t := range tracks {
+		f := lineFeature(t, loc)
+		f.Properties["Variant"] = variant
+		switch variant {
+		case "smoothed":
+			f.Geometry.Coordinates = smoothTrack(t)
+		case "matched":
+			coords, err := a.Matcher.Match(t)
+			if err != nil {
+				a.Logger.Printf("Map matching %s failed: %v", storage.DeviceName(t[0]), err)
+				http.Error(w, "Map matching failed", http.StatusBadGateway)
+				return
+			}
+			f.Geometry.Coordinates = coords
+			f.Properties["Matched"] = true
+		}
+		if len(kinds) > 0 {
+			values := make(map[string]SegmentValues)
+			for _, k := range kinds {
+				values[k] = segmentValues(k, t, loc)
+			}
+			f.Properties["Values"] = values
+		}
+		if derive {
+			f.Properties["coordinateProperties"] = deriveValues(t)
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	writeEncoded(w, r, fc)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// what3wordsURL is the conversion endpoint of the what3words API.
+const what3wordsURL = "https://api.what3words.com/v3/convert-to-3wa"
+
+// maxWhat3Words is the number of addresses a What3Words keeps.
+const maxWhat3Words = 10000
+
+// What3Words converts coordinates to what3words addresses with the API of
+// what3words. Addresses are kept, so every 3 meter square is only sent to
+// the API once.
+type What3Words struct {
+	// Key is the API key of what3words.
+	Key string
+	// Language is the language of the addresses, e.g. "en".
+	Language string
+	Client   *http.Client
+
+	mu    sync.Mutex
+	cache map[[2]int64]string
+}
+
+// NewWhat3Words returns a What3Words using the API key key.
+func NewWhat3Words(key, language string) *What3Words {
+	if language == "" {
+		language = "en"
+	}
+	return &What3Words{
+		Key:      key,
+		Language: language,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+		cache:    make(map[[2]int64]string),
+	}
+}
+
+// Words returns the what3words address of the coordinates lat and lon, like
+// "filled.count.soap".
+func (w *What3Words) Words(lat, lon float64) (string, error) {
+	// the squares are 3 meters wide, coordinates closer than about a meter
+	// share an entry
+	key := [2]int64{int64(lat * 1e5), int64(lon * 1e5)}
+	w.mu.Lock()
+	words, ok := w.cache[key]
+	w.mu.Unlock()
+	if ok {
+		return words, nil
+	}
+	v := url.Values{
+		"coordinates": {fmt.Sprintf("%f,%f", lat, lon)},
+		"language":    {w.Language},
+		"key":         {w.Key},
+	}
+	resp, err := w.Client.Get(what3wordsURL + "?" + v.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Words string `json:"words"`
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", fmt.Errorf("what3words: %s", resp.Status)
+	}
+	if r.Words == "" {
+		return "", fmt.Errorf("what3words: %s: %s", r.Error.Code, r.Error.Message)
+	}
+	w.mu.Lock()
+	if len(w.cache) >= maxWhat3Words {
+		w.cache = make(map[[2]int64]string)
+	}
+	w.cache[key] = r.Words
+	w.mu.Unlock()
+	return r.Words, nil
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AccessRule restricts the clients that may access all paths starting with
+// Prefix. Networks are given in CIDR notation or as single IP addresses.
+// A client matching any network in Deny is rejected. If Allow is not empty,
+// only clients matching one of its networks are let through.
+type AccessRule struct {
+	Prefix string
+	Allow  []string
+	Deny   []string
+}
+
+type accessRule struct {
+	prefix string
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+}
+
+// parseNetworks converts a list of CIDR strings or IP addresses to networks.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP returns the IP address of the client that sent r.
+func RemoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// AccessFilter wraps h so that every request is checked against the rule
+// with the longest prefix matching the request path. Requests that are not
+// allowed are answered with 403 Forbidden and logged to logger.
+func AccessFilter(h http.Handler, rules []AccessRule, logger *log.Logger) (http.Handler, error) {
+	if len(rules) == 0 {
+		return h, nil
+	}
+	var parsed []accessRule
+	for _, r := range rules {
+		allow, err := parseNetworks(r.Allow)
+		if err != nil {
+			return nil, fmt.Errorf("access rule %q: %v", r.Prefix, err)
+		}
+		deny, err := parseNetworks(r.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("access rule %q: %v", r.Prefix, err)
+		}
+		parsed = append(parsed, accessRule{prefix: r.Prefix, allow: allow, deny: deny})
+	}
+	f := func(w http.ResponseWriter, r *http.Request) {
+		var rule *accessRule
+		for i := range parsed {
+			p := &parsed[i]
+			if strings.HasPrefix(r.URL.Path, p.prefix) && (rule == nil || len(p.prefix) > len(rule.prefix)) {
+				rule = p
+			}
+		}
+		if rule != nil {
+			ip := RemoteIP(r)
+			if ip == nil || containsIP(rule.deny, ip) || (len(rule.allow) > 0 && !containsIP(rule.allow, ip)) {
+				logger.Printf("403 Forbidden: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(f), nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"middleware"
+)
+
+// flashCookie carries the flash messages to the next rendered page.
+const flashCookie = "daisser-flash"
+
+// flashCodes are the messages that can be requested with the error and info
+// query parameters. They are shown if the flash cookie got lost, e.g. because
+// the browser does not accept cookies.
+var flashCodes = map[string]string{
+	"invalid": "login.invalid",
+	"logout":  "logout.done",
+}
+
+// AddFlash adds msg to the messages shown on the next page rendered for the
+// client of r. msg is translated as a message key of package i18n when the
+// page is rendered.
+func AddFlash(w http.ResponseWriter, r *http.Request, msg string) {
+	var msgs []string
+	if c, err := r.Cookie(flashCookie); err == nil {
+		if v, err := url.QueryUnescape(c.Value); err == nil && v != "" {
+			msgs = strings.Split(v, "\n")
+		}
+	}
+	msgs = append(msgs, msg)
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    url.QueryEscape(strings.Join(msgs, "\n")),
+		Path:     middleware.Base(r) + "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Flashes returns the messages added with AddFlash and clears them. Without
+// flash cookie, the messages named by the error and info parameters of r are
+// returned instead.
+func Flashes(w http.ResponseWriter, r *http.Request) []string {
+	if c, err := r.Cookie(flashCookie); err == nil {
+		http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: middleware.Base(r) + "/", MaxAge: -1})
+		if v, err := url.QueryUnescape(c.Value); err == nil && v != "" {
+			return strings.Split(v, "\n")
+		}
+	}
+	var msgs []string
+	for _, p := range []string{"error", "info"} {
+		if msg, ok := flashCodes[r.URL.Query().Get(p)]; ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustProxies wraps h so that for requests coming from one of the trusted
+// proxies the client address is taken from X-Forwarded-For and the scheme
+// from X-Forwarded-Proto. Afterwards r.RemoteAddr holds the address of the
+// real client, so all further handlers can rely on it, and the request context
+// records that the request was forwarded by a trusted proxy. The headers of
+// requests from other addresses are ignored.
+func TrustProxies(h http.Handler, proxies []string) (http.Handler, error) {
+	if len(proxies) == 0 {
+		return h, nil
+	}
+	trusted, err := parseNetworks(proxies)
+	if err != nil {
+		return nil, err
+	}
+	f := func(w http.ResponseWriter, r *http.Request) {
+		ip := RemoteIP(r)
+		if ip == nil || !containsIP(trusted, ip) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// walk the chain of forwarders backwards, the first address not
+		// belonging to a trusted proxy is the client
+		hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !containsIP(trusted, hop) {
+				break
+			}
+		}
+		r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		switch proto := r.Header.Get("X-Forwarded-Proto"); proto {
+		case "http", "https":
+			r.URL.Scheme = proto
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), trustedProxyKey, true)))
+	}
+	return http.HandlerFunc(f), nil
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitStats counts the requests rejected by every rate limit, by
+// prefix.
+var rateLimitStats = expvar.NewMap("http_ratelimit")
+
+const (
+	// maxBuckets is the number of clients a rate limit tracks before
+	// buckets that are full again are dropped.
+	maxBuckets = 10000
+	// maxTokensPerIP is the number of tokens a rate limit by token tracks
+	// per address. Further tokens from the address share the bucket of the
+	// address, so that clients cannot escape the limit by making up tokens.
+	maxTokensPerIP = 64
+)
+
+// RateLimit restricts how often a client may request the paths starting
+// with Prefix. Limit is given as "<requests>/<period>", where the period
+// is "s", "m", "h" or a duration like "10s", e.g. "10/m". Clients may send
+// up to Burst requests at once, the default is the number of requests of
+// Limit. By selects how clients are told apart: "ip" by their address,
+// which is the default, and "token" by their address together with the
+// Authorization header or the token parameter, falling back to the address
+// alone without them or beyond maxTokensPerIP tokens.
+type RateLimit struct {
+	Prefix string
+	Limit  string
+	Burst  int
+	By     string
+}
+
+type rateLimit struct {
+	prefix string
+	// rate is the number of requests per second a client may send.
+	rate  float64
+	burst float64
+	by    string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// perIP counts the buckets of tokens by address.
+	perIP map[string]int
+}
+
+// bucket holds the requests left to a client at the time last. ip is the
+// address of the buckets of tokens.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	ip     string
+}
+
+// parseLimit returns the requests per second of a limit like "10/m" and
+// its number of requests.
+func parseLimit(s string) (float64, float64, error) {
+	n, per, ok := strings.Cut(s, "/")
+	count, err := strconv.ParseFloat(n, 64)
+	if !ok || err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid limit %q, expected <requests>/<period>", s)
+	}
+	var d time.Duration
+	switch per {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		if d, err = time.ParseDuration(per); err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("invalid period in limit %q", s)
+		}
+	}
+	return count / d.Seconds(), count, nil
+}
+
+// clientKey returns the address of the client of r and, for limits by
+// token, its token.
+func (l *rateLimit) clientKey(r *http.Request) (ip, token string) {
+	if i := RemoteIP(r); i != nil {
+		ip = i.String()
+	} else {
+		ip = r.RemoteAddr
+	}
+	if l.by == "token" {
+		if t := r.Header.Get("Authorization"); t != "" {
+			return ip, "auth " + t
+		}
+		// the body is left alone, it belongs to the handler
+		if t := r.URL.Query().Get("token"); t != "" {
+			return ip, "token " + t
+		}
+	}
+	return ip, ""
+}
+
+// take removes a request from the bucket of the client with the address ip
+// and token. If none is left, it returns false and the time until the next
+// one is available.
+func (l *rateLimit) take(ip, token string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := ip
+	if token != "" {
+		key = ip + " " + token
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		if token != "" && l.perIP[ip] >= maxTokensPerIP {
+			key = ip
+			b, ok = l.buckets[key]
+		}
+	}
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		if key != ip {
+			b.ip = ip
+			l.perIP[ip]++
+		}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that have filled up again, they are the same as
+// new ones.
+func (l *rateLimit) prune(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate < l.burst {
+			continue
+		}
+		delete(l.buckets, k)
+		if b.ip != "" {
+			if l.perIP[b.ip]--; l.perIP[b.ip] <= 0 {
+				delete(l.perIP, b.ip)
+			}
+		}
+	}
+}
+
+// RateLimiter wraps h so that every request is counted against the limit
+// with the longest prefix matching the request path, if any. Requests over
+// the limit are answered with 429 Too Many Requests and a Retry-After
+// header, and counted in the expvar map http_ratelimit.
+func RateLimiter(h http.Handler, limits []RateLimit, logger *log.Logger) (http.Handler, error) {
+	if len(limits) == 0 {
+		return h, nil
+	}
+	var parsed []*rateLimit
+	for _, rl := range limits {
+		rate, burst, err := parseLimit(rl.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %v", rl.Prefix, err)
+		}
+		if rl.Burst > 0 {
+			burst = float64(rl.Burst)
+		}
+		switch rl.By {
+		case "":
+			rl.By = "ip"
+		case "ip", "token":
+		default:
+			return nil, fmt.Errorf("rate limit %q: unknown By %q", rl.Prefix, rl.By)
+		}
+		parsed = append(parsed, &rateLimit{
+			prefix:  rl.Prefix,
+			rate:    rate,
+			burst:   math.Max(1, burst),
+			by:      rl.By,
+			buckets: make(map[string]*bucket),
+			perIP:   make(map[string]int),
+		})
+	}
+	f := func(w http.ResponseWriter, r *http.Request) {
+		var limit *rateLimit
+		for _, l := range parsed {
+			if strings.HasPrefix(r.URL.Path, l.prefix) && (limit == nil || len(l.prefix) > len(limit.prefix)) {
+				limit = l
+			}
+		}
+		if limit != nil {
+			ip, token := limit.clientKey(r)
+			if ok, wait := limit.take(ip, token, time.Now()); !ok {
+				rateLimitStats.Add(limit.prefix, 1)
+				logger.Printf("429 Too Many Requests: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(f), nil
+}
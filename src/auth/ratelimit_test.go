@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitMadeUpTokens(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h, err := RateLimiter(ok, []RateLimit{{Prefix: "/ingest/", Limit: "2/m", By: "token"}}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(addr, token string) int {
+		r := httptest.NewRequest("GET", "/ingest/osmand?token="+token, nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	passed := 0
+	for i := 0; i < 200; i++ {
+		if send("192.0.2.1:1000", fmt.Sprint("made-up-", i)) == http.StatusOK {
+			passed++
+		}
+	}
+	// every token up to the limit per address, then the bucket of the address
+	if want := maxTokensPerIP + 2; passed != want {
+		t.Errorf("%d requests with made up tokens passed, want %d", passed, want)
+	}
+	if code := send("192.0.2.2:1000", "good"); code != http.StatusOK {
+		t.Errorf("token from another address: %d", code)
+	}
+}
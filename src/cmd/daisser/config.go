@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configCommand implements "daisser config migrate", which writes the config
+// file with all settings of this version, adding the defaults of new ones.
+// The server itself never writes the file.
+func configCommand(args []string) error {
+	if len(args) != 1 || args[0] != "migrate" {
+		return errors.New("usage: daisser config migrate")
+	}
+	// read the file again, so that unknown settings are not dropped silently
+	c, exists, err := loadConfig(true)
+	if err != nil {
+		return fmt.Errorf("%v, remove or fix the setting before migrating", err)
+	}
+	if err := writeConfig(&c); err != nil {
+		return err
+	}
+	if exists {
+		fmt.Printf("migrated %s\n", configFile)
+	} else {
+		fmt.Printf("created %s with the defaults\n", configFile)
+	}
+	return nil
+}
+
+// bootstrap prepares the first run of a new installation, like a fresh
+// container, see the -init flag. If the config file does not exist yet, it
+// creates the data directory next to it and writes a config that keeps all
+// data there and receives positions with the osmand protocol, which needs no
+// MQTT broker, from one device with a generated id. Everything else keeps
+// its default.
+//
+// The server is only reachable on localhost port 8080, unless the
+// environment variables DAISSER_AUTH_HEADER and DAISSER_TRUSTED_PROXIES
+// set up authentication by a proxy, as AuthHeader and a comma-separated
+// list of TrustedProxies. Then it listens on port 8080 of all interfaces.
+func bootstrap() error {
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		return err
+	}
+	header, proxies := os.Getenv("DAISSER_AUTH_HEADER"), os.Getenv("DAISSER_TRUSTED_PROXIES")
+	if (header == "") != (proxies == "") {
+		return errors.New("DAISSER_AUTH_HEADER and DAISSER_TRUSTED_PROXIES must be set together")
+	}
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+	dir := filepath.Join(filepath.Dir(configFile), "data")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	c := map[string]interface{}{
+		"Listen":    "localhost:8080",
+		"Protocols": []string{"osmand"},
+		"ProtocolOptions": map[string]interface{}{
+			"osmand": map[string]interface{}{
+				"Devices": map[string]string{id: bootstrapDevice},
+			},
+		},
+		"DbDriver":        "file",
+		"DbFile":          filepath.Join(dir, "positions.jsonl"),
+		"PreferencesFile": filepath.Join(dir, "preferences.json"),
+		"DevicesFile":     filepath.Join(dir, "devices.json"),
+		"PlacesFile":      filepath.Join(dir, "places.json"),
+		"POIsFile":        filepath.Join(dir, "pois.json"),
+		"SpoolFile":       filepath.Join(dir, "spool.jsonl"),
+		"ExportDir":       filepath.Join(dir, "exports"),
+	}
+	if header != "" {
+		c["Listen"] = ":8080"
+		c["AuthHeader"] = header
+		c["TrustedProxies"] = strings.Split(proxies, ",")
+	}
+	if err := writeConfig(c); err != nil {
+		return err
+	}
+	logger.Printf("First run: wrote %s, data is kept in %s, listening on %s", configFile, dir, c["Listen"])
+	logger.Printf("First run: OsmAnd logs the positions of %s with the id %s to /ingest/osmand", bootstrapDevice, id)
+	return nil
+}
+
+// bootstrapDevice is the device of the osmand id generated by bootstrap.
+const bootstrapDevice = "admin/phone"
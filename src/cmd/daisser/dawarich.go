@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+// Dawarich (https://dawarich.app) exports the points of a user either as a
+// GeoJSON FeatureCollection of Points or as a JSON array of its point
+// records. Both carry the OwnTracks fields the points were recorded with.
+
+// importDawarich implements "daisser import dawarich".
+func importDawarich(args []string) error {
+	fs := flag.NewFlagSet("import dawarich", flag.ExitOnError)
+	user := fs.String("user", "", "user the positions are stored for (required)")
+	tracker := fs.String("tracker", "dw", "tracker ID for points without tracker_id")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: daisser import dawarich --user <name> [flags] <export.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing export file")
+	}
+	if err := checkUserName(*user); err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	points, err := parseDawarich(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	im := newImporter(store)
+	for _, p := range points {
+		lu, ok := p.locationUpdate(*user, *tracker)
+		if !ok {
+			im.rejected++
+			continue
+		}
+		if err := im.add(lu); err != nil {
+			return err
+		}
+	}
+	im.report()
+	return nil
+}
+
+// dawarichPoint holds the fields of a Dawarich point that daisser stores.
+// Dawarich writes numbers as strings in some versions, so all of them are
+// decoded leniently.
+type dawarichPoint map[string]interface{}
+
+// number returns the first of the fields keys that is a number.
+func (p dawarichPoint) number(keys ...string) (float64, bool) {
+	for _, k := range keys {
+		switch v := p[k].(type) {
+		case float64:
+			return v, true
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (p dawarichPoint) text(key string) string {
+	s, _ := p[key].(string)
+	return s
+}
+
+// locationUpdate converts p to a position of user. Points without
+// tracker_id get the tracker ID tracker.
+func (p dawarichPoint) locationUpdate(user, tracker string) (owntracks.LocationUpdate, bool) {
+	lat, okLat := p.number("latitude", "lat")
+	lon, okLon := p.number("longitude", "lon")
+	if wkt := p.text("lonlat"); !okLat && wkt != "" {
+		// "POINT (13.405 52.52)"
+		var x, y float64
+		if _, err := fmt.Sscanf(wkt, "POINT (%g %g)", &x, &y); err == nil {
+			lon, lat, okLat, okLon = x, y, true, true
+		}
+	}
+	ts, okTS := p.number("timestamp", "tst")
+	if !okLat || !okLon || !okTS {
+		return owntracks.LocationUpdate{}, false
+	}
+	lu := owntracks.LocationUpdate{
+		T:         time.Unix(int64(ts), 0),
+		Trigger:   owntracks.UnknownTrigger,
+		User:      user,
+		ClientID:  "dawarich",
+		TrackerID: p.text("tracker_id"),
+		Latitude:  lat,
+		Longitude: lon,
+	}
+	if lu.TrackerID == "" {
+		lu.TrackerID = tracker
+	}
+	// owntracks/<user>/<device>
+	if parts := strings.Split(p.text("topic"), "/"); len(parts) == 3 && parts[2] != "" {
+		lu.ClientID = parts[2]
+	}
+	for _, f := range []struct {
+		v    *int
+		keys []string
+	}{
+		{&lu.Accuracy, []string{"accuracy", "acc"}},
+		{&lu.Battery, []string{"battery", "batt"}},
+		{&lu.Altitude, []string{"altitude", "alt"}},
+		{&lu.Velocity, []string{"velocity", "vel"}},
+		{&lu.Course, []string{"course", "cog"}},
+	} {
+		if v, ok := p.number(f.keys...); ok {
+			*f.v = int(v + 0.5)
+		}
+	}
+	return lu, true
+}
+
+// parseDawarich reads the points of a Dawarich export.
+func parseDawarich(r io.Reader) ([]dawarichPoint, error) {
+	var doc interface{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var points []dawarichPoint
+	var walk func(v interface{}) error
+	walk = func(v interface{}) error {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, e := range v {
+				if o, ok := e.(map[string]interface{}); ok {
+					points = append(points, dawarichPoint(o))
+				}
+			}
+			return nil
+		case map[string]interface{}:
+			if v["type"] == "FeatureCollection" {
+				features, _ := v["features"].([]interface{})
+				for _, f := range features {
+					points = append(points, featurePoint(f))
+				}
+				return nil
+			}
+			// older exports nest the points by user, as
+			// {"<email>": {"dawarich-export": [...]}}
+			for _, e := range v {
+				o, _ := e.(map[string]interface{})
+				if pts, ok := o["dawarich-export"]; ok {
+					if err := walk(pts); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		return errors.New("not a Dawarich export")
+	}
+	if err := walk(doc); err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, errors.New("no points found")
+	}
+	return points, nil
+}
+
+// featurePoint returns the properties of the GeoJSON Point feature f, with
+// the coordinates added.
+func featurePoint(f interface{}) dawarichPoint {
+	p := dawarichPoint{}
+	o, _ := f.(map[string]interface{})
+	if props, ok := o["properties"].(map[string]interface{}); ok {
+		for k, v := range props {
+			p[k] = v
+		}
+	}
+	if g, ok := o["geometry"].(map[string]interface{}); ok {
+		if c, ok := g["coordinates"].([]interface{}); ok && len(c) >= 2 {
+			p["longitude"], p["latitude"] = c[0], c[1]
+		}
+	}
+	return p
+}
+
+// exportDawarich implements "daisser export dawarich", which writes the
+// positions as a GeoJSON FeatureCollection that Dawarich imports.
+func exportDawarich(args []string) error {
+	fs := flag.NewFlagSet("export dawarich", flag.ExitOnError)
+	user := fs.String("user", "", "export only the positions of this user")
+	tracker := fs.String("tracker", "", "export only the positions of this tracker ID")
+	from := fs.String("from", "", "export positions from this date or RFC 3339 time on")
+	to := fs.String("to", "", "export positions up to this date or RFC 3339 time")
+	out := fs.String("o", "-", "file to write to, or '-' for stdout")
+	fs.Parse(args)
+
+	q := storage.Query{User: *user, TrackerID: *tracker}
+	var err error
+	if q.From, err = parseDate(*from); err != nil {
+		return fmt.Errorf("invalid --from: %v", err)
+	}
+	if q.To, err = parseDate(*to); err != nil {
+		return fmt.Errorf("invalid --to: %v", err)
+	}
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	tracks, err := storage.Tracks(store, q)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"type":"FeatureCollection","features":[`)
+	n := 0
+	for _, t := range tracks {
+		for _, lu := range t {
+			if n > 0 {
+				bw.WriteString(",\n")
+			}
+			b, err := json.Marshal(dawarichFeature(lu))
+			if err != nil {
+				return err
+			}
+			bw.Write(b)
+			n++
+		}
+	}
+	bw.WriteString("]}\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d positions\n", n)
+	return nil
+}
+
+// dawarichFeature returns lu as a feature of a Dawarich GeoJSON export.
+func dawarichFeature(lu owntracks.LocationUpdate) interface{} {
+	device := lu.ClientID
+	if device == "" {
+		device = lu.TrackerID
+	}
+	type geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	}
+	return struct {
+		Type       string                 `json:"type"`
+		Geometry   geometry               `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}{
+		Type:     "Feature",
+		Geometry: geometry{"Point", [2]float64{lu.Longitude, lu.Latitude}},
+		Properties: map[string]interface{}{
+			"timestamp":  lu.T.Unix(),
+			"latitude":   lu.Latitude,
+			"longitude":  lu.Longitude,
+			"altitude":   lu.Altitude,
+			"velocity":   strconv.Itoa(lu.Velocity),
+			"course":     lu.Course,
+			"battery":    lu.Battery,
+			"accuracy":   lu.Accuracy,
+			"tracker_id": lu.TrackerID,
+			"topic":      "owntracks/" + lu.User + "/" + device,
+		},
+	}
+}
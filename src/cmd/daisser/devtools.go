@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ingest"
+	"owntracks"
+	"storage"
+)
+
+// devtools implements the "daisser devtools" commands that help measuring
+// the performance of daisser.
+func devtools(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: daisser devtools seed|load|replay [flags]")
+	}
+	switch args[0] {
+	case "seed":
+		return seed(args[1:])
+	case "load":
+		return load(args[1:])
+	case "replay":
+		return replay(args[1:])
+	}
+	return fmt.Errorf("unknown devtools command %q", args[0])
+}
+
+// seed fills the configured storage with synthetic tracks.
+func seed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	users := fs.Int("users", 5, "number of users to generate")
+	days := fs.Int("days", 30, "number of days of history per user")
+	interval := fs.Duration("interval", 30*time.Second, "time between two positions")
+	lat := fs.Float64("lat", 52.52, "latitude of the area the users live in")
+	lon := fs.Float64("lon", 13.405, "longitude of the area the users live in")
+	seed := fs.Int64("seed", 1, "seed of the random generator")
+	fs.Parse(args)
+
+	if config.DbDriver == "memory" {
+		return errors.New("seeding needs a persistent DbDriver")
+	}
+	store, err := storage.Open(config.DbDriver, config.DbFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rnd := rand.New(rand.NewSource(*seed))
+	end := time.Now().Truncate(*interval)
+	start := end.AddDate(0, 0, -*days)
+	for u := 0; u < *users; u++ {
+		su := newSynthUser(fmt.Sprintf("user%d", u+1), *lat, *lon, rnd)
+		n := 0
+		for t := start; t.Before(end); t = t.Add(*interval) {
+			if err := ingest.Accept(store, su.step(t, *interval)); err != nil {
+				return err
+			}
+			n++
+		}
+		fmt.Printf("%s: %d positions\n", su.name, n)
+	}
+	return nil
+}
+
+// load publishes synthetic OwnTracks messages to the configured MQTT broker
+// and reports the achieved throughput.
+func load(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	devices := fs.Int("devices", 10, "number of simulated devices")
+	rate := fs.Float64("rate", 10, "messages per second over all devices")
+	duration := fs.Duration("duration", time.Minute, "duration of the test")
+	fs.Parse(args)
+	if *devices <= 0 {
+		return fmt.Errorf("invalid number of devices %d", *devices)
+	}
+	// negated, so that NaN is invalid as well
+	if !(*rate > 0) {
+		return fmt.Errorf("invalid rate %v", *rate)
+	}
+	every := time.Duration(float64(*devices) / *rate * float64(time.Second))
+	if every <= 0 {
+		return fmt.Errorf("rate %v is too high", *rate)
+	}
+
+	var sent, failed int64
+	var latency int64 // sum in nanoseconds
+	var wg sync.WaitGroup
+	stop := time.Now().Add(*duration)
+	for d := 0; d < *devices; d++ {
+		l := config.OwnTracksListener()
+		l.ClientID = fmt.Sprintf("daisser-load-%d", d)
+		if err := l.Dial(); err != nil {
+			return err
+		}
+		defer l.Disconnect()
+		su := newSynthUser(fmt.Sprintf("load%d", d+1), 52.52, 13.405, rand.New(rand.NewSource(int64(d))))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tick := time.NewTicker(every)
+			defer tick.Stop()
+			for t := range tick.C {
+				if t.After(stop) {
+					return
+				}
+				m := owntracks.NewLocationMessage(su.step(t, every))
+				if err := l.Publish(m, false); err != nil {
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				atomic.AddInt64(&sent, 1)
+				atomic.AddInt64(&latency, int64(time.Since(t)))
+			}
+		}()
+	}
+	wg.Wait()
+	fmt.Printf("sent %d messages in %s (%.1f/s), %d failed", sent, *duration, float64(sent)/duration.Seconds(), failed)
+	if sent > 0 {
+		fmt.Printf(", average publish latency %s", time.Duration(latency/sent))
+	}
+	fmt.Println()
+	return nil
+}
+
+// gpxFile holds the parts of a GPX document needed for replaying it.
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Lat  float64   `xml:"lat,attr"`
+				Lon  float64   `xml:"lon,attr"`
+				Ele  float64   `xml:"ele"`
+				Time time.Time `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// replay publishes the track points of a GPX file as OwnTracks messages,
+// keeping their relative timing but speeding it up by a factor.
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.String("speed", "1x", "how much faster than real time to replay, e.g. 10x")
+	user := fs.String("user", "replay", "user to publish the positions as")
+	device := fs.String("device", "gpx", "device to publish the positions as")
+	target := fs.String("target", "", "MQTT broker as host:port, defaults to the configured one")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: daisser devtools replay [flags] file.gpx")
+	}
+	factor, err := strconv.ParseFloat(strings.TrimSuffix(*speed, "x"), 64)
+	if err != nil || factor <= 0 {
+		return fmt.Errorf("invalid speed %q", *speed)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var gpx gpxFile
+	err = xml.NewDecoder(f).Decode(&gpx)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	l := config.OwnTracksListener()
+	l.ClientID = "daisser-replay"
+	if *target != "" {
+		host, port, ok := strings.Cut(*target, ":")
+		l.Hostname = host
+		if ok {
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid target %q", *target)
+			}
+			l.Port = uint16(p)
+		}
+	}
+	if err := l.Dial(); err != nil {
+		return err
+	}
+	defer l.Disconnect()
+
+	var first, start time.Time
+	n := 0
+	for _, t := range gpx.Tracks {
+		for _, s := range t.Segments {
+			for _, p := range s.Points {
+				now := time.Now()
+				if first.IsZero() {
+					first, start = p.Time, now
+				}
+				if !p.Time.IsZero() {
+					due := start.Add(time.Duration(float64(p.Time.Sub(first)) / factor))
+					time.Sleep(due.Sub(now))
+				}
+				lu := owntracks.LocationUpdate{
+					T:         time.Now(),
+					Trigger:   owntracks.AutoLocationUpdate,
+					User:      *user,
+					ClientID:  *device,
+					TrackerID: *device,
+					Latitude:  p.Lat,
+					Longitude: p.Lon,
+					Altitude:  int(p.Ele),
+				}
+				if err := l.Publish(owntracks.NewLocationMessage(lu), false); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+	}
+	fmt.Printf("replayed %d positions in %s\n", n, time.Since(start).Truncate(time.Second))
+	return nil
+}
+
+// synthUser moves between home and a few other places, staying at each for
+// a while, like a person carrying a phone.
+type synthUser struct {
+	name     string
+	rnd      *rand.Rand
+	places   [][2]float64 // places[0] is home
+	pos      [2]float64
+	target   int
+	speed    float64 // in [m/s], 0 while staying at a place
+	until    time.Time
+	battery  float64
+	distance float64 // to the target in [m]
+}
+
+func newSynthUser(name string, lat, lon float64, rnd *rand.Rand) *synthUser {
+	su := &synthUser{name: name, rnd: rnd, battery: 100}
+	for i := 0; i < 4; i++ {
+		// within roughly 15 km of the center of the area
+		su.places = append(su.places, [2]float64{
+			lat + (rnd.Float64()-0.5)*0.27,
+			lon + (rnd.Float64()-0.5)*0.45,
+		})
+	}
+	su.pos = su.places[0]
+	return su
+}
+
+// step advances su by dt to time t and returns its position.
+func (su *synthUser) step(t time.Time, dt time.Duration) owntracks.LocationUpdate {
+	switch {
+	case su.speed > 0:
+		p := su.places[su.target]
+		d := su.speed * dt.Seconds()
+		if d >= su.distance {
+			su.pos, su.speed = p, 0
+			if su.target == 0 {
+				su.until = t.Add(time.Duration(1+su.rnd.Intn(12)) * time.Hour)
+			} else {
+				su.until = t.Add(time.Duration(20+su.rnd.Intn(480)) * time.Minute)
+			}
+			break
+		}
+		f := d / su.distance
+		su.pos[0] += (p[0] - su.pos[0]) * f
+		su.pos[1] += (p[1] - su.pos[1]) * f
+		su.distance -= d
+	case t.After(su.until) && (t.Hour() >= 7 && t.Hour() < 22 || su.target != 0):
+		if su.target != 0 {
+			su.target = 0
+		} else {
+			su.target = 1 + su.rnd.Intn(len(su.places)-1)
+		}
+		su.speed = 1.2 + su.rnd.Float64()*15
+		su.distance = synthDistance(su.pos, su.places[su.target])
+	}
+
+	if su.target == 0 && su.speed == 0 {
+		su.battery = math.Min(100, su.battery+dt.Hours()*40)
+	} else {
+		su.battery = math.Max(1, su.battery-dt.Hours()*5)
+	}
+	acc := 5 + su.rnd.Intn(20)
+	jitter := float64(acc) / 111000
+	return owntracks.LocationUpdate{
+		T:         t,
+		Trigger:   owntracks.AutoLocationUpdate,
+		User:      su.name,
+		ClientID:  "phone",
+		TrackerID: su.name[:1] + su.name[len(su.name)-1:],
+		Accuracy:  acc,
+		Battery:   int(su.battery),
+		Latitude:  su.pos[0] + (su.rnd.Float64()-0.5)*jitter,
+		Longitude: su.pos[1] + (su.rnd.Float64()-0.5)*jitter,
+		Altitude:  30 + su.rnd.Intn(10),
+		Velocity:  int(su.speed * 3.6),
+	}
+}
+
+// synthDistance approximates the distance between a and b in [m], which is
+// good enough for short distances.
+func synthDistance(a, b [2]float64) float64 {
+	dy := (b[0] - a[0]) * 111000
+	dx := (b[1] - a[1]) * 111000 * math.Cos(a[0]*math.Pi/180)
+	return math.Hypot(dx, dy)
+}
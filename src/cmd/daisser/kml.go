@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"publish"
+	"storage"
+)
+
+// exportKML implements "daisser export kml", which writes the tracks of a
+// trip as KML, optionally with a tour flying along them in Google Earth.
+func exportKML(args []string) error {
+	fs := flag.NewFlagSet("export kml", flag.ExitOnError)
+	user := fs.String("user", "", "export only the positions of this user")
+	tracker := fs.String("tracker", "", "export only the positions of this tracker ID")
+	from := fs.String("from", "", "export positions from this date or RFC 3339 time on (required)")
+	to := fs.String("to", "", "export positions up to this date or RFC 3339 time")
+	name := fs.String("name", "daisser", "name of the document, as shown in Google Earth")
+	tour := fs.Bool("tour", false, "add an animated tour along the tracks")
+	out := fs.String("o", "-", "file to write to, or '-' for stdout")
+	fs.Parse(args)
+	if *from == "" {
+		fs.Usage()
+		return errors.New("--from is required")
+	}
+
+	q := storage.Query{User: *user, TrackerID: *tracker}
+	var err error
+	if q.From, err = parseDate(*from); err != nil {
+		return fmt.Errorf("invalid --from: %v", err)
+	}
+	if q.To, err = parseDate(*to); err != nil {
+		return fmt.Errorf("invalid --to: %v", err)
+	}
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	tracks, err := storage.Tracks(store, q)
+	if err != nil {
+		return err
+	}
+	if len(tracks) == 0 {
+		return errors.New("no positions found")
+	}
+	data := publish.EncodeKML(*name, tracks, *tour)
+	if *out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*out, data, 0644)
+}
@@ -1,349 +1,209 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"html/template"
 	"io"
 	"log"
-	"net/http"
-	"net/http/fcgi"
 	"os"
-	"owntracks"
+	"os/signal"
 	"path/filepath"
-	"strconv"
-	"sync"
-	"time"
+	"syscall"
 
-	"golang.org/x/crypto/bcrypt"
+	"server"
 )
 
-const configFile = "config.json"
+// configFile is the path of the config file.
+var configFile = "config.json"
 
-var config struct {
-	MQTTHost     string
-	MQTTPort     uint16
-	MQTTUser     string
-	MQTTPassword string
-	UrlBase      string
-	DbFile       string
-	Listen       string
-}
+// logFile is where to log to, see the -log flag.
+var logFile = "daisser.log"
 
-var cachedTemplates = map[string]*template.Template{}
-var cachedMutex sync.Mutex
+var config server.Config
 
 var logger *log.Logger
 
-func writeConfig() error {
-	j, err := json.MarshalIndent(&config, "", "\t")
+// writeConfig writes the settings c to the config file through a temporary
+// file, so that a crash never leaves a partial config behind.
+func writeConfig(c interface{}) error {
+	j, err := json.MarshalIndent(c, "", "\t")
 	if err != nil {
 		return err
 	}
-	outFile, err := os.Create(configFile)
-	b := bytes.NewBuffer(j)
-	if _, err := b.WriteTo(outFile); err != nil {
+	dir := filepath.Dir(configFile)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(configFile)+".*")
+	if err != nil {
 		return err
 	}
-	return nil
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(j, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// keep the permissions of the existing file, it may hold secrets
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(configFile); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), configFile)
 }
 
-func readConfig() error {
-	config.UrlBase = ""
-	config.Listen = "fastcgi"
+// loadConfig returns the settings of the config file on top of the
+// defaults. If strict, settings unknown to this version are an error. It
+// reports whether the file exists.
+func loadConfig(strict bool) (server.Config, bool, error) {
+	c := server.DefaultConfig()
 	inFile, err := os.Open(configFile)
+	if os.IsNotExist(err) {
+		return c, false, nil
+	}
 	if err != nil {
-		return writeConfig()
+		return c, false, err
 	}
+	defer inFile.Close()
 	dec := json.NewDecoder(inFile)
-	err = dec.Decode(&config)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&c); err != nil {
+		return c, true, fmt.Errorf("%s: %v", configFile, err)
+	}
+	return c, true, nil
+}
+
+// readConfig reads the config file into config. The file is never written,
+// settings missing in it get their defaults, see "daisser config migrate".
+func readConfig() error {
+	c, exists, err := loadConfig(false)
 	if err != nil {
 		return err
 	}
-	// add backwards-compatible defaults for new config file entries when
-	// reading the config
-	return writeConfig()
+	if !exists {
+		logger.Printf("%s not found, using the defaults, see 'daisser config migrate'", configFile)
+	}
+	config = c
+	return nil
 }
 
 func init() {
+	dirFlag := flag.String("dir", "", "directory to run in, relative paths in the config are relative to it")
+	flag.StringVar(&configFile, "config", configFile, "the config file")
+	initFlag := flag.Bool("init", false, "on the first run, write a config file that keeps all data in a data directory next to it")
 	listenFlag := flag.String("listen", "", "Where to listen, either 'fastcgi' or a http.Listen string (':8080')")
-	logFlag := flag.String("log", "daisser.log", "file to log to, or '-' for stderr")
+	flag.StringVar(&logFile, "log", logFile, "file to log to, '-' for stderr, or 'eventlog' for the Windows event log")
 	flag.Parse()
+	// services are started in a system directory
+	if *dirFlag != "" {
+		if err := os.Chdir(*dirFlag); err != nil {
+			panic(err)
+		}
+	}
 	var w io.Writer = os.Stderr
-	if *logFlag != "-" {
+	switch logFile {
+	case "-":
+	case "eventlog":
+		var err error
+		if w, err = openEventLog(); err != nil {
+			panic(err)
+		}
+	default:
 		var err error
-		w, err = os.OpenFile("daisser.log", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+		w, err = os.OpenFile(logFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 		if err != nil {
 			panic(err)
 		}
 	}
 	logger = log.New(w, "", log.Ldate|log.Ltime|log.Lshortfile)
 
+	if *initFlag {
+		if err := bootstrap(); err != nil {
+			logger.Println(err)
+			panic(err)
+		}
+	}
 	err := readConfig()
 	if err != nil {
 		logger.Println(err)
 		panic(err)
 	}
+	// the config command works on the file, which keeps the references
+	if flag.Arg(0) != "config" {
+		if err := config.ResolveSecrets(); err != nil {
+			logger.Println(err)
+			panic(err)
+		}
+	}
 
 	if *listenFlag != "" {
 		config.Listen = *listenFlag
 	}
+	config.Logger = logger
 }
 
-// T returns a html/template. All compiled templates are cached.
-// The template must compile, otherwise this method will panic.
-func T(name string) *template.Template {
-	cachedMutex.Lock()
-	defer cachedMutex.Unlock()
-
-	if t, ok := cachedTemplates[name]; ok {
-		return t
-	}
-
-	t := template.Must(template.ParseFiles(
-		filepath.Join("static", name),
-	))
-	cachedTemplates[name] = t
-
-	return t
-}
-
-type Feature struct {
-	Type       string            `json:"type"`
-	Properties map[string]string `json:"properties"`
-	Geometry   struct {
-		Type        string    `json:"type"`
-		Coordinates []float64 `json:"coordinates"`
-	} `json:"geometry"`
-}
-
-type FeatureCollection struct {
-	Type     string    `json:"type"`
-	Features []Feature `json:"features"`
-}
-
-func (s *Server) Positions(w http.ResponseWriter, r *http.Request) {
-	var fc FeatureCollection
-	fc.Type = "FeatureCollection"
-	s.posMutex.RLock()
-	for _, v := range s.positions {
-		var f Feature
-		f.Type = "Feature"
-		f.Properties = make(map[string]string)
-		f.Properties["Time"] = v.T.String()
-		f.Properties["User"] = v.User
-		f.Properties["Client"] = v.ClientID
-		f.Properties["Tracker"] = v.TrackerID
-		f.Properties["Accuracy"] = strconv.Itoa(v.Accuracy)
-		f.Properties["Description"] = v.Description
-		f.Geometry.Type = "Point"
-		f.Geometry.Coordinates = make([]float64, 2)
-		f.Geometry.Coordinates[0] = v.Longitude
-		f.Geometry.Coordinates[1] = v.Latitude
-		fc.Features = append(fc.Features, f)
-	}
-	s.posMutex.RUnlock()
-	fmt.Println(fc)
-	b, err := json.Marshal(fc)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-	w.Write(b)
-}
-
-// runTemplate executes the template named name on w.
-func runTemplate(w http.ResponseWriter, r *http.Request, name string) {
-	buf := new(bytes.Buffer)
-	T(name).Execute(buf, nil) // TODO add correct data here
-	buf.WriteTo(w)
-}
-
-func serveLogin(w http.ResponseWriter, r *http.Request) {
-	runTemplate(w, r, "signin.html")
-}
-
-func serveMap(w http.ResponseWriter, r *http.Request) {
-	runTemplate(w, r, "bootleaf.html")
-}
-
-func postLogin(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, "Bad login request", 400)
+func main() {
+	if flag.NArg() > 0 {
+		var err error
+		switch flag.Arg(0) {
+		case "devtools":
+			err = devtools(flag.Args()[1:])
+		case "user":
+			err = userCommand(flag.Args()[1:])
+		case "device":
+			err = deviceCommand(flag.Args()[1:])
+		case "import":
+			err = importCommand(flag.Args()[1:])
+		case "export":
+			err = exportCommand(flag.Args()[1:])
+		case "config":
+			err = configCommand(flag.Args()[1:])
+		case "service":
+			err = serviceCommand(flag.Args()[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", flag.Arg(0))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		return
 	}
-	username := r.FormValue("username")
-	password := r.FormValue("password")
-	var encryptedPassword string
-	_ = username
-
-	// TODO check request
-
-	log.Println("PASSWORD for testing: ", encryptedPassword, password)
-
-	err = bcrypt.CompareHashAndPassword([]byte(encryptedPassword), []byte(password))
-	if err == nil {
-		// TODO save cookie
-		http.Redirect(w, r, config.UrlBase+"/map", http.StatusSeeOther)
-	} else {
-		log.Println(err)
-		// TODO error
-		http.Redirect(w, r, config.UrlBase+"/", http.StatusSeeOther)
-	}
-}
-
-func logout(w http.ResponseWriter, r *http.Request) {
-	log.Println("Logging out")
-	// TODO delete cookie
-	http.Redirect(w, r, config.UrlBase+"/", http.StatusSeeOther)
-}
-
-func authCheck(exe func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
-	// TODO check authentication
-	f := func(w http.ResponseWriter, r *http.Request) {
-		exe(w, r)
-	}
-	return f
-}
-
-func setPassword(username, password string) {
-	hpass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		panic(err) //this is a panic because bcrypt errors on invalid costs
-	}
-	log.Println(string(hpass))
-
-	// TODO make password persistent
-}
-
-type PositionSet map[string]owntracks.LocationUpdate
-
-// Server is the primary datastructure for daisser. Internally it combines a
-// HTTP server or FastCGI process with an Owntracks listener
-type Server struct {
-	mux       *http.ServeMux
-	done      chan struct{}
-	startTime time.Time
-	listener  owntracks.Listener
-	positions PositionSet
-	posMutex  sync.RWMutex
-}
-
-func (s *Server) NotFound(w http.ResponseWriter, r *http.Request) {
-	logger.Printf("404 Not found: %s %s", r.Method, r.URL.Path)
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintf(w, "404 Not Found (%s %s)\n", r.Method, r.URL.Path)
-	fmt.Fprintf(w, "Started at %s\nRunning for %s\n", s.startTime.String(), time.Since(s.startTime))
-	pwd, _ := os.Getwd()
-	fmt.Fprintf(w, "cwd: %s\n", pwd)
-}
-
-func (s *Server) DefaultHandle(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/" {
-		serveMap(w, r)
-	} else {
-		s.NotFound(w, r)
-	}
-	if _, err := os.Stat("killfile"); !os.IsNotExist(err) {
-		logger.Println("found fillfile, quitting now")
-		os.Remove("killfile")
-		close(s.done)
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	if err := serve(stop); err != nil {
+		logger.Println(err)
 	}
 }
 
-func (s *Server) Listen() error {
-	s.listener = owntracks.Listener{
-		Hostname: config.MQTTHost,
-		Port:     config.MQTTPort,
-		Username: config.MQTTUser,
-		Password: config.MQTTPassword,
-		UseTLS:   true,
-		ClientID: "daisser-server",
-	}
-	msgs, err := s.listener.Connect()
+// serve runs the server until stop is closed or the server fails.
+func serve(stop <-chan struct{}) error {
+	logger.Println("Started")
+	defer logger.Println("Exited")
+	s, err := server.New(config)
 	if err != nil {
 		return err
 	}
-	parser := owntracks.RunMessageParser(msgs, s.done)
-	logger.Printf("Connected to MQTT server at %s", s.listener.BrokerAddress())
+	errc := make(chan error, 1)
 	go func() {
-		for {
-			select {
-			case <-s.done:
-			case l := <-parser.L:
-				fmt.Println(l)
-				s.addPositionUpdate(l)
-			case m := <-parser.O:
-				logger.Printf("Received other message: %s %s", m.Topic, m.Payload)
-			}
-		}
-		if err := s.listener.Disconnect(); err != nil {
-			logger.Printf("Error during owntracks.Listener.Disconnect: %v", err)
-		}
-	}()
-	return nil
-}
-
-func (s *Server) addPositionUpdate(lu owntracks.LocationUpdate) {
-	k := lu.User + lu.TrackerID
-	s.posMutex.Lock()
-	s.positions[k] = lu
-	defer s.posMutex.Unlock()
-}
-
-func RunServer(listen string) error {
-	s := &Server{
-		mux:       http.NewServeMux(),
-		done:      make(chan struct{}),
-		startTime: time.Now(),
-		positions: make(PositionSet),
-	}
-	// default access
-	s.mux.HandleFunc("/", s.DefaultHandle)
-	s.mux.HandleFunc("/api/positions", s.Positions)
-	s.mux.HandleFunc("/logout", logout)
-	s.mux.Handle("/assets/", http.FileServer(http.Dir("static")))
-
-	if config.UrlBase != "" {
-		strip := http.StripPrefix(config.UrlBase, s.mux)
-		m := http.NewServeMux()
-		m.Handle("/", strip)
-		s.mux = m
-	}
-
-	//r.Path("/").HandlerFunc(serveLogin)
-	//r.Path("/api/login").Methods("POST").HandlerFunc(postLogin)
-	//r.PathPrefix("/static/default/").Handler(http.StripPrefix(config.UrlBase+"/static/default/", http.FileServer(http.Dir("static/default"))))
-
-	//base.NotFoundHandler = http.HandlerFunc(NotFound)
-
-	if err := s.Listen(); err != nil {
-		return err
-	}
-
-	errc := make(chan error)
-	go func() error {
-		if listen != "fastcgi" {
-			return http.ListenAndServe(listen, s.mux)
-		} else {
-			return fcgi.Serve(nil, s.mux)
-		}
+		errc <- s.Run()
 	}()
 	select {
-	case <-s.done:
-		return nil
+	case <-stop:
+		s.Close()
+		return <-errc
 	case err := <-errc:
+		s.Close()
 		return err
 	}
 }
-
-func main() {
-	logger.Println("Started")
-	defer logger.Println("Exited")
-	if err := RunServer(config.Listen); err != nil {
-		logger.Println(err)
-	}
-}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// serviceCommand is only supported on Windows, elsewhere daisser is run by
+// the service manager of the system, like systemd, without a command.
+func serviceCommand(args []string) error {
+	return errors.New("daisser service is only available on Windows, run daisser from the service manager of the system, like systemd")
+}
+
+// openEventLog fails, there is no Windows event log.
+func openEventLog() (io.Writer, error) {
+	return nil, errors.New("the event log is only available on Windows, log to a file or '-' for stderr")
+}
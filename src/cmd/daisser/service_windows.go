@@ -0,0 +1,390 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The service talks to the Windows service control manager through the
+// Win32 API directly, see
+// https://learn.microsoft.com/windows/win32/services/services.
+
+// serviceName is the name daisser is installed under, both as service and
+// as event log source.
+const serviceName = "daisser"
+
+const (
+	scManagerAllAccess = 0xf003f
+	serviceAllAccess   = 0xf01ff
+
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	errorCallNotImplemented             = 120
+	errorFailedServiceControllerConnect = 1063
+	errorServiceSpecific                = 1066
+	eventlogInformationType             = 4
+	eventSourceKey                      = `SYSTEM\CurrentControlSet\Services\EventLog\Application\` + serviceName
+	eventCreateMessageFile              = `%SystemRoot%\System32\EventCreate.exe`
+	eventTypesSupported                 = 7 // error, warning and information
+	eventID                             = 1 // EventCreate.exe formats 1 to 1000 as "%1"
+	serviceStopTimeout                  = 30 * time.Second
+	serviceWaitHint                     = uint32(serviceStopTimeout / time.Millisecond)
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procStartServiceCtrlDispatcher   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerEx = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus             = advapi32.NewProc("SetServiceStatus")
+	procOpenSCManager                = advapi32.NewProc("OpenSCManagerW")
+	procCreateService                = advapi32.NewProc("CreateServiceW")
+	procOpenService                  = advapi32.NewProc("OpenServiceW")
+	procDeleteService                = advapi32.NewProc("DeleteService")
+	procStartService                 = advapi32.NewProc("StartServiceW")
+	procControlService               = advapi32.NewProc("ControlService")
+	procQueryServiceStatus           = advapi32.NewProc("QueryServiceStatus")
+	procCloseServiceHandle           = advapi32.NewProc("CloseServiceHandle")
+	procRegisterEventSource          = advapi32.NewProc("RegisterEventSourceW")
+	procReportEvent                  = advapi32.NewProc("ReportEventW")
+	procRegCreateKeyEx               = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueEx                = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKey                 = advapi32.NewProc("RegDeleteKeyW")
+)
+
+// serviceStatus is a SERVICE_STATUS.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is a SERVICE_TABLE_ENTRYW.
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// serviceCommand installs, removes, starts or stops the daisser service, or
+// runs daisser as the service.
+func serviceCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: daisser [-dir DIR] [-config FILE] [-log FILE|eventlog] service install|remove|start|stop|run")
+	}
+	switch args[0] {
+	case "install":
+		return installService()
+	case "remove":
+		return removeService()
+	case "start":
+		return withService(func(s uintptr) error {
+			_, err := call(procStartService, s, 0, 0)
+			return err
+		})
+	case "stop":
+		return withService(stopService)
+	case "run":
+		return runService()
+	}
+	return fmt.Errorf("unknown service command %q", args[0])
+}
+
+// call calls p and returns the last error if p returns 0.
+func call(p *syscall.LazyProc, args ...uintptr) (uintptr, error) {
+	r, _, err := p.Call(args...)
+	if r == 0 {
+		return 0, err
+	}
+	return r, nil
+}
+
+// utf16 returns s as argument of a Win32 function.
+func utf16(s string) uintptr {
+	p, err := syscall.UTF16PtrFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return uintptr(unsafe.Pointer(p))
+}
+
+// openManager connects to the service control manager. The handle must be
+// closed with CloseServiceHandle.
+func openManager() (uintptr, error) {
+	m, err := call(procOpenSCManager, 0, 0, scManagerAllAccess)
+	if err != nil {
+		return 0, fmt.Errorf("connecting to the service manager: %v", err)
+	}
+	return m, nil
+}
+
+// withService calls f with a handle of the installed service.
+func withService(f func(s uintptr) error) error {
+	m, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(m)
+	s, err := call(procOpenService, m, utf16(serviceName), serviceAllAccess)
+	if err != nil {
+		return fmt.Errorf("service %s: %v", serviceName, err)
+	}
+	defer procCloseServiceHandle.Call(s)
+	return f(s)
+}
+
+// installService installs daisser as a service that starts with Windows and
+// runs with the current directory, config file and log. It also registers
+// daisser as event log source.
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	cfg, err := filepath.Abs(configFile)
+	if err != nil {
+		return err
+	}
+	logPath := logFile
+	if logPath != "-" && logPath != "eventlog" {
+		if logPath, err = filepath.Abs(logPath); err != nil {
+			return err
+		}
+	}
+	var cmd []string
+	for _, arg := range []string{exe, "-dir", dir, "-config", cfg, "-log", logPath, "service", "run"} {
+		cmd = append(cmd, syscall.EscapeArg(arg))
+	}
+
+	m, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(m)
+	s, err := call(procCreateService, m, utf16(serviceName), utf16("daisser tracker server"),
+		serviceAllAccess, serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal,
+		utf16(strings.Join(cmd, " ")), 0, 0, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("installing service %s: %v", serviceName, err)
+	}
+	procCloseServiceHandle.Call(s)
+	if err := installEventSource(); err != nil {
+		return fmt.Errorf("registering the event log source: %v", err)
+	}
+	fmt.Printf("installed service %s running in %s\n", serviceName, dir)
+	return nil
+}
+
+// removeService removes the service and the event log source. A running
+// service is removed once it is stopped.
+func removeService() error {
+	err := withService(func(s uintptr) error {
+		_, err := call(procDeleteService, s)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if r, _, _ := procRegDeleteKey.Call(uintptr(syscall.HKEY_LOCAL_MACHINE), utf16(eventSourceKey)); r != 0 {
+		return fmt.Errorf("removing the event log source: %v", syscall.Errno(r))
+	}
+	fmt.Printf("removed service %s\n", serviceName)
+	return nil
+}
+
+// stopService stops the service s and waits until it has stopped.
+func stopService(s uintptr) error {
+	var st serviceStatus
+	if _, err := call(procControlService, s, serviceControlStop, uintptr(unsafe.Pointer(&st))); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(serviceStopTimeout); st.CurrentState != serviceStopped; {
+		if time.Now().After(deadline) {
+			return errors.New("timeout waiting for the service to stop")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if _, err := call(procQueryServiceStatus, s, uintptr(unsafe.Pointer(&st))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installEventSource registers daisser as source of the Application event
+// log. Its messages are shown through EventCreate.exe, which formats any
+// text without a message file of its own.
+func installEventSource() error {
+	var key syscall.Handle
+	r, _, _ := procRegCreateKeyEx.Call(uintptr(syscall.HKEY_LOCAL_MACHINE), utf16(eventSourceKey),
+		0, 0, 0, syscall.KEY_WRITE, 0, uintptr(unsafe.Pointer(&key)), 0)
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	defer syscall.RegCloseKey(key)
+	file, err := syscall.UTF16FromString(eventCreateMessageFile)
+	if err != nil {
+		return err
+	}
+	types := uint32(eventTypesSupported)
+	for _, v := range []struct {
+		name string
+		kind uint32
+		data unsafe.Pointer
+		size uintptr
+	}{
+		{"EventMessageFile", syscall.REG_EXPAND_SZ, unsafe.Pointer(&file[0]), uintptr(2 * len(file))},
+		{"TypesSupported", syscall.REG_DWORD, unsafe.Pointer(&types), 4},
+	} {
+		r, _, _ := procRegSetValueEx.Call(uintptr(key), utf16(v.name), 0, uintptr(v.kind), uintptr(v.data), v.size)
+		if r != 0 {
+			return syscall.Errno(r)
+		}
+	}
+	return nil
+}
+
+// eventLog writes every log line as an event of the Application event log.
+type eventLog uintptr
+
+// openEventLog returns a writer to the Application event log, see
+// "daisser service install".
+func openEventLog() (io.Writer, error) {
+	h, err := call(procRegisterEventSource, 0, utf16(serviceName))
+	if err != nil {
+		return nil, fmt.Errorf("opening the event log: %v", err)
+	}
+	return eventLog(h), nil
+}
+
+func (l eventLog) Write(p []byte) (int, error) {
+	msg := strings.ReplaceAll(strings.TrimRight(string(p), "\r\n"), "\x00", "")
+	strs := []uintptr{utf16(msg)}
+	_, err := call(procReportEvent, uintptr(l), eventlogInformationType, 0, eventID, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var (
+	// statusHandle reports the status of the running service.
+	statusHandle uintptr
+	statusMu     sync.Mutex
+	status       serviceStatus
+	// serviceStop is closed when the service manager stops the service.
+	serviceStop = make(chan struct{})
+	stopOnce    sync.Once
+	// serviceErr is the error the service stopped with.
+	serviceErr error
+)
+
+// runService runs daisser as the service, it must be started by the service
+// manager.
+func runService() error {
+	name, err := syscall.UTF16PtrFromString(serviceName)
+	if err != nil {
+		return err
+	}
+	table := []serviceTableEntry{{name, syscall.NewCallback(serviceMain)}, {nil, 0}}
+	// returns once the service has stopped
+	if _, err := call(procStartServiceCtrlDispatcher, uintptr(unsafe.Pointer(&table[0]))); err != nil {
+		if err == syscall.Errno(errorFailedServiceControllerConnect) {
+			return errors.New("the service is started by the service manager, see 'daisser service start', or run daisser without a command")
+		}
+		return err
+	}
+	return serviceErr
+}
+
+// serviceMain is the ServiceMain function of the service. It serves until
+// the service manager stops the service.
+func serviceMain(argc uint32, argv uintptr) uintptr {
+	h, err := call(procRegisterServiceCtrlHandlerEx, utf16(serviceName), syscall.NewCallback(serviceHandler), 0)
+	if err != nil {
+		serviceErr = err
+		return 0
+	}
+	statusHandle = h
+	setStatus(serviceStartPending, 0)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(serviceStop)
+	}()
+	setStatus(serviceRunning, 0)
+	serviceErr = <-errc
+	code := uint32(0)
+	if serviceErr != nil {
+		logger.Println(serviceErr)
+		code = 1
+	}
+	setStatus(serviceStopped, code)
+	return 0
+}
+
+// serviceHandler is the HandlerEx function of the service.
+func serviceHandler(control, eventType uint32, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		stopOnce.Do(func() {
+			setStatus(serviceStopPending, 0)
+			close(serviceStop)
+		})
+	case serviceControlInterrogate:
+		setStatus(0, 0)
+	default:
+		return errorCallNotImplemented
+	}
+	return 0
+}
+
+// setStatus reports state to the service manager, 0 reports the current
+// state again. A non-zero code is reported as exit code.
+func setStatus(state, code uint32) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if state != 0 {
+		status = serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state}
+		switch state {
+		case serviceRunning:
+			status.ControlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+		case serviceStartPending, serviceStopPending:
+			status.WaitHint = serviceWaitHint
+		}
+		if code != 0 {
+			status.Win32ExitCode = errorServiceSpecific
+			status.ServiceSpecificExitCode = code
+		}
+	}
+	procSetServiceStatus.Call(statusHandle, uintptr(unsafe.Pointer(&status)))
+}
@@ -227,7 +227,7 @@ var positions = L.geoJson(null, {
   },
   onEachFeature: function (feature, layer) {
     if (feature.properties) {
-      var content = "<table class='table table-striped table-bordered table-condensed'>" + "<tr><th>Name</th><td>" + feature.properties.User + "</td></tr>" + "<tr><th>Client</th><td>" + feature.properties.Client + "</td></tr>" + "<tr><th>Tracker</th><td>" + feature.properties.Tracker + "</td></tr></a></td></tr>" + "<table>";
+      var content = "<table class='table table-striped table-bordered table-condensed'>" + "<tr><th>Name</th><td>" + feature.properties.User + "</td></tr>" + "<tr><th>Client</th><td>" + feature.properties.Client + "</td></tr>" + "<tr><th>Tracker</th><td>" + feature.properties.Tracker + "</td></tr></a></td></tr>" + "<tr><th>Setup</th><td><img src='api/devices/" + encodeURIComponent(feature.properties.User) + "/" + encodeURIComponent(feature.properties.Tracker) + "/qr' alt='Scan with OwnTracks' onerror='$(this).closest(\"tr\").remove()'></td></tr>" + "<table>";
       layer.on({
         click: function (e) {
           $("#feature-title").html(feature.properties.User);
@@ -253,9 +253,33 @@ $.getJSON("/api/positions", function (data) {
   map.addLayer(positions);
 });
 
+/* The points of interest the user pinned */
+var pois = L.geoJson(null, {
+  pointToLayer: function (feature, latlng) {
+    return L.marker(latlng, {
+      title: feature.properties.Name,
+      riseOnHover: true
+    });
+  },
+  onEachFeature: function (feature, layer) {
+    var content = "<table class='table table-striped table-bordered table-condensed'>" + "<tr><th>Name</th><td>" + feature.properties.Name + "</td></tr>" + "<tr><th>Notes</th><td>" + feature.properties.Notes + "</td></tr>" + "<table>";
+    layer.on({
+      click: function (e) {
+        $("#feature-title").html(feature.properties.Name);
+        $("#feature-info").html(content);
+        $("#featureModal").modal("show");
+      }
+    });
+  }
+});
+$.getJSON("api/layers/pois", function (data) {
+  pois.addData(data);
+});
+
+var preferences = window.preferences || {};
 map = L.map("map", {
-  zoom: 10,
-  center: [50, 8.56],
+  zoom: preferences.zoom || 10,
+  center: preferences.center || [50, 8.56],
   layers: [mapquestOSM, markerClusters, positionsLayer, highlight],
   zoomControl: false,
   attributionControl: false
@@ -357,6 +381,7 @@ var baseLayers = {
 var groupedOverlays = {
   "Points of Interest": {
     "<img src='assets/img/theater.png' width='24' height='28'>&nbsp;Positions": positionsLayer,
+    "<img src='assets/img/globe.png' width='24' height='28'>&nbsp;POIs": pois,
   },
   //"History": {
     //"Last 24 hours": last24hours
@@ -489,3 +514,7 @@ if (!L.Browser.touch) {
 } else {
   L.DomEvent.disableClickPropagation(container);
 }
+
+if ("serviceWorker" in navigator) {
+  navigator.serviceWorker.register("sw.js");
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"owntracks"
+)
+
+// traccarWindow is the time span of the positions fetched with one request
+// from the Traccar API.
+const traccarWindow = 7 * 24 * time.Hour
+
+// knotsToKmh converts the speeds reported by Traccar to [km/h].
+const knotsToKmh = 1.852
+
+type traccarDevice struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	UniqueID string `json:"uniqueId"`
+}
+
+type traccarPosition struct {
+	FixTime    time.Time              `json:"fixTime"`
+	Valid      bool                   `json:"valid"`
+	Latitude   float64                `json:"latitude"`
+	Longitude  float64                `json:"longitude"`
+	Altitude   float64                `json:"altitude"`
+	Speed      float64                `json:"speed"`
+	Course     float64                `json:"course"`
+	Accuracy   float64                `json:"accuracy"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// deviceMap collects the --map flags of "daisser import traccar".
+type deviceMap map[string]string
+
+func (m deviceMap) String() string { return fmt.Sprint(map[string]string(m)) }
+
+func (m deviceMap) Set(v string) error {
+	from, to, ok := strings.Cut(v, "=")
+	user, tracker, _ := strings.Cut(to, "/")
+	if !ok || from == "" || user == "" || tracker == "" {
+		return fmt.Errorf("invalid mapping %q, expected <device>=<user>/<tracker>", v)
+	}
+	m[from] = to
+	return nil
+}
+
+// importTraccar implements "daisser import traccar", which copies the
+// history of the devices of a Traccar server through its REST API.
+//
+// The progress is saved to the state file after every window of positions,
+// so that an interrupted import continues where it stopped when it is
+// started again with the same state file.
+func importTraccar(args []string) error {
+	fs := flag.NewFlagSet("import traccar", flag.ExitOnError)
+	server := fs.String("url", "", "base URL of the Traccar server, like https://traccar.example.com (required)")
+	login := fs.String("login", "", "email and password of a Traccar user, as <email>:<password>")
+	user := fs.String("user", "", "user that unmapped devices are imported for, they are skipped if empty")
+	from := fs.String("from", "", "import positions from this date or RFC 3339 time on (required)")
+	to := fs.String("to", "", "import positions up to this date or RFC 3339 time, default now")
+	statePath := fs.String("state", "traccar-import.json", "file recording the progress of the import")
+	mapping := deviceMap{}
+	fs.Var(mapping, "map", "import the Traccar device with this name or unique ID for a device, as <device>=<user>/<tracker>, repeatable")
+	fs.Parse(args)
+
+	if *server == "" || *from == "" {
+		fs.Usage()
+		return errors.New("--url and --from are required")
+	}
+	if *user != "" {
+		if err := checkUserName(*user); err != nil {
+			return err
+		}
+	}
+	start, err := parseDate(*from)
+	if err != nil {
+		return fmt.Errorf("invalid --from: %v", err)
+	}
+	end := time.Now()
+	if *to != "" {
+		if end, err = parseDate(*to); err != nil {
+			return fmt.Errorf("invalid --to: %v", err)
+		}
+	}
+	tc := &traccarClient{base: strings.TrimSuffix(*server, "/"), client: &http.Client{Timeout: 5 * time.Minute}}
+	tc.email, tc.password, _ = strings.Cut(*login, ":")
+
+	// state maps the Traccar device IDs to the time their positions have
+	// been imported up to
+	state := make(map[string]time.Time)
+	if b, err := os.ReadFile(*statePath); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("%s: %v", *statePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	saveState := func() error {
+		b, err := json.MarshalIndent(state, "", "\t")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(*statePath, b, 0600)
+	}
+
+	var devices []traccarDevice
+	if err := tc.get("/api/devices", nil, &devices); err != nil {
+		return err
+	}
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	im := newImporter(store)
+
+	for _, d := range devices {
+		target, ok := mapping[d.UniqueID]
+		if !ok {
+			target, ok = mapping[d.Name]
+		}
+		if !ok && *user != "" {
+			target, ok = *user+"/"+d.UniqueID, true
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: skipped, not mapped\n", d.Name)
+			continue
+		}
+		owner, tracker, _ := strings.Cut(target, "/")
+		key := fmt.Sprint(d.ID)
+		t := start
+		if done := state[key]; done.After(t) {
+			t = done
+		}
+		for t.Before(end) {
+			next := t.Add(traccarWindow)
+			if next.After(end) {
+				next = end
+			}
+			var positions []traccarPosition
+			q := url.Values{
+				"deviceId": {key},
+				"from":     {t.UTC().Format(time.RFC3339)},
+				"to":       {next.UTC().Format(time.RFC3339)},
+			}
+			if err := tc.get("/api/positions", q, &positions); err != nil {
+				return fmt.Errorf("%s: %v", d.Name, err)
+			}
+			before := im.imported
+			for _, p := range positions {
+				if !p.Valid {
+					im.rejected++
+					continue
+				}
+				if err := im.add(p.locationUpdate(owner, tracker)); err != nil {
+					return err
+				}
+			}
+			state[key] = next
+			if err := saveState(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%s -> %s: %s to %s, %d positions, %d new\n",
+				d.Name, target, t.Format("2006-01-02"), next.Format("2006-01-02"), len(positions), im.imported-before)
+			t = next
+		}
+	}
+	im.report()
+	return nil
+}
+
+// locationUpdate converts p to a position of the device user/tracker.
+func (p traccarPosition) locationUpdate(user, tracker string) owntracks.LocationUpdate {
+	lu := owntracks.LocationUpdate{
+		T:         p.FixTime,
+		Trigger:   owntracks.UnknownTrigger,
+		User:      user,
+		ClientID:  "traccar",
+		TrackerID: tracker,
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		Altitude:  int(p.Altitude + 0.5),
+		Velocity:  int(p.Speed*knotsToKmh + 0.5),
+		Course:    int(p.Course + 0.5),
+		Accuracy:  int(p.Accuracy + 0.5),
+	}
+	if b, ok := p.Attributes["batteryLevel"].(float64); ok {
+		lu.Battery = int(b + 0.5)
+	}
+	return lu
+}
+
+// traccarClient calls the REST API of a Traccar server.
+type traccarClient struct {
+	base            string
+	email, password string
+	client          *http.Client
+}
+
+// get decodes the JSON response of the API endpoint path with the query q
+// into v.
+func (c *traccarClient) get(path string, q url.Values, v interface{}) error {
+	u := c.base + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.email != "" {
+		req.SetBasicAuth(c.email, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"ingest"
+	"owntracks"
+	"storage"
+)
+
+// The commands in this file move location history between daisser and
+// other location tracking software.
+
+// importCommand implements "daisser import <format> ...".
+func importCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: daisser import dawarich|traccar [flags]")
+	}
+	switch args[0] {
+	case "dawarich":
+		return importDawarich(args[1:])
+	case "traccar":
+		return importTraccar(args[1:])
+	}
+	return fmt.Errorf("unknown import format %q", args[0])
+}
+
+// exportCommand implements "daisser export <format> ...".
+func exportCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: daisser export dawarich|kml [flags]")
+	}
+	switch args[0] {
+	case "dawarich":
+		return exportDawarich(args[1:])
+	case "kml":
+		return exportKML(args[1:])
+	}
+	return fmt.Errorf("unknown export format %q", args[0])
+}
+
+// openStore opens the configured storage, which must be persistent.
+func openStore() (storage.Store, error) {
+	if config.DbDriver == "memory" {
+		return nil, errors.New("this command needs a persistent DbDriver")
+	}
+	return storage.Open(config.DbDriver, config.DbFile)
+}
+
+// parseDate parses the value of a time flag, either RFC 3339 or a date.
+// An empty value yields the zero time.
+func parseDate(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
+// importer adds positions to a storage, skipping those that are already
+// stored, so that an interrupted import can simply be run again.
+type importer struct {
+	store storage.Store
+	// known holds the stored times of every device seen so far, in
+	// seconds since the Unix epoch.
+	known    map[string]map[int64]bool
+	imported int
+	skipped  int
+	rejected int
+}
+
+func newImporter(store storage.Store) *importer {
+	return &importer{store: store, known: make(map[string]map[int64]bool)}
+}
+
+// add stores lu unless its device already has a position at that time.
+// Positions failing validation are counted and skipped.
+func (im *importer) add(lu owntracks.LocationUpdate) error {
+	name := storage.DeviceName(lu)
+	known, ok := im.known[name]
+	if !ok {
+		stored, err := im.store.QueryPositions(storage.Query{User: lu.User, TrackerID: lu.TrackerID})
+		if err != nil {
+			return err
+		}
+		known = make(map[int64]bool, len(stored))
+		for _, s := range stored {
+			known[s.T.Unix()] = true
+		}
+		im.known[name] = known
+	}
+	if known[lu.T.Unix()] {
+		im.skipped++
+		return nil
+	}
+	if err := ingest.Accept(im.store, lu); err != nil {
+		im.rejected++
+		return nil
+	}
+	known[lu.T.Unix()] = true
+	im.imported++
+	return nil
+}
+
+// report prints the totals of the import.
+func (im *importer) report() {
+	fmt.Fprintf(os.Stderr, "imported %d positions, skipped %d already stored, rejected %d invalid\n",
+		im.imported, im.skipped, im.rejected)
+}
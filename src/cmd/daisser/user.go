@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"owntracks"
+	"storage"
+)
+
+// The commands in this file change the owner of stored data. They rewrite
+// the files of the server and must not run while the server is running.
+
+// userCommand implements "daisser user rename <old> <new>".
+func userCommand(args []string) error {
+	if len(args) != 3 || args[0] != "rename" {
+		return errors.New("usage: daisser user rename <old> <new>")
+	}
+	old, new := args[1], args[2]
+	if err := checkUserName(new); err != nil {
+		return err
+	}
+	if old == new {
+		return errors.New("old and new user are the same")
+	}
+	users, err := storedUsers()
+	if err != nil {
+		return err
+	}
+	if users[new] {
+		return fmt.Errorf("user %q already has positions, use 'daisser device reassign' to merge devices", new)
+	}
+	n, err := storage.RewriteFile(config.DbFile, func(lu *owntracks.LocationUpdate) bool {
+		if lu.User != old {
+			return false
+		}
+		lu.User = new
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("renamed user %q to %q in %d positions\n", old, new, n)
+	return renameUserData(old, new)
+}
+
+// deviceCommand implements
+// "daisser device reassign [-merge] <user>/<tracker> <user>".
+func deviceCommand(args []string) error {
+	const usage = "usage: daisser device reassign [-merge] <user>/<tracker> <user>"
+	if len(args) == 0 || args[0] != "reassign" {
+		return errors.New(usage)
+	}
+	fs := flag.NewFlagSet("device reassign", flag.ExitOnError)
+	merge := fs.Bool("merge", false, "merge the positions into the device of the new user if it already has positions")
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 {
+		return errors.New(usage)
+	}
+	name, new := fs.Arg(0), fs.Arg(1)
+	user, tracker, ok := strings.Cut(name, "/")
+	if !ok || user == "" || tracker == "" {
+		return fmt.Errorf("invalid device %q, expected <user>/<tracker>", name)
+	}
+	if err := checkUserName(new); err != nil {
+		return err
+	}
+	if user == new {
+		return errors.New("old and new user are the same")
+	}
+	devices, err := storedDevices()
+	if err != nil {
+		return err
+	}
+	if !devices[name] {
+		return fmt.Errorf("device %q has no positions", name)
+	}
+	if devices[new+"/"+tracker] && !*merge {
+		return fmt.Errorf("device %q already has positions, use -merge to merge them", new+"/"+tracker)
+	}
+	n, err := storage.RewriteFile(config.DbFile, func(lu *owntracks.LocationUpdate) bool {
+		if lu.User != user || lu.TrackerID != tracker {
+			return false
+		}
+		lu.User = new
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reassigned %d positions of %s to %q\n", n, name, new)
+	infos, err := storage.OpenDeviceInfos(config.DevicesFile)
+	if err != nil {
+		return err
+	}
+	// a merged device keeps its own info
+	return infos.Rename(name, new+"/"+tracker)
+}
+
+func checkUserName(name string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	return nil
+}
+
+// storedUsers returns the set of users that have positions in the
+// configured storage, which must be persistent.
+func storedUsers() (map[string]bool, error) {
+	store, err := openStored()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	users, err := store.Users()
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	for _, u := range users {
+		set[u] = true
+	}
+	return set, nil
+}
+
+// storedDevices returns the set of the names of the devices that have
+// positions in the configured storage, which must be persistent.
+func storedDevices() (map[string]bool, error) {
+	store, err := openStored()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	devices, err := store.Devices()
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	for _, d := range devices {
+		set[d.Name()] = true
+	}
+	return set, nil
+}
+
+// openStored opens the configured storage if it is persistent.
+func openStored() (storage.Store, error) {
+	if config.DbDriver != "file" {
+		return nil, fmt.Errorf("changing stored positions is not supported for DbDriver %q", config.DbDriver)
+	}
+	return storage.Open(config.DbDriver, config.DbFile)
+}
+
+// renameUserData moves the preferences, device infos, places and POIs of
+// user old to user new.
+func renameUserData(old, new string) error {
+	prefs, err := storage.OpenPreferences(config.PreferencesFile)
+	if err != nil {
+		return err
+	}
+	if err := prefs.RenameUser(old, new); err != nil {
+		return err
+	}
+	devices, err := storage.OpenDeviceInfos(config.DevicesFile)
+	if err != nil {
+		return err
+	}
+	for _, name := range devices.Names() {
+		if tracker, ok := strings.CutPrefix(name, old+"/"); ok {
+			if err := devices.Rename(name, new+"/"+tracker); err != nil {
+				return err
+			}
+		}
+	}
+	places, err := storage.OpenPlaces(config.PlacesFile)
+	if err != nil {
+		return err
+	}
+	if err := places.ReassignUser(old, new); err != nil {
+		return err
+	}
+	pois, err := storage.OpenPOIs(config.POIsFile)
+	if err != nil {
+		return err
+	}
+	return pois.ReassignUser(old, new)
+}
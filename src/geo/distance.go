@@ -0,0 +1,27 @@
+package geo
+
+import "math"
+
+// EarthRadius is the mean radius of the earth in [m].
+const EarthRadius = 6371000
+
+// Distance returns the great-circle distance in [m] between the points with
+// the coordinates lat1, lon1 and lat2, lon2 in degrees.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Bearing returns the initial course in degrees clockwise from north on the
+// great circle from lat1, lon1 to lat2, lon2.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLon := rad(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(rad(lat2))
+	x := math.Cos(rad(lat1))*math.Sin(rad(lat2)) - math.Sin(rad(lat1))*math.Cos(rad(lat2))*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
@@ -0,0 +1,40 @@
+package geo
+
+import "math"
+
+const plusCodeAlphabet = "23456789CFGHJMPQRVWX"
+
+// plusCodeResolution is the number of cells per degree of the last digit
+// pair of a full Plus Code, 20^3.
+const plusCodeResolution = 8000
+
+// PlusCode encodes the coordinates lat and lon in degrees as a full Open
+// Location Code (https://plus.codes) of 10 digits, like "9F4MGC22+22",
+// which identifies a cell of about 14 by 14 meters.
+func PlusCode(lat, lon float64) string {
+	lat = math.Min(math.Max(lat, -90), 90)
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	latVal := int64(math.Floor((lat + 90) * plusCodeResolution))
+	lonVal := int64(math.Floor(lon * plusCodeResolution))
+	// the north pole belongs to the cells below it
+	if latVal >= 180*plusCodeResolution {
+		latVal = 180*plusCodeResolution - 1
+	}
+	code := make([]byte, 11)
+	// the digit pairs are computed from the finest to the coarsest
+	for i := 4; i >= 0; i-- {
+		pos := 2 * i
+		if pos >= 8 {
+			pos++
+		}
+		code[pos] = plusCodeAlphabet[latVal%20]
+		code[pos+1] = plusCodeAlphabet[lonVal%20]
+		latVal /= 20
+		lonVal /= 20
+	}
+	code[8] = '+'
+	return string(code)
+}
@@ -0,0 +1,180 @@
+// Package gpkg writes vector layers as OGC GeoPackage, the SQLite based
+// format GIS programs like QGIS open directly. Only points and line strings
+// in WGS 84 are supported, which suits positions and tracks.
+package gpkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// applicationID is "GPKG", userVersion the GeoPackage version 1.3.
+	applicationID = 0x47504B47
+	userVersion   = 10300
+	// srsID is the id of WGS 84 in gpkg_spatial_ref_sys.
+	srsID = 4326
+
+	// TimeFormat is the format of DATETIME columns.
+	TimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// Geometry types of a Layer.
+const (
+	Point      = "POINT"
+	LineString = "LINESTRING"
+)
+
+// Column is an attribute of the features of a Layer. Type is a GeoPackage
+// data type like "TEXT", "INTEGER", "DOUBLE" or "DATETIME".
+type Column struct {
+	Name string
+	Type string
+}
+
+// Feature is a feature of a Layer. Coordinates are longitude and latitude,
+// one pair for points. Values holds the attributes in the order of the
+// columns, they are nil, int, int64, float64, string or time.Time.
+type Feature struct {
+	Coordinates [][2]float64
+	Values      []interface{}
+}
+
+// Layer is a table of features with the same geometry type and columns.
+type Layer struct {
+	Name         string
+	Description  string
+	GeometryType string
+	Columns      []Column
+	Features     []Feature
+}
+
+// Encode returns a GeoPackage with layers, created at now.
+func Encode(layers []Layer, now time.Time) ([]byte, error) {
+	d := newDatabase(applicationID, userVersion)
+	d.createTable("gpkg_spatial_ref_sys", `CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER NOT NULL PRIMARY KEY, organization TEXT NOT NULL, organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)`, []row{
+		{-1, []interface{}{"Undefined cartesian SRS", nil, "NONE", -1, "undefined", "undefined cartesian coordinate reference system"}},
+		{0, []interface{}{"Undefined geographic SRS", nil, "NONE", 0, "undefined", "undefined geographic coordinate reference system"}},
+		{srsID, []interface{}{"WGS 84 geodetic", nil, "EPSG", srsID, wgs84, "longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid"}},
+	})
+
+	var contents, geometryColumns []row
+	for i, l := range layers {
+		if !validName(l.Name) {
+			return nil, fmt.Errorf("gpkg: invalid layer name %q", l.Name)
+		}
+		if l.GeometryType != Point && l.GeometryType != LineString {
+			return nil, fmt.Errorf("gpkg: unsupported geometry type %q", l.GeometryType)
+		}
+		cols := []string{"fid INTEGER PRIMARY KEY NOT NULL", "geom " + l.GeometryType}
+		for _, c := range l.Columns {
+			if !validName(c.Name) {
+				return nil, fmt.Errorf("gpkg: invalid column name %q", c.Name)
+			}
+			cols = append(cols, quote(c.Name)+" "+c.Type)
+		}
+		bounds := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		var rows []row
+		for j, f := range l.Features {
+			if len(f.Values) != len(l.Columns) {
+				return nil, fmt.Errorf("gpkg: feature %d of %s has %d values for %d columns", j, l.Name, len(f.Values), len(l.Columns))
+			}
+			values := []interface{}{nil, geometry(l.GeometryType, f.Coordinates)}
+			for _, v := range f.Values {
+				if t, ok := v.(time.Time); ok {
+					v = t.UTC().Format(TimeFormat)
+				}
+				values = append(values, v)
+			}
+			rows = append(rows, row{int64(j + 1), values})
+			for _, c := range f.Coordinates {
+				bounds[0], bounds[1] = math.Min(bounds[0], c[0]), math.Min(bounds[1], c[1])
+				bounds[2], bounds[3] = math.Max(bounds[2], c[0]), math.Max(bounds[3], c[1])
+			}
+		}
+		d.createTable(l.Name, fmt.Sprintf("CREATE TABLE %s (%s)", quote(l.Name), strings.Join(cols, ", ")), rows)
+
+		c := []interface{}{l.Name, "features", l.Name, l.Description, now.UTC().Format(TimeFormat), nil, nil, nil, nil, srsID}
+		if len(rows) > 0 {
+			c[5], c[6], c[7], c[8] = bounds[0], bounds[1], bounds[2], bounds[3]
+		}
+		contents = append(contents, row{int64(i + 1), c})
+		geometryColumns = append(geometryColumns, row{int64(i + 1), []interface{}{l.Name, "geom", l.GeometryType, srsID, 0, 0}})
+	}
+
+	d.createTable("gpkg_contents", `CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT UNIQUE, description TEXT DEFAULT '', last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')), min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER, CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id))`, contents)
+	var byName, byIdentifier [][]interface{}
+	for _, r := range contents {
+		byName = append(byName, []interface{}{r.values[0], r.id})
+		byIdentifier = append(byIdentifier, []interface{}{r.values[2], r.id})
+	}
+	if err := d.createAutoIndex("gpkg_contents", 1, byName); err != nil {
+		return nil, err
+	}
+	if err := d.createAutoIndex("gpkg_contents", 2, byIdentifier); err != nil {
+		return nil, err
+	}
+
+	d.createTable("gpkg_geometry_columns", `CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL, CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name), CONSTRAINT uk_gc_table_name UNIQUE (table_name), CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name), CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))`, geometryColumns)
+	var byColumn, byTable [][]interface{}
+	for _, r := range geometryColumns {
+		byColumn = append(byColumn, []interface{}{r.values[0], r.values[1], r.id})
+		byTable = append(byTable, []interface{}{r.values[0], r.id})
+	}
+	if err := d.createAutoIndex("gpkg_geometry_columns", 1, byColumn); err != nil {
+		return nil, err
+	}
+	if err := d.createAutoIndex("gpkg_geometry_columns", 2, byTable); err != nil {
+		return nil, err
+	}
+	return d.bytes()
+}
+
+// validName reports whether name can be used as table or column name.
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, "gpkg_") && !strings.HasPrefix(name, "sqlite_") &&
+		!strings.ContainsAny(name, "\"\x00")
+}
+
+// quote returns name as SQL identifier.
+func quote(name string) string {
+	return `"` + name + `"`
+}
+
+// geometry returns the GeoPackage binary of a point or line string: a header
+// with the envelope of line strings, followed by the geometry as WKB.
+func geometry(kind string, coords [][2]float64) []byte {
+	b := []byte{'G', 'P', 0, 1} // little endian, no envelope
+	if kind == LineString {
+		b[3] |= 1 << 1 // envelope [minx, maxx, miny, maxy]
+	}
+	b = binary.LittleEndian.AppendUint32(b, srsID)
+	if kind == LineString {
+		env := [4]float64{math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)}
+		for _, c := range coords {
+			env[0], env[1] = math.Min(env[0], c[0]), math.Max(env[1], c[0])
+			env[2], env[3] = math.Min(env[2], c[1]), math.Max(env[3], c[1])
+		}
+		for _, v := range env {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+	}
+	b = append(b, 1) // little endian
+	if kind == Point {
+		b = binary.LittleEndian.AppendUint32(b, 1)
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, 2)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(coords)))
+	}
+	for _, c := range coords {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(c[0]))
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(c[1]))
+	}
+	return b
+}
+
+// wgs84 is the definition of EPSG:4326 as OGC WKT.
+const wgs84 = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AXIS["Latitude",NORTH],AXIS["Longitude",EAST],AUTHORITY["EPSG","4326"]]`
@@ -0,0 +1,354 @@
+package gpkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The database is written in the SQLite file format version 3, see
+// https://www.sqlite.org/fileformat.html. Only what a freshly written,
+// read-only export needs is supported: tables without overflowing schema,
+// and indexes that fit into a single page.
+
+const (
+	pageSize = 4096
+	// sqliteVersion is stored as the version of the library that wrote the
+	// file.
+	sqliteVersion = 3046000
+
+	pageLeafTable     = 0x0d
+	pageInteriorTable = 0x05
+	pageLeafIndex     = 0x0a
+
+	// maxLocal is the largest payload of a table leaf cell that is stored
+	// in the page, minLocal the part of larger payloads kept in the page.
+	maxLocal = pageSize - 35
+	minLocal = (pageSize-12)*32/255 - 23
+	// maxIndexLocal is the largest payload of an index cell, larger ones
+	// would overflow, which is not supported.
+	maxIndexLocal = (pageSize-12)*64/255 - 23
+)
+
+var errSchemaTooLarge = errors.New("gpkg: schema does not fit into the first page")
+
+// row is a row of a table with its rowid.
+type row struct {
+	id     int64
+	values []interface{}
+}
+
+// schemaEntry is a row of the sqlite_master table.
+type schemaEntry struct {
+	kind, name, table string
+	root              int
+	sql               interface{}
+}
+
+// database collects the pages of a SQLite database. Page 1 holds the file
+// header and the schema and is written last.
+type database struct {
+	pages       [][]byte
+	schema      []schemaEntry
+	userVersion uint32
+	appID       uint32
+}
+
+func newDatabase(appID, userVersion uint32) *database {
+	return &database{pages: [][]byte{nil}, appID: appID, userVersion: userVersion}
+}
+
+// newPage appends an empty page and returns it with its number.
+func (d *database) newPage() ([]byte, int) {
+	p := make([]byte, pageSize)
+	d.pages = append(d.pages, p)
+	return p, len(d.pages)
+}
+
+// createTable adds a table created by sql holding rows, which must be
+// sorted by their ids.
+func (d *database) createTable(name, sql string, rows []row) {
+	var cells [][]byte
+	var keys []int64
+	for _, r := range rows {
+		cells = append(cells, d.tableCell(r))
+		keys = append(keys, r.id)
+	}
+	d.schema = append(d.schema, schemaEntry{"table", name, name, d.tableTree(cells, keys), sql})
+}
+
+// createAutoIndex adds the index SQLite creates for the nth UNIQUE or
+// PRIMARY KEY constraint of table. Each entry holds the values of the
+// indexed columns followed by the rowid.
+func (d *database) createAutoIndex(table string, n int, entries [][]interface{}) error {
+	sort.Slice(entries, func(i, j int) bool { return compareRecords(entries[i], entries[j]) < 0 })
+	p, no := d.newPage()
+	var cells [][]byte
+	for _, e := range entries {
+		rec := record(e)
+		if len(rec) > maxIndexLocal {
+			return fmt.Errorf("gpkg: index entry of %s too large", table)
+		}
+		cells = append(cells, append(putVarint(nil, uint64(len(rec))), rec...))
+	}
+	if !fitPage(cells, 8, 0) {
+		return fmt.Errorf("gpkg: index of %s does not fit into a page", table)
+	}
+	writePage(p, 0, pageLeafIndex, cells, 0)
+	name := fmt.Sprintf("sqlite_autoindex_%s_%d", table, n)
+	d.schema = append(d.schema, schemaEntry{"index", name, table, no, nil})
+	return nil
+}
+
+// tableCell returns the leaf cell of r, moving the end of large payloads to
+// overflow pages.
+func (d *database) tableCell(r row) []byte {
+	payload := record(r.values)
+	c := putVarint(nil, uint64(len(payload)))
+	c = putVarint(c, uint64(r.id))
+	if len(payload) <= maxLocal {
+		return append(c, payload...)
+	}
+	local := minLocal + (len(payload)-minLocal)%(pageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	c = append(c, payload[:local]...)
+	first := 0
+	var prev []byte
+	for rest := payload[local:]; len(rest) > 0; {
+		p, no := d.newPage()
+		if prev == nil {
+			first = no
+		} else {
+			binary.BigEndian.PutUint32(prev, uint32(no))
+		}
+		n := copy(p[4:], rest)
+		rest = rest[n:]
+		prev = p
+	}
+	return binary.BigEndian.AppendUint32(c, uint32(first))
+}
+
+// tableTree writes the b-tree of a table with the leaf cells and their keys
+// and returns its root page.
+func (d *database) tableTree(cells [][]byte, keys []int64) int {
+	type child struct {
+		page int
+		key  int64
+	}
+	var level []child
+	for i := 0; i < len(cells) || len(level) == 0; {
+		n := 0
+		for space := pageSize - 8; i+n < len(cells) && len(cells[i+n])+2 <= space; n++ {
+			space -= len(cells[i+n]) + 2
+		}
+		p, no := d.newPage()
+		writePage(p, 0, pageLeafTable, cells[i:i+n], 0)
+		key := int64(0)
+		if n > 0 {
+			key = keys[i+n-1]
+		}
+		level = append(level, child{no, key})
+		i += n
+	}
+	// every interior cell takes at most 4+9 bytes and a pointer
+	const perPage = (pageSize - 12) / 15
+	for len(level) > 1 {
+		pages := (len(level) + perPage) / (perPage + 1)
+		per := (len(level) + pages - 1) / pages
+		var next []child
+		for i := 0; i < len(level); i += per {
+			group := level[i:min(i+per, len(level))]
+			var cells [][]byte
+			for _, c := range group[:len(group)-1] {
+				cell := binary.BigEndian.AppendUint32(nil, uint32(c.page))
+				cells = append(cells, putVarint(cell, uint64(c.key)))
+			}
+			last := group[len(group)-1]
+			p, no := d.newPage()
+			writePage(p, 0, pageInteriorTable, cells, last.page)
+			next = append(next, child{no, last.key})
+		}
+		level = next
+	}
+	return level[0].page
+}
+
+// fitPage reports whether cells fit into a page with a header of size
+// header that starts at offset.
+func fitPage(cells [][]byte, header, offset int) bool {
+	space := pageSize - offset - header
+	for _, c := range cells {
+		space -= len(c) + 2
+	}
+	return space >= 0
+}
+
+// writePage writes a b-tree page of kind with cells to p, starting at
+// offset. right is the right-most child of interior pages.
+func writePage(p []byte, offset int, kind byte, cells [][]byte, right int) {
+	p[offset] = kind
+	header := 8
+	if kind == pageInteriorTable {
+		header = 12
+		binary.BigEndian.PutUint32(p[offset+8:], uint32(right))
+	}
+	binary.BigEndian.PutUint16(p[offset+3:], uint16(len(cells)))
+	end := pageSize
+	for i, c := range cells {
+		end -= len(c)
+		copy(p[end:], c)
+		binary.BigEndian.PutUint16(p[offset+header+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(p[offset+5:], uint16(end))
+}
+
+// bytes returns the database file.
+func (d *database) bytes() ([]byte, error) {
+	var cells [][]byte
+	for i, e := range d.schema {
+		cells = append(cells, d.tableCell(row{int64(i + 1), []interface{}{e.kind, e.name, e.table, e.root, e.sql}}))
+	}
+	if !fitPage(cells, 8, 100) {
+		return nil, errSchemaTooLarge
+	}
+	p := make([]byte, pageSize)
+	d.pages[0] = p
+	writePage(p, 100, pageLeafTable, cells, 0)
+
+	copy(p, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(p[16:], pageSize)
+	p[18], p[19] = 1, 1 // legacy journal
+	p[21], p[22], p[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(p[24:], 1) // change counter
+	binary.BigEndian.PutUint32(p[28:], uint32(len(d.pages)))
+	binary.BigEndian.PutUint32(p[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(p[44:], 4) // schema format
+	binary.BigEndian.PutUint32(p[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(p[60:], d.userVersion)
+	binary.BigEndian.PutUint32(p[68:], d.appID)
+	binary.BigEndian.PutUint32(p[92:], 1) // version valid for the change counter
+	binary.BigEndian.PutUint32(p[96:], sqliteVersion)
+
+	b := make([]byte, 0, len(d.pages)*pageSize)
+	for _, p := range d.pages {
+		b = append(b, p...)
+	}
+	return b, nil
+}
+
+// record returns values in the record format. They may be nil, int, int64,
+// float64, string or []byte.
+func record(values []interface{}) []byte {
+	var header, body []byte
+	for _, v := range values {
+		if i, ok := v.(int); ok {
+			v = int64(i)
+		}
+		switch v := v.(type) {
+		case nil:
+			header = putVarint(header, 0)
+		case int64:
+			switch {
+			case v == 0:
+				header = putVarint(header, 8)
+			case v == 1:
+				header = putVarint(header, 9)
+			default:
+				types := []struct {
+					serial uint64
+					size   int
+				}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}, {6, 8}}
+				for _, t := range types {
+					if t.size == 8 || (v >= -1<<(8*t.size-1) && v < 1<<(8*t.size-1)) {
+						header = putVarint(header, t.serial)
+						for s := t.size - 1; s >= 0; s-- {
+							body = append(body, byte(v>>(8*s)))
+						}
+						break
+					}
+				}
+			}
+		case float64:
+			header = putVarint(header, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			header = putVarint(header, uint64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			header = putVarint(header, uint64(12+2*len(v)))
+			body = append(body, v...)
+		default:
+			panic(fmt.Sprintf("gpkg: unsupported value %T", v))
+		}
+	}
+	// the size of the header includes its own varint
+	n := len(header) + 1
+	for len(putVarint(nil, uint64(n)))+len(header) != n {
+		n = len(putVarint(nil, uint64(n))) + len(header)
+	}
+	b := putVarint(nil, uint64(n))
+	return append(append(b, header...), body...)
+}
+
+// compareRecords compares index entries of strings and integers like the
+// BINARY collation.
+func compareRecords(a, b []interface{}) int {
+	for i := range a {
+		switch x := a[i].(type) {
+		case string:
+			y := b[i].(string)
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		case int64:
+			y := b[i].(int64)
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// putVarint appends v as big-endian variable-length integer of up to 9
+// bytes to b.
+func putVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	n := 0
+	for {
+		buf[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := buf[i]
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
@@ -0,0 +1,114 @@
+// Package i18n translates the texts daisser shows to its users.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language used if none of the requested ones is supported.
+const Default = "en"
+
+// catalog maps languages to the translations of the message keys. Every key
+// must be translated to Default.
+var catalog = map[string]map[string]string{
+	"en": {
+		"login.title":     "Please sign in",
+		"login.user":      "User",
+		"login.password":  "Password",
+		"login.remember":  "Remember me",
+		"login.submit":    "Sign in",
+		"login.invalid":   "Invalid username/password",
+		"logout.done":     "You have been logged out",
+		"live.title":      "Live position",
+		"live.waiting":    "Waiting for the position…",
+		"live.updated":    "Updated at",
+		"live.expired":    "This live share has ended.",
+		"error.404.title": "Not found",
+		"error.404.text":  "The page you are looking for does not exist.",
+		"error.500.title": "Internal server error",
+		"error.500.text":  "Something went wrong, please try again later.",
+		"error.503.title": "Down for maintenance",
+		"error.503.text":  "daisser is being maintained, please try again in a few minutes.",
+		"error.back":      "Back to the map",
+	},
+	"de": {
+		"login.title":     "Bitte einloggen",
+		"login.user":      "Benutzer",
+		"login.password":  "Passwort",
+		"login.remember":  "Angemeldet bleiben",
+		"login.submit":    "Anmelden",
+		"login.invalid":   "Ungültiger Benutzername oder Passwort",
+		"logout.done":     "Sie wurden abgemeldet",
+		"live.title":      "Live-Position",
+		"live.waiting":    "Warte auf die Position…",
+		"live.updated":    "Aktualisiert um",
+		"live.expired":    "Diese Live-Freigabe ist beendet.",
+		"error.404.title": "Nicht gefunden",
+		"error.404.text":  "Die gesuchte Seite existiert nicht.",
+		"error.500.title": "Interner Serverfehler",
+		"error.500.text":  "Etwas ist schiefgelaufen, bitte später erneut versuchen.",
+		"error.503.title": "Wartungsarbeiten",
+		"error.503.text":  "daisser wird gerade gewartet, bitte in einigen Minuten erneut versuchen.",
+		"error.back":      "Zurück zur Karte",
+	},
+}
+
+// Supported returns the languages that have a catalog, sorted.
+func Supported() []string {
+	var langs []string
+	for l := range catalog {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// IsSupported reports whether lang has a catalog.
+func IsSupported(lang string) bool {
+	_, ok := catalog[lang]
+	return ok
+}
+
+// T returns the translation of key to lang, formatted with args like
+// fmt.Sprintf. Keys missing in lang fall back to Default, unknown keys are
+// returned unchanged.
+func T(lang, key string, args ...interface{}) string {
+	msg, ok := catalog[lang][key]
+	if !ok {
+		if msg, ok = catalog[Default][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Negotiate picks the language for a user who prefers preferred, which may
+// be empty, and whose client sent the Accept-Language header acceptLanguage.
+func Negotiate(preferred, acceptLanguage string) string {
+	if IsSupported(preferred) {
+		return preferred
+	}
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		// only the primary language subtag is considered, "de-AT" is "de"
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if IsSupported(lang) && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
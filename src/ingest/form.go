@@ -0,0 +1,109 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFormBody is the largest request body the HTTP protocols read.
+const maxFormBody = 1 << 20
+
+// requestValues returns the parameters of r, no matter how the tracker
+// sent them: in the query string, as form body, urlencoded or multipart,
+// or as JSON object. Nested JSON objects are flattened with their keys
+// joined by dots, so {"coords": {"latitude": 1}} yields "coords.latitude".
+// Values in the body take precedence over those in the query string.
+func requestValues(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+	v := r.URL.Query()
+	if r.Body == nil || r.Method == "GET" || r.Method == "HEAD" {
+		return v, nil
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	if ct == "multipart/form-data" {
+		if err := r.ParseMultipartForm(maxFormBody); err != nil {
+			return nil, err
+		}
+		for k, vs := range r.MultipartForm.Value {
+			v[k] = vs
+		}
+		return v, nil
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	// some trackers send JSON without saying so
+	if ct == "application/json" || strings.HasSuffix(ct, "+json") || bytes.HasPrefix(b, []byte("{")) {
+		var o map[string]interface{}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &o); err != nil {
+				return nil, err
+			}
+		}
+		flattenJSON(v, "", o)
+		return v, nil
+	}
+	// urlencoded, also when trackers send no content type at all
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range form {
+		v[k] = vs
+	}
+	return v, nil
+}
+
+// flattenJSON adds the members of o to v, with the keys of nested objects
+// prefixed by those of their parents.
+func flattenJSON(v url.Values, prefix string, o map[string]interface{}) {
+	for k, e := range o {
+		switch e := e.(type) {
+		case map[string]interface{}:
+			flattenJSON(v, prefix+k+".", e)
+		case string:
+			v.Set(prefix+k, e)
+		case float64:
+			v.Set(prefix+k, strconv.FormatFloat(e, 'f', -1, 64))
+		case bool:
+			v.Set(prefix+k, strconv.FormatBool(e))
+		}
+	}
+}
+
+// firstValue returns the first of the parameters keys that is set in v.
+func firstValue(v url.Values, keys ...string) (string, string) {
+	for _, k := range keys {
+		if s := v.Get(k); s != "" {
+			return k, s
+		}
+	}
+	return "", ""
+}
+
+// parseTimestamp parses the timestamps trackers send: seconds or
+// milliseconds since the Unix epoch, RFC 3339, or date and time in UTC.
+func parseTimestamp(s string) (time.Time, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(int64(n)), nil
+		}
+		return time.Unix(int64(n), 0), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
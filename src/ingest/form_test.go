@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"middleware"
+	"owntracks"
+)
+
+// multipartBody returns the form fields as multipart body and its content
+// type.
+func multipartBody(fields map[string]string) (string, string) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	w.Close()
+	return b.String(), w.FormDataContentType()
+}
+
+// TestRequestShapes sends the same position in every shape OsmAnd and
+// Traccar clients use.
+func TestRequestShapes(t *testing.T) {
+	mp, mpType := multipartBody(map[string]string{"id": "1", "lat": "50.1", "lon": "8.2", "timestamp": "1760000000", "speed": "10", "batt": "80"})
+	for _, c := range []struct {
+		name, method, url, contentType, body string
+	}{
+		{"query string", "GET", "/ingest/osmand?id=1&lat=50.1&lon=8.2&timestamp=1760000000&speed=10&batt=80", "", ""},
+		{"urlencoded body", "POST", "/ingest/osmand", "application/x-www-form-urlencoded",
+			"id=1&lat=50.1&lon=8.2&timestamp=1760000000&speed=10&batt=80"},
+		{"urlencoded body without content type", "POST", "/ingest/osmand", "",
+			"id=1&lat=50.1&lon=8.2&timestamp=1760000000&speed=10&batt=80"},
+		{"multipart body", "POST", "/ingest/osmand", mpType, mp},
+		{"flat JSON", "POST", "/ingest/osmand", "application/json",
+			`{"id": "1", "lat": 50.1, "lon": 8.2, "timestamp": 1760000000, "speed": 10, "batt": 80}`},
+		{"Traccar JSON", "POST", "/ingest/osmand", "application/json",
+			`{"device_id": "1", "location": {"timestamp": "2025-10-09T08:53:20Z",
+			"coords": {"latitude": 50.1, "longitude": 8.2, "speed": 5.14444, "heading": 90},
+			"battery": {"level": 0.8}}}`},
+	} {
+		var got []owntracks.LocationUpdate
+		w := serveOsmAnd(c.method, c.url, c.contentType, c.body, func(lu owntracks.LocationUpdate) error {
+			got = append(got, lu)
+			return nil
+		})
+		if w.Code != http.StatusOK || len(got) != 1 {
+			t.Errorf("%s: %d %s, %d positions", c.name, w.Code, w.Body, len(got))
+			continue
+		}
+		lu := got[0]
+		if lu.User != "alice" || lu.TrackerID != "phone" || lu.Latitude != 50.1 || lu.Longitude != 8.2 ||
+			!lu.T.Equal(time.Unix(1760000000, 0)) || lu.Velocity != 19 || lu.Battery != 80 {
+			t.Errorf("%s: got %+v", c.name, lu)
+		}
+	}
+}
+
+// TestRequestNaN checks that coordinates that are not numbers are rejected
+// in every shape.
+func TestRequestNaN(t *testing.T) {
+	for _, c := range []struct {
+		name, method, url, contentType, body string
+	}{
+		{"query string", "GET", "/ingest/osmand?id=1&lat=NaN&lon=8", "", ""},
+		{"urlencoded body", "POST", "/ingest/osmand", "application/x-www-form-urlencoded", "id=1&lat=50&lon=Inf"},
+		{"flat JSON", "POST", "/ingest/osmand", "application/json", `{"id": "1", "lat": "NaN", "lon": 8}`},
+		{"Traccar JSON", "POST", "/ingest/osmand", "application/json",
+			`{"device_id": "1", "location": {"coords": {"latitude": "nan", "longitude": 8}}}`},
+	} {
+		stored := 0
+		w := serveOsmAnd(c.method, c.url, c.contentType, c.body, func(lu owntracks.LocationUpdate) error {
+			if _, err := Prepare(lu); err != nil {
+				return err
+			}
+			stored++
+			return nil
+		})
+		if w.Code != http.StatusBadRequest || stored != 0 {
+			t.Errorf("%s: %d, %d positions stored", c.name, w.Code, stored)
+		}
+	}
+}
+
+// serveOsmAnd sends a request to an OsmAnd protocol with the device 1 of
+// alice/phone, passing the positions to sink.
+func serveOsmAnd(method, url, contentType, body string, sink Sink) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	o := &OsmAnd{Devices: map[string]string{"1": "alice/phone"}}
+	o.RegisterRoutes(middleware.NewRouter(mux), sink)
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,186 @@
+package ingest
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"owntracks"
+)
+
+func init() {
+	Register("garmin-kml", func(s Settings) (Protocol, error) {
+		g := &GarminKML{Interval: "5m", Logger: s.Logger}
+		if err := decodeOptions(s, g); err != nil {
+			return nil, err
+		}
+		if g.URL == "" || g.User == "" || g.TrackerID == "" {
+			return nil, errors.New("URL, User and TrackerID are required")
+		}
+		d, err := time.ParseDuration(g.Interval)
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid Interval %q, must be at least 1m", g.Interval)
+		}
+		g.interval = d
+		return g, nil
+	})
+}
+
+// GarminKML polls the KML feed of a Garmin MapShare page, so that the tracks
+// of an inReach satellite messenger end up in the history of a device.
+type GarminKML struct {
+	// URL is the address of the feed, like
+	// https://share.garmin.com/Feed/Share/<MapShare name>.
+	URL string
+	// Password is the MapShare password, if the page is protected.
+	Password string
+	// Interval is the time between two polls, like "5m".
+	Interval string
+	// User and TrackerID name the device the positions are stored for.
+	User      string
+	TrackerID string
+
+	Logger   *log.Logger `json:"-"`
+	interval time.Duration
+	last     time.Time
+}
+
+func (g *GarminKML) Name() string {
+	return "garmin-kml"
+}
+
+// Listen polls the feed until done is closed.
+func (g *GarminKML) Listen(sink Sink, done <-chan struct{}) error {
+	go func() {
+		t := time.NewTicker(g.interval)
+		defer t.Stop()
+		for {
+			if err := g.poll(sink); err != nil {
+				g.Logger.Printf("Polling Garmin feed of %s/%s failed: %v", g.User, g.TrackerID, err)
+			}
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// poll fetches the positions since the last poll and passes them to sink.
+func (g *GarminKML) poll(sink Sink) error {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return err
+	}
+	if !g.last.IsZero() {
+		q := u.Query()
+		q.Set("d1", g.last.UTC().Format("2006-01-02T15:04Z"))
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if g.Password != "" {
+		req.SetBasicAuth("", g.Password)
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u.Host, resp.Status)
+	}
+	lus, err := parseGarminKML(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+	for _, lu := range lus {
+		if !lu.T.After(g.last) {
+			continue
+		}
+		lu.User, lu.TrackerID, lu.ClientID = g.User, g.TrackerID, "inreach"
+		if err := sink(lu); err != nil {
+			g.Logger.Printf("Rejected Garmin position of %s/%s: %v", g.User, g.TrackerID, err)
+		}
+		g.last = lu.T
+	}
+	return nil
+}
+
+type kmlPlacemark struct {
+	When        time.Time `xml:"TimeStamp>when"`
+	Coordinates string    `xml:"Point>coordinates"`
+	Data        []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value"`
+	} `xml:"ExtendedData>Data"`
+}
+
+// parseGarminKML returns the timestamped points of a MapShare KML feed,
+// oldest first.
+func parseGarminKML(r io.Reader) ([]owntracks.LocationUpdate, error) {
+	var lus []owntracks.LocationUpdate
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "Placemark" {
+			continue
+		}
+		var pm kmlPlacemark
+		if err := dec.DecodeElement(&pm, &se); err != nil {
+			return nil, err
+		}
+		// the track line has no time stamp
+		c := strings.Split(strings.TrimSpace(pm.Coordinates), ",")
+		if pm.When.IsZero() || len(c) < 2 {
+			continue
+		}
+		lu := owntracks.LocationUpdate{T: pm.When, Trigger: owntracks.TimerBasedUpdate}
+		if lu.Longitude, err = strconv.ParseFloat(c[0], 64); err != nil {
+			return nil, err
+		}
+		if lu.Latitude, err = strconv.ParseFloat(c[1], 64); err != nil {
+			return nil, err
+		}
+		for _, d := range pm.Data {
+			// values carry units, like "12.0 km/h" or "45.00 ° True"
+			f := strings.Fields(d.Value)
+			if len(f) == 0 {
+				continue
+			}
+			v, err := strconv.ParseFloat(f[0], 64)
+			if err != nil {
+				continue
+			}
+			switch d.Name {
+			case "Velocity":
+				lu.Velocity = int(v + 0.5)
+			case "Course":
+				lu.Course = int(v + 0.5)
+			case "Elevation":
+				lu.Altitude = int(v + 0.5)
+			}
+		}
+		lus = append(lus, lu)
+	}
+	return lus, nil
+}
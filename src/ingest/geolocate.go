@@ -0,0 +1,164 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"middleware"
+	"owntracks"
+)
+
+func init() {
+	Register("wifi-cell", func(s Settings) (Protocol, error) {
+		g := &Geolocate{URL: "https://api.beacondb.net/v1/geolocate", Logger: s.Logger}
+		if err := decodeOptions(s, g); err != nil {
+			return nil, err
+		}
+		if _, err := url.Parse(g.URL); err != nil {
+			return nil, fmt.Errorf("invalid URL: %v", err)
+		}
+		for token, device := range g.Tokens {
+			if user, tracker, ok := strings.Cut(device, "/"); !ok || user == "" || tracker == "" {
+				return nil, fmt.Errorf("device %q of token %q is not <user>/<tracker>", device, token)
+			}
+		}
+		return g, nil
+	})
+}
+
+// minGeolocateAccuracy is the accuracy in [m] that positions resolved from
+// WiFi and cell observations get at least, so that they are never mistaken
+// for GPS fixes.
+const minGeolocateAccuracy = 100
+
+// Geolocate resolves WiFi access points and cell towers seen by a tracker to
+// an approximate position with a geolocation service speaking the Mozilla
+// Location Service API, like beaconDB. This fills the gaps indoors, where
+// there is no GPS fix.
+//
+// Trackers POST the body of a geolocate request to /ingest/geolocate, with
+// their token in the Authorization header as "Bearer <token>". The optional
+// field "timestamp" gives the time of the observation in seconds since the
+// Unix epoch, it defaults to the time of the request.
+type Geolocate struct {
+	// URL is the geolocate endpoint of the service.
+	URL string
+	// Key is the API key of the service, if it needs one.
+	Key string
+	// Tokens maps the token of every tracker to its device, as
+	// "<user>/<tracker>".
+	Tokens map[string]string
+
+	Logger *log.Logger `json:"-"`
+}
+
+func (g *Geolocate) Name() string {
+	return "wifi-cell"
+}
+
+// RegisterRoutes registers /ingest/geolocate with r.
+func (g *Geolocate) RegisterRoutes(r *middleware.Router, sink Sink) {
+	r.HandleFunc("/ingest/geolocate", func(w http.ResponseWriter, req *http.Request) {
+		g.serve(w, req, sink)
+	})
+}
+
+func (g *Geolocate) serve(w http.ResponseWriter, r *http.Request, sink Sink) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	device, ok := g.Tokens[token]
+	if !ok {
+		http.Error(w, "Unknown token", http.StatusUnauthorized)
+		return
+	}
+	if replayIdempotent(w, r, device) {
+		return
+	}
+	var obs map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&obs); err != nil {
+		http.Error(w, "Bad observation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	lu := owntracks.LocationUpdate{T: time.Now(), Trigger: owntracks.AutoLocationUpdate, ClientID: "geolocate"}
+	lu.User, lu.TrackerID, _ = strings.Cut(device, "/")
+	if ts, ok := obs["timestamp"]; ok {
+		var sec int64
+		if err := json.Unmarshal(ts, &sec); err != nil {
+			http.Error(w, "Bad timestamp", http.StatusBadRequest)
+			return
+		}
+		lu.T = time.Unix(sec, 0)
+		delete(obs, "timestamp")
+	}
+	lat, lon, acc, err := g.lookup(obs)
+	if err == errNoPosition {
+		http.Error(w, "No position found for the observation", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		g.Logger.Printf("Geolocation for %s failed: %v", device, err)
+		http.Error(w, "Geolocation failed", http.StatusBadGateway)
+		return
+	}
+	lu.Latitude, lu.Longitude = lat, lon
+	lu.Accuracy = int(acc + 0.5)
+	if lu.Accuracy < minGeolocateAccuracy {
+		lu.Accuracy = minGeolocateAccuracy
+	}
+	if err := sink(lu); err != nil {
+		http.Error(w, "Rejected position: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errNoPosition is returned by lookup if the service does not know any of
+// the observed transmitters.
+var errNoPosition = errors.New("no position found")
+
+// lookup sends the observation obs to the geolocation service.
+func (g *Geolocate) lookup(obs map[string]json.RawMessage) (lat, lon, acc float64, err error) {
+	body, err := json.Marshal(obs)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	u := g.URL
+	if g.Key != "" {
+		u += "?key=" + url.QueryEscape(g.Key)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, 0, 0, errNoPosition
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, 0, fmt.Errorf("%s: %s", resp.Request.URL.Host, resp.Status)
+	}
+	var res struct {
+		Location struct {
+			Lat float64 `json:"lat"`
+			Lng float64 `json:"lng"`
+		} `json:"location"`
+		Accuracy float64 `json:"accuracy"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&res); err != nil {
+		return 0, 0, 0, err
+	}
+	return res.Location.Lat, res.Location.Lng, res.Accuracy, nil
+}
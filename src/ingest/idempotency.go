@@ -0,0 +1,174 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyStats counts the retries answered from memory and those
+// rejected while the original request was still running.
+var idempotencyStats = expvar.NewMap("ingest_idempotency")
+
+// maxIdempotencyKeys is the number of keys an Idempotency remembers at most.
+const maxIdempotencyKeys = 100000
+
+// Idempotency lets trackers on flaky networks retry HTTP ingest requests
+// without storing positions twice. A request carrying the header
+// Idempotency-Key, or the parameter idempotency_key in the query string, is
+// only handled once per key and device. Retries within the window get the
+// original response, marked with the header Idempotent-Replayed.
+//
+// Only the protocol knows the device of a request, so the protocols claim
+// the key with replayIdempotent once they have found the device. Requests
+// rejected before are not recorded.
+type Idempotency struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+	// queue holds the keys in the order they were first seen, so the
+	// expired ones are found at its front.
+	queue []string
+}
+
+// idempotentResponse is the response recorded for a key. done is closed
+// once the response is complete.
+type idempotentResponse struct {
+	seen   time.Time
+	done   chan struct{}
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyContextKey is the context key of the idempotentRequest of a
+// request.
+type idempotencyContextKey struct{}
+
+// idempotentRequest is a request carrying an Idempotency-Key.
+type idempotentRequest struct {
+	i   *Idempotency
+	key string
+	// entry is the response recorded for the request, stored under
+	// deviceKey, once the request claimed its key.
+	entry     *idempotentResponse
+	deviceKey string
+}
+
+// NewIdempotency returns an Idempotency remembering keys for window.
+func NewIdempotency(window time.Duration) *Idempotency {
+	return &Idempotency{window: window, entries: make(map[string]*idempotentResponse)}
+}
+
+// Middleware wraps the handlers of the ingest protocols. Requests without
+// key are passed on unchanged.
+func (i *Idempotency) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			key = r.URL.Query().Get("idempotency_key")
+		}
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		req := &idempotentRequest{i: i, key: key}
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), idempotencyContextKey{}, req)))
+		e := req.entry
+		if e == nil {
+			return
+		}
+		e.status, e.header, e.body = rec.status, w.Header().Clone(), rec.body.Bytes()
+		close(e.done)
+		if e.status >= 500 {
+			// failures of the server are worth retrying
+			i.mu.Lock()
+			if i.entries[req.deviceKey] == e {
+				delete(i.entries, req.deviceKey)
+			}
+			i.mu.Unlock()
+		}
+	})
+}
+
+// replayIdempotent claims the Idempotency-Key of r, if it has one, for
+// device. It reports whether r is a retry, which has been answered with the
+// original response, or with 409 Conflict while that is still in progress.
+// The protocols call it once they know the device of r, before storing
+// anything.
+func replayIdempotent(w http.ResponseWriter, r *http.Request, device string) bool {
+	req, ok := r.Context().Value(idempotencyContextKey{}).(*idempotentRequest)
+	if !ok || req.entry != nil {
+		return false
+	}
+	i := req.i
+	key := r.URL.Path + "\x00" + device + "\x00" + req.key
+	now := time.Now()
+	i.mu.Lock()
+	i.expire(now)
+	e, ok := i.entries[key]
+	if !ok {
+		e = &idempotentResponse{seen: now, done: make(chan struct{})}
+		i.entries[key] = e
+		i.queue = append(i.queue, key)
+	}
+	i.mu.Unlock()
+	if !ok {
+		req.entry, req.deviceKey = e, key
+		return false
+	}
+
+	select {
+	case <-e.done:
+	default:
+		idempotencyStats.Add("conflict", 1)
+		http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+		return true
+	}
+	idempotencyStats.Add("replayed", 1)
+	for k, vs := range e.header {
+		w.Header()[k] = vs
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+	return true
+}
+
+// expire forgets the keys older than the window, and the oldest ones if
+// there are too many. i.mu must be held.
+func (i *Idempotency) expire(now time.Time) {
+	n := 0
+	for ; n < len(i.queue); n++ {
+		e, ok := i.entries[i.queue[n]]
+		if ok && now.Sub(e.seen) < i.window && len(i.queue)-n < maxIdempotencyKeys {
+			break
+		}
+		if ok {
+			delete(i.entries, i.queue[n])
+		}
+	}
+	i.queue = i.queue[n:]
+}
+
+// recordingWriter keeps a copy of the response written through it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
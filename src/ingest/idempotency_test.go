@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"middleware"
+	"owntracks"
+)
+
+func TestIdempotencyPerDevice(t *testing.T) {
+	var stored []string
+	sink := func(lu owntracks.LocationUpdate) error {
+		stored = append(stored, lu.User+"/"+lu.TrackerID)
+		return nil
+	}
+	mux := http.NewServeMux()
+	r := middleware.NewRouter(mux, NewIdempotency(time.Minute).Middleware)
+	o := &OsmAnd{Devices: map[string]string{"1": "alice/phone", "2": "bob/phone"}}
+	o.RegisterRoutes(r, sink)
+
+	post := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ingest/osmand", strings.NewReader("id="+id+"&lat=50&lon=8"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Idempotency-Key", "1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	for _, id := range []string{"1", "2", "1"} {
+		if w := post(id); w.Code != http.StatusOK {
+			t.Fatalf("device %s: %d %s", id, w.Code, w.Body)
+		}
+	}
+	if w := post("3"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown device: %d", w.Code)
+	}
+	if strings.Join(stored, " ") != "alice/phone bob/phone" {
+		t.Errorf("stored %v, want the position of each device once", stored)
+	}
+	if w := post("2"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry not replayed: %v", w.Header())
+	}
+}
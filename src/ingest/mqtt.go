@@ -0,0 +1,86 @@
+// Package ingest receives location updates from the trackers and hands them
+// over to the storage. Every supported tracker protocol is registered as a
+// Protocol and can be enabled in the config.
+package ingest
+
+import (
+	"log"
+	"time"
+
+	"geo"
+	"owntracks"
+	"storage"
+)
+
+func init() {
+	Register("owntracks-mqtt", func(s Settings) (Protocol, error) {
+		m := &OwnTracksMQTT{Logger: s.Logger}
+		if err := decodeOptions(s, &m.Listener); err != nil {
+			return nil, err
+		}
+		return m, nil
+	})
+}
+
+// Accept validates lu, computes its geohash and adds it to store.
+func Accept(store storage.Store, lu owntracks.LocationUpdate) error {
+	lu, err := Prepare(lu)
+	if err != nil {
+		return err
+	}
+	return store.InsertPosition(lu)
+}
+
+// Prepare validates lu and computes its geohash, so that it can be stored
+// later.
+func Prepare(lu owntracks.LocationUpdate) (owntracks.LocationUpdate, error) {
+	if err := Validate(lu, time.Now()); err != nil {
+		return lu, err
+	}
+	lu.Geohash = geo.Geohash(lu.Latitude, lu.Longitude, geo.GeohashPrecision)
+	return lu, nil
+}
+
+// OwnTracksMQTT receives location updates from an OwnTracks MQTT broker. Its
+// options are the fields of owntracks.Listener.
+type OwnTracksMQTT struct {
+	Listener owntracks.Listener
+	Logger   *log.Logger
+}
+
+func (m *OwnTracksMQTT) Name() string {
+	return "owntracks-mqtt"
+}
+
+// Listen connects to the broker and passes all received location updates to
+// sink until done is closed. It returns once the connection is established.
+func (m *OwnTracksMQTT) Listen(sink Sink, done <-chan struct{}) error {
+	msgs, err := m.Listener.Connect()
+	if err != nil {
+		return err
+	}
+	parser := owntracks.RunMessageParser(msgs, done)
+	m.Logger.Printf("Connected to MQTT server at %s", m.Listener.BrokerAddress())
+	go func() {
+	loop:
+		for {
+			select {
+			case <-done:
+				break loop
+			case l, ok := <-parser.L:
+				if !ok {
+					break loop
+				}
+				if err := sink(l); err != nil {
+					m.Logger.Printf("Rejected location update from %s: %v", storage.DeviceName(l), err)
+				}
+			case msg := <-parser.O:
+				m.Logger.Printf("Received other message: %s %s", msg.Topic, msg.Payload)
+			}
+		}
+		if err := m.Listener.Disconnect(); err != nil {
+			m.Logger.Printf("Error during owntracks.Listener.Disconnect: %v", err)
+		}
+	}()
+	return nil
+}
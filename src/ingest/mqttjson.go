@@ -0,0 +1,293 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"owntracks"
+)
+
+func init() {
+	Register("mqtt-json", func(s Settings) (Protocol, error) {
+		m := &MQTTJSON{Logger: s.Logger}
+		if err := decodeOptions(s, m); err != nil {
+			return nil, err
+		}
+		if m.Broker.Hostname == "" || len(m.Topics) == 0 {
+			return nil, errors.New("Broker.Hostname and Topics are required")
+		}
+		// a second connection with the client ID of owntracks-mqtt would
+		// throw the first one off the broker
+		if m.Broker.ClientID == "" {
+			m.Broker.ClientID = owntracks.DefaultClientId + "-json"
+		}
+		m.Broker.Topics = nil
+		for i, t := range m.Topics {
+			if t.Topic == "" || t.User == "" || t.Device == "" || t.Lat == "" || t.Lon == "" {
+				return nil, fmt.Errorf("Topics[%d]: Topic, User, Device, Lat and Lon are required", i)
+			}
+			m.Broker.Topics = append(m.Broker.Topics, t.Topic)
+		}
+		return m, nil
+	})
+}
+
+// MQTTJSON receives positions that IoT firmware like Tasmota or ESPHome
+// publishes as JSON on arbitrary MQTT topics.
+//
+// The fields of a JSONTopic locate the values in the payload with paths like
+// "$.GPS.lat" or "$.fix[0].time", where the leading "$." is optional. The
+// Device field may also be "topic[n]", the n-th level of the topic, counted
+// from zero.
+type MQTTJSON struct {
+	// Broker holds the connection settings, its Topics are ignored.
+	Broker owntracks.Listener
+	Topics []JSONTopic
+
+	Logger *log.Logger `json:"-"`
+}
+
+// JSONTopic maps the messages of an MQTT topic filter to positions. Only
+// Time and the fields below it are optional. Without Time, the time of
+// reception is used. The values below Time are left at zero if they are
+// missing in a message.
+type JSONTopic struct {
+	// Topic is the topic filter, it may contain the wildcards + and #.
+	Topic string
+	// User is the user the positions are stored for.
+	User string
+	// Device is the path of the tracker ID.
+	Device string
+	Lat    string
+	Lon    string
+	// Time is the path of the time stamp, either seconds or milliseconds
+	// since the Unix epoch or a string in RFC 3339 format. Strings without
+	// time zone, as sent by Tasmota, are taken as local time.
+	Time string
+
+	Accuracy string
+	Altitude string
+	Velocity string
+	Course   string
+	Battery  string
+}
+
+func (m *MQTTJSON) Name() string {
+	return "mqtt-json"
+}
+
+// Listen connects to the broker and passes the positions of all messages on
+// the configured topics to sink until done is closed. It returns once the
+// connection is established.
+func (m *MQTTJSON) Listen(sink Sink, done <-chan struct{}) error {
+	msgs, err := m.Broker.Connect()
+	if err != nil {
+		return err
+	}
+	m.Logger.Printf("Connected to MQTT server at %s for JSON topics", m.Broker.BrokerAddress())
+	go func() {
+	loop:
+		for {
+			select {
+			case <-done:
+				break loop
+			case msg, ok := <-msgs:
+				if !ok {
+					break loop
+				}
+				for _, t := range m.Topics {
+					if !topicMatches(t.Topic, msg.Topic) {
+						continue
+					}
+					lu, err := t.parse(msg, time.Now())
+					if err == nil {
+						err = sink(lu)
+					}
+					if err != nil {
+						m.Logger.Printf("Rejected message on %s: %v", msg.Topic, err)
+					}
+					break
+				}
+			}
+		}
+		if err := m.Broker.Disconnect(); err != nil {
+			m.Logger.Printf("Error during owntracks.Listener.Disconnect: %v", err)
+		}
+	}()
+	return nil
+}
+
+// parse maps msg to a position. now is used if t has no Time.
+func (t JSONTopic) parse(msg owntracks.Message, now time.Time) (owntracks.LocationUpdate, error) {
+	lu := owntracks.LocationUpdate{
+		T:        now,
+		Trigger:  owntracks.UnknownTrigger,
+		User:     t.User,
+		ClientID: "mqtt-json",
+	}
+	var doc interface{}
+	if err := json.Unmarshal(msg.Payload, &doc); err != nil {
+		return lu, err
+	}
+	var err error
+	if lu.TrackerID, err = t.device(doc, msg.Topic); err != nil {
+		return lu, err
+	}
+	if lu.Latitude, err = jsonNumber(doc, t.Lat); err != nil {
+		return lu, err
+	}
+	if lu.Longitude, err = jsonNumber(doc, t.Lon); err != nil {
+		return lu, err
+	}
+	if t.Time != "" {
+		if lu.T, err = jsonTime(doc, t.Time); err != nil {
+			return lu, err
+		}
+	}
+	for _, f := range []struct {
+		path string
+		v    *int
+	}{
+		{t.Accuracy, &lu.Accuracy},
+		{t.Altitude, &lu.Altitude},
+		{t.Velocity, &lu.Velocity},
+		{t.Course, &lu.Course},
+		{t.Battery, &lu.Battery},
+	} {
+		// firmware often leaves out values it does not know yet
+		if _, err := jsonPath(doc, f.path); f.path == "" || err != nil {
+			continue
+		}
+		v, err := jsonNumber(doc, f.path)
+		if err != nil {
+			return lu, err
+		}
+		*f.v = int(v + 0.5)
+	}
+	return lu, nil
+}
+
+// device returns the tracker ID from the payload doc or the topic.
+func (t JSONTopic) device(doc interface{}, topic string) (string, error) {
+	if s, ok := strings.CutPrefix(t.Device, "topic["); ok {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "]"))
+		levels := strings.Split(topic, "/")
+		if err != nil || n < 0 || n >= len(levels) {
+			return "", fmt.Errorf("topic has no level %s", t.Device)
+		}
+		return levels[n], nil
+	}
+	v, err := jsonPath(doc, t.Device)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("%s is no string", t.Device)
+}
+
+// jsonPath returns the value at path in doc, which is the result of
+// json.Unmarshal into an interface{}.
+func jsonPath(doc interface{}, path string) (interface{}, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	v := doc
+	for p != "" {
+		var key string
+		if strings.HasPrefix(p, "[") {
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %s", path)
+			}
+			i, err := strconv.Atoi(p[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %s", path)
+			}
+			a, ok := v.([]interface{})
+			if !ok || i < 0 || i >= len(a) {
+				return nil, fmt.Errorf("%s not found", path)
+			}
+			v, p = a[i], strings.TrimPrefix(p[end+1:], ".")
+			continue
+		}
+		end := strings.IndexAny(p, ".[")
+		if end < 0 {
+			key, p = p, ""
+		} else {
+			key, p = p[:end], strings.TrimPrefix(p[end:], ".")
+		}
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+		if v, ok = o[key]; !ok {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+	}
+	return v, nil
+}
+
+// jsonNumber returns the number at path in doc. Numbers in strings are
+// accepted, because some firmware sends them that way.
+func jsonNumber(doc interface{}, path string) (float64, error) {
+	v, err := jsonPath(doc, path)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("%s is no number", path)
+}
+
+// jsonTime returns the time stamp at path in doc.
+func jsonTime(doc interface{}, path string) (time.Time, error) {
+	v, err := jsonPath(doc, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch v := v.(type) {
+	case float64:
+		// seconds would not reach 1e11 before the year 5000
+		if v >= 1e11 {
+			return time.UnixMilli(int64(v)), nil
+		}
+		return time.Unix(int64(v), 0), nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		if t, err := time.ParseInLocation("2006-01-02T15:04:05", v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s is no time stamp", path)
+}
+
+// topicMatches reports whether topic matches the MQTT topic filter.
+func topicMatches(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
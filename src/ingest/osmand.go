@@ -0,0 +1,143 @@
+package ingest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"middleware"
+	"owntracks"
+)
+
+func init() {
+	Register("osmand", func(s Settings) (Protocol, error) {
+		o := &OsmAnd{}
+		if err := decodeOptions(s, o); err != nil {
+			return nil, err
+		}
+		for id, device := range o.Devices {
+			if user, tracker, ok := strings.Cut(device, "/"); !ok || user == "" || tracker == "" {
+				return nil, fmt.Errorf("device %q of id %q is not <user>/<tracker>", device, id)
+			}
+		}
+		return o, nil
+	})
+}
+
+// knotsToKmh converts the speeds of the OsmAnd protocol to [km/h].
+const knotsToKmh = 1.852
+
+// OsmAnd receives positions in the OsmAnd protocol of Traccar, which the
+// OsmAnd app, the Traccar Client apps and many hardware trackers speak.
+//
+// Trackers send their positions to /ingest/osmand, either as parameters of
+// the query string, as urlencoded or multipart form body, or as JSON body.
+// The JSON body of the newer Traccar Client apps, with the fields nested
+// as "location.coords.latitude", is understood as well.
+type OsmAnd struct {
+	// Devices maps the id every tracker sends to its device, as
+	// "<user>/<tracker>".
+	Devices map[string]string
+}
+
+func (o *OsmAnd) Name() string {
+	return "osmand"
+}
+
+// RegisterRoutes registers /ingest/osmand with r.
+func (o *OsmAnd) RegisterRoutes(r *middleware.Router, sink Sink) {
+	r.HandleFunc("/ingest/osmand", func(w http.ResponseWriter, req *http.Request) {
+		o.serve(w, req, sink)
+	})
+}
+
+func (o *OsmAnd) serve(w http.ResponseWriter, r *http.Request, sink Sink) {
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	v, err := requestValues(w, r)
+	if err != nil {
+		http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, id := firstValue(v, "id", "deviceid", "device_id")
+	device, ok := o.Devices[id]
+	if !ok {
+		http.Error(w, "Unknown device", http.StatusUnauthorized)
+		return
+	}
+	if replayIdempotent(w, r, device) {
+		return
+	}
+	lu, err := osmandLocation(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lu.User, lu.TrackerID, _ = strings.Cut(device, "/")
+	if err := sink(lu); err != nil {
+		http.Error(w, "Rejected position: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// osmandLocation converts the parameters v of an OsmAnd request to a
+// position without user and tracker.
+func osmandLocation(v url.Values) (owntracks.LocationUpdate, error) {
+	lu := owntracks.LocationUpdate{T: time.Now(), Trigger: owntracks.AutoLocationUpdate, ClientID: "osmand"}
+	_, lat := firstValue(v, "lat", "latitude", "location.coords.latitude")
+	_, lon := firstValue(v, "lon", "lng", "longitude", "location.coords.longitude")
+	if loc := v.Get("location"); lat == "" && loc != "" {
+		// location=<lat>,<lon>
+		lat, lon, _ = strings.Cut(loc, ",")
+	}
+	var err1, err2 error
+	lu.Latitude, err1 = strconv.ParseFloat(lat, 64)
+	lu.Longitude, err2 = strconv.ParseFloat(lon, 64)
+	if err1 != nil || err2 != nil {
+		return lu, fmt.Errorf("invalid or missing coordinates %q, %q", lat, lon)
+	}
+	if _, ts := firstValue(v, "timestamp", "location.timestamp"); ts != "" {
+		t, err := parseTimestamp(ts)
+		if err != nil {
+			return lu, err
+		}
+		lu.T = t
+	}
+	number := func(keys ...string) (string, float64, bool) {
+		k, s := firstValue(v, keys...)
+		f, err := strconv.ParseFloat(s, 64)
+		return k, f, err == nil
+	}
+	if _, f, ok := number("accuracy", "location.coords.accuracy"); ok {
+		lu.Accuracy = int(f + 0.5)
+	}
+	if _, f, ok := number("altitude", "location.coords.altitude"); ok {
+		lu.Altitude = int(f + 0.5)
+	}
+	if _, f, ok := number("bearing", "heading", "location.coords.heading"); ok && f >= 0 {
+		lu.Course = int(f + 0.5)
+	}
+	if k, f, ok := number("speed", "location.coords.speed"); ok && f >= 0 {
+		if k == "speed" {
+			lu.Velocity = int(f*knotsToKmh + 0.5)
+		} else {
+			// the JSON body gives [m/s]
+			lu.Velocity = int(f*3.6 + 0.5)
+		}
+	}
+	if k, f, ok := number("batt", "battery", "location.battery.level"); ok && f >= 0 {
+		if k == "location.battery.level" {
+			// a fraction between 0 and 1
+			f *= 100
+		}
+		lu.Battery = int(f + 0.5)
+	}
+	return lu, nil
+}
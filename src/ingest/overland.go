@@ -0,0 +1,150 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"middleware"
+	"owntracks"
+)
+
+func init() {
+	Register("overland", func(s Settings) (Protocol, error) {
+		o := &Overland{Logger: s.Logger}
+		if err := decodeOptions(s, o); err != nil {
+			return nil, err
+		}
+		for token, device := range o.Tokens {
+			if user, tracker, ok := strings.Cut(device, "/"); !ok || user == "" || tracker == "" {
+				return nil, fmt.Errorf("device %q of token %q is not <user>/<tracker>", device, token)
+			}
+		}
+		return o, nil
+	})
+}
+
+// maxOverlandBody is the largest batch read from Overland. The app sends up
+// to 1000 positions per request, each about 600 bytes.
+const maxOverlandBody = 8 << 20
+
+// Overland receives the batches of positions of the Overland app for iOS.
+//
+// The app POSTs GeoJSON to /ingest/overland, with its access token in the
+// Authorization header as "Bearer <token>" or in the parameter token of the
+// receiver URL. Every point feature of the member "locations" becomes a
+// position, with the motion, like "driving", as description. The app
+// resends a batch until it is answered with {"result":"ok"}, so positions
+// that are rejected are only logged, not to block the positions queued
+// behind them. Positions that cannot be stored are lost unless a spool is
+// configured.
+type Overland struct {
+	// Tokens maps the access token of every app to its device, as
+	// "<user>/<tracker>".
+	Tokens map[string]string
+
+	Logger *log.Logger `json:"-"`
+}
+
+// overlandBatch is the body of a request of Overland.
+type overlandBatch struct {
+	Locations []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string    `json:"type"`
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			Timestamp time.Time `json:"timestamp"`
+			Altitude  float64   `json:"altitude"`
+			// Speed in [m/s], Course and HorizontalAccuracy are -1 if
+			// unknown.
+			Speed              float64  `json:"speed"`
+			Course             *float64 `json:"course"`
+			HorizontalAccuracy float64  `json:"horizontal_accuracy"`
+			Motion             []string `json:"motion"`
+			// BatteryLevel is a fraction between 0 and 1.
+			BatteryLevel *float64 `json:"battery_level"`
+		} `json:"properties"`
+	} `json:"locations"`
+}
+
+func (o *Overland) Name() string {
+	return "overland"
+}
+
+// RegisterRoutes registers /ingest/overland with r.
+func (o *Overland) RegisterRoutes(r *middleware.Router, sink Sink) {
+	r.HandleFunc("/ingest/overland", func(w http.ResponseWriter, req *http.Request) {
+		o.serve(w, req, sink)
+	})
+}
+
+func (o *Overland) serve(w http.ResponseWriter, r *http.Request, sink Sink) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	device, ok := o.Tokens[token]
+	if !ok {
+		http.Error(w, "Unknown token", http.StatusUnauthorized)
+		return
+	}
+	if replayIdempotent(w, r, device) {
+		return
+	}
+	var b overlandBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOverlandBody)).Decode(&b); err != nil {
+		http.Error(w, "Bad batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, tracker, _ := strings.Cut(device, "/")
+	rejected := 0
+	var lastErr error
+	for _, f := range b.Locations {
+		if f.Type != "Feature" || f.Geometry.Type != "Point" || len(f.Geometry.Coordinates) < 2 {
+			continue
+		}
+		p := f.Properties
+		lu := owntracks.LocationUpdate{
+			T:           p.Timestamp,
+			Trigger:     owntracks.AutoLocationUpdate,
+			User:        user,
+			ClientID:    "overland",
+			TrackerID:   tracker,
+			Latitude:    f.Geometry.Coordinates[1],
+			Longitude:   f.Geometry.Coordinates[0],
+			Altitude:    int(p.Altitude + 0.5),
+			Description: strings.Join(p.Motion, ","),
+		}
+		if p.HorizontalAccuracy > 0 {
+			lu.Accuracy = int(p.HorizontalAccuracy + 0.5)
+		}
+		if p.Speed > 0 {
+			lu.Velocity = int(p.Speed*3.6 + 0.5)
+		}
+		if p.Course != nil && *p.Course >= 0 {
+			lu.Course = int(*p.Course + 0.5)
+		}
+		if p.BatteryLevel != nil && *p.BatteryLevel >= 0 {
+			lu.Battery = int(*p.BatteryLevel*100 + 0.5)
+		}
+		if err := sink(lu); err != nil {
+			rejected++
+			lastErr = err
+		}
+	}
+	if rejected > 0 {
+		o.Logger.Printf("Rejected %d of %d Overland positions of %s, the last because: %v", rejected, len(b.Locations), device, lastErr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": "ok", "rejected": rejected})
+}
@@ -0,0 +1,196 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"middleware"
+	"owntracks"
+)
+
+func init() {
+	Register("phonetrack", func(s Settings) (Protocol, error) {
+		p := &PhoneTrack{}
+		if err := decodeOptions(s, p); err != nil {
+			return nil, err
+		}
+		for token, user := range p.Sessions {
+			if user == "" || strings.Contains(user, "/") {
+				return nil, fmt.Errorf("invalid user %q of session %q", user, token)
+			}
+		}
+		return p, nil
+	})
+}
+
+// phoneTrackPrefixes are the paths the logging URLs of PhoneTrack start
+// with, with and without the front controller of Nextcloud.
+var phoneTrackPrefixes = []string{"/index.php/apps/phonetrack/", "/apps/phonetrack/"}
+
+// phoneTrackPointFields are the fields of a point sent to logPostMultiple,
+// in their order.
+var phoneTrackPointFields = []string{"lat", "lon", "timestamp", "alt", "acc", "bat", "sat", "useragent", "speed", "bearing"}
+
+// PhoneTrack receives positions in the logging protocol of the PhoneTrack
+// app for Nextcloud, so that the PhoneTrack Android app only needs the URL
+// of daisser instead of the one of Nextcloud.
+//
+// The app sends its positions to
+// /index.php/apps/phonetrack/<method>/<session token>/<device name>, where
+// method is logPost or logGet for single positions with the parameters lat,
+// lon, timestamp, alt, acc, bat, sat, speed and bearing, or logPostMultiple
+// for a JSON body with a list of points, each a list of those values. The
+// device name becomes the tracker of the position.
+type PhoneTrack struct {
+	// Sessions maps the token of every PhoneTrack session to the user
+	// whose devices log into it.
+	Sessions map[string]string
+}
+
+func (p *PhoneTrack) Name() string {
+	return "phonetrack"
+}
+
+// RegisterRoutes registers the logging URLs of PhoneTrack with r.
+func (p *PhoneTrack) RegisterRoutes(r *middleware.Router, sink Sink) {
+	for _, prefix := range phoneTrackPrefixes {
+		r.HandleFunc(prefix, func(w http.ResponseWriter, req *http.Request) {
+			p.serve(w, req, strings.TrimPrefix(req.URL.Path, prefix), sink)
+		})
+	}
+}
+
+// serve handles the request r to the path below the PhoneTrack prefix.
+func (p *PhoneTrack) serve(w http.ResponseWriter, r *http.Request, path string, sink Sink) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[2] == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	method, token, tracker := parts[0], parts[1], parts[2]
+	switch {
+	case method == "logGet" && r.Method == "GET",
+		(method == "logPost" || method == "logPostMultiple") && r.Method == "POST":
+	case method == "logGet":
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	case method == "logPost" || method == "logPostMultiple":
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	user, ok := p.Sessions[token]
+	if !ok {
+		http.Error(w, "Unknown session", http.StatusUnauthorized)
+		return
+	}
+	if replayIdempotent(w, r, user+"/"+tracker) {
+		return
+	}
+	var points []url.Values
+	if method == "logPostMultiple" {
+		var err error
+		if points, err = phoneTrackPoints(w, r); err != nil {
+			http.Error(w, "Bad points: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		v, err := requestValues(w, r)
+		if err != nil {
+			http.Error(w, "Bad request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		points = []url.Values{v}
+	}
+	for _, v := range points {
+		lu, err := phoneTrackLocation(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lu.User, lu.TrackerID = user, tracker
+		if err := sink(lu); err != nil {
+			http.Error(w, "Rejected position: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"done":1}`))
+}
+
+// phoneTrackPoints returns the points of the logPostMultiple request r as
+// parameters of single positions.
+func phoneTrackPoints(w http.ResponseWriter, r *http.Request) ([]url.Values, error) {
+	var body struct {
+		Points [][]interface{} `json:"points"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFormBody)).Decode(&body); err != nil {
+		return nil, err
+	}
+	points := make([]url.Values, len(body.Points))
+	for i, pt := range body.Points {
+		v := make(url.Values)
+		for j, e := range pt {
+			if j >= len(phoneTrackPointFields) {
+				break
+			}
+			switch e := e.(type) {
+			case float64:
+				v.Set(phoneTrackPointFields[j], strconv.FormatFloat(e, 'f', -1, 64))
+			case string:
+				v.Set(phoneTrackPointFields[j], e)
+			}
+		}
+		points[i] = v
+	}
+	return points, nil
+}
+
+// phoneTrackLocation converts the parameters v of a PhoneTrack position to
+// a position without user and tracker. Speeds are given in [m/s].
+func phoneTrackLocation(v url.Values) (owntracks.LocationUpdate, error) {
+	lu := owntracks.LocationUpdate{T: time.Now(), Trigger: owntracks.AutoLocationUpdate, ClientID: "phonetrack"}
+	var err1, err2 error
+	lu.Latitude, err1 = strconv.ParseFloat(v.Get("lat"), 64)
+	lu.Longitude, err2 = strconv.ParseFloat(v.Get("lon"), 64)
+	if err1 != nil || err2 != nil {
+		return lu, fmt.Errorf("invalid or missing coordinates %q, %q", v.Get("lat"), v.Get("lon"))
+	}
+	if ts := v.Get("timestamp"); ts != "" {
+		t, err := parseTimestamp(ts)
+		if err != nil {
+			return lu, err
+		}
+		lu.T = t
+	}
+	// unknown values are sent as empty or negative
+	number := func(key string) (float64, bool) {
+		f, err := strconv.ParseFloat(v.Get(key), 64)
+		return f, err == nil && f >= 0
+	}
+	if f, ok := number("acc"); ok {
+		lu.Accuracy = int(f + 0.5)
+	}
+	if f, err := strconv.ParseFloat(v.Get("alt"), 64); err == nil {
+		lu.Altitude = int(f + 0.5)
+	}
+	if f, ok := number("bearing"); ok {
+		lu.Course = int(f + 0.5)
+	}
+	if f, ok := number("speed"); ok {
+		lu.Velocity = int(f*3.6 + 0.5)
+	}
+	if f, ok := number("bat"); ok {
+		lu.Battery = int(f + 0.5)
+	}
+	return lu, nil
+}
@@ -0,0 +1,224 @@
+package ingest
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"geo"
+	"owntracks"
+	"storage"
+)
+
+// policyStats counts the positions dropped by policies, by reason.
+var policyStats = expvar.NewMap("ingest_policy")
+
+// Policy decides which of the positions of chatty trackers are worth
+// storing. The zero Policy keeps all positions.
+type Policy struct {
+	// MaxAccuracy drops positions with an accuracy worse than that many [m].
+	MaxAccuracy int
+	// MinInterval drops positions that follow the last stored one of the
+	// device within this time, like "30s".
+	MinInterval string
+	// MinDistance drops positions less than that many [m] away from the
+	// last stored one of the device.
+	MinDistance float64
+	// IgnoreStationary drops positions without velocity that lie within
+	// the accuracy of the last stored one of the device.
+	IgnoreStationary bool
+	// MaxClockSkew is how far the timestamp of a position may be off from
+	// the time it is received, like "6h". Beyond that, ClockSkew applies.
+	// It must exceed the time trackers buffer positions while offline.
+	MaxClockSkew string
+	// ClockSkew is what happens to positions with skewed timestamps:
+	// "server" replaces the timestamp by the time of receipt, "learn"
+	// shifts it by the clock offset learned for the device, and "reject"
+	// refuses the position. Corrected positions keep the timestamp of the
+	// device in DeviceT.
+	ClockSkew string
+	// MaxOutOfOrder rejects positions whose timestamp lies further than
+	// this before the newest stored position of the device, like "1h", so
+	// that confused trackers cannot rewrite its history. "0s" only accepts
+	// positions in order, empty accepts them in any order. It must exceed
+	// the time trackers buffer positions while offline. Imports are not
+	// affected, so history can still be backfilled with them.
+	MaxOutOfOrder string
+
+	minInterval   time.Duration
+	maxClockSkew  time.Duration
+	checkOrder    bool
+	maxOutOfOrder time.Duration
+}
+
+// Policies applies a Policy to the positions of every device. The policy of
+// a device is looked up by its name "<user>/<tracker>" first, then by the
+// name of the protocol that received the position. Policies are not merged.
+type Policies struct {
+	policies map[string]Policy
+
+	mu   sync.Mutex
+	last map[string]owntracks.LocationUpdate
+	// offsets holds the clock offsets learned for the devices.
+	offsets map[string]time.Duration
+	// newest holds the time of the newest stored position of the devices.
+	newest map[string]time.Time
+}
+
+// NewPolicies returns Policies for the given policies by device or protocol
+// name.
+func NewPolicies(policies map[string]Policy) (*Policies, error) {
+	p := &Policies{
+		policies: make(map[string]Policy),
+		last:     make(map[string]owntracks.LocationUpdate),
+		offsets:  make(map[string]time.Duration),
+		newest:   make(map[string]time.Time),
+	}
+	for name, pol := range policies {
+		if pol.MinInterval != "" {
+			d, err := time.ParseDuration(pol.MinInterval)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("policy %s: invalid MinInterval %q", name, pol.MinInterval)
+			}
+			pol.minInterval = d
+		}
+		if pol.MaxOutOfOrder != "" {
+			d, err := time.ParseDuration(pol.MaxOutOfOrder)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("policy %s: invalid MaxOutOfOrder %q", name, pol.MaxOutOfOrder)
+			}
+			pol.checkOrder, pol.maxOutOfOrder = true, d
+		}
+		switch pol.ClockSkew {
+		case "":
+		case "server", "learn", "reject":
+			d, err := time.ParseDuration(pol.MaxClockSkew)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("policy %s: invalid MaxClockSkew %q", name, pol.MaxClockSkew)
+			}
+			pol.maxClockSkew = d
+		default:
+			return nil, fmt.Errorf("policy %s: unknown ClockSkew %q", name, pol.ClockSkew)
+		}
+		p.policies[name] = pol
+	}
+	return p, nil
+}
+
+// Seed tells p the newest stored position of every device, for checking
+// MaxOutOfOrder after a restart.
+func (p *Policies) Seed(devices []storage.Device) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range devices {
+		if d.Last.T.After(p.newest[d.Name()]) {
+			p.newest[d.Name()] = d.Last.T
+		}
+	}
+}
+
+// Filter returns a Sink that passes the positions received by protocol to
+// sink unless they are dropped by their policy. Dropped positions are not an
+// error.
+func (p *Policies) Filter(protocol string, sink Sink) Sink {
+	return func(lu owntracks.LocationUpdate) error {
+		name := storage.DeviceName(lu)
+		pol, ok := p.policies[name]
+		if !ok {
+			if pol, ok = p.policies[protocol]; !ok {
+				return sink(lu)
+			}
+		}
+		lu, keep, err := p.check(pol, name, lu)
+		if err != nil || !keep {
+			return err
+		}
+		// the sink is not called under the lock, so that storing one
+		// position does not hold up all others
+		if err := sink(lu); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.last[name] = lu
+		if lu.T.After(p.newest[name]) {
+			p.newest[name] = lu.T
+		}
+		return nil
+	}
+}
+
+// check applies pol to lu, the position of the device name. It returns lu
+// with a corrected clock and whether it is kept, or an error if it is
+// rejected.
+func (p *Policies) check(pol Policy, name string, lu owntracks.LocationUpdate) (owntracks.LocationUpdate, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lu, err := p.correctClock(pol, name, lu, time.Now())
+	if err != nil {
+		return lu, false, err
+	}
+	if newest, ok := p.newest[name]; ok && pol.checkOrder && newest.Sub(lu.T) > pol.maxOutOfOrder {
+		policyStats.Add("order_rejected", 1)
+		return lu, false, fmt.Errorf("timestamp %s is %v before the newest position", lu.T.Format(time.RFC3339), newest.Sub(lu.T).Round(time.Second))
+	}
+	last, seen := p.last[name]
+	if reason := pol.drop(lu, last, seen); reason != "" {
+		policyStats.Add(reason, 1)
+		return lu, false, nil
+	}
+	return lu, true, nil
+}
+
+// correctClock applies the ClockSkew of pol to lu, the position of the
+// device name received at now. p.mu must be held.
+func (p *Policies) correctClock(pol Policy, name string, lu owntracks.LocationUpdate, now time.Time) (owntracks.LocationUpdate, error) {
+	skew := now.Sub(lu.T)
+	if pol.ClockSkew == "" || (skew <= pol.maxClockSkew && skew >= -pol.maxClockSkew) {
+		return lu, nil
+	}
+	switch pol.ClockSkew {
+	case "reject":
+		policyStats.Add("clock_rejected", 1)
+		return lu, fmt.Errorf("timestamp %s is off by %v", lu.T.Format(time.RFC3339), skew.Round(time.Second))
+	case "learn":
+		// the offset drifts slowly and is blurred by transmission delays,
+		// so it is smoothed unless the clock jumped
+		offset, ok := p.offsets[name]
+		if diff := skew - offset; ok && diff <= pol.maxClockSkew && diff >= -pol.maxClockSkew {
+			offset += diff / 4
+		} else {
+			offset = skew
+		}
+		p.offsets[name] = offset
+		lu.DeviceT, lu.T = lu.T, lu.T.Add(offset)
+	default:
+		lu.DeviceT, lu.T = lu.T, now
+	}
+	policyStats.Add("clock_corrected", 1)
+	return lu, nil
+}
+
+// drop returns why lu is dropped, given the last stored position of its
+// device if seen, or "" if it is kept.
+func (pol Policy) drop(lu, last owntracks.LocationUpdate, seen bool) string {
+	if pol.MaxAccuracy > 0 && lu.Accuracy > pol.MaxAccuracy {
+		return "accuracy"
+	}
+	// positions buffered by the tracker may arrive after newer ones
+	if !seen || lu.T.Before(last.T) {
+		return ""
+	}
+	if lu.T.Sub(last.T) < pol.minInterval {
+		return "interval"
+	}
+	d := geo.Distance(last.Latitude, last.Longitude, lu.Latitude, lu.Longitude)
+	if d < pol.MinDistance {
+		return "distance"
+	}
+	if pol.IgnoreStationary && lu.Velocity == 0 && (d <= float64(lu.Accuracy) || d <= float64(last.Accuracy)) {
+		return "stationary"
+	}
+	return ""
+}
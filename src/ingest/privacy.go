@@ -0,0 +1,189 @@
+package ingest
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"time"
+
+	"geo"
+	"owntracks"
+	"storage"
+)
+
+const (
+	// maskScale rounds the coordinates of masked positions outside of
+	// places to 1/maskScale [°], about 1 km.
+	maskScale = 100
+	// maskAccuracy is the accuracy in [m] of masked positions outside of
+	// places.
+	maskAccuracy = 1000
+)
+
+// privacyStats counts the positions discarded or masked by privacy rules.
+var privacyStats = expvar.NewMap("ingest_privacy")
+
+// weekdays are the names of the days of storage.PrivacyRule.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Privacy enforces the privacy rules in the preferences of the users on the
+// positions of their devices.
+type Privacy struct {
+	Preferences *storage.PreferenceStore
+	// Places are the places the rules refer to. Rules with a place never
+	// match without them.
+	Places *storage.PlaceStore
+}
+
+// CheckPrivacyRule returns an error if r is not a valid privacy rule.
+func CheckPrivacyRule(r storage.PrivacyRule) error {
+	if r.Action != storage.Discard && r.Action != storage.Mask {
+		return fmt.Errorf("privacy rule action must be %q or %q", storage.Discard, storage.Mask)
+	}
+	if (r.From == "") != (r.To == "") {
+		return fmt.Errorf("privacy rule needs both from and to")
+	}
+	if r.From != "" {
+		from, err := parseClock(r.From)
+		if err != nil {
+			return err
+		}
+		to, err := parseClock(r.To)
+		if err != nil {
+			return err
+		}
+		if from == to {
+			return fmt.Errorf("privacy rule from and to must differ")
+		}
+	}
+	for _, d := range r.Days {
+		if _, ok := weekdays[d]; !ok {
+			return fmt.Errorf("unknown day %q, must be one of mon, tue, wed, thu, fri, sat, sun", d)
+		}
+	}
+	return nil
+}
+
+// parseClock returns the time of day s, like "22:00", as the duration since
+// midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, must be like 22:00", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Filter returns a Sink that passes the positions to sink unless they are
+// discarded by a privacy rule of their user, masked if a rule says so. If
+// several rules match, discarding wins, otherwise the first masking rule
+// applies. Discarded positions are not an error.
+func (p *Privacy) Filter(sink Sink) Sink {
+	return func(lu owntracks.LocationUpdate) error {
+		prefs := p.Preferences.Get(lu.User)
+		if len(prefs.PrivacyRules) == 0 {
+			return sink(lu)
+		}
+		loc, err := time.LoadLocation(prefs.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		var places []storage.Place
+		if p.Places != nil {
+			places = p.Places.List(lu.User)
+		}
+		masked, inPlace := false, false
+		var maskPlace storage.Place
+		for _, r := range prefs.PrivacyRules {
+			place, ok := matchPrivacyRule(r, lu, loc, places)
+			if !ok {
+				continue
+			}
+			if r.Action == storage.Discard {
+				privacyStats.Add("discarded", 1)
+				return nil
+			}
+			if !masked {
+				masked, inPlace, maskPlace = true, r.Place != "", place
+			}
+		}
+		if masked {
+			privacyStats.Add("masked", 1)
+			lu = maskPosition(lu, inPlace, maskPlace)
+		}
+		return sink(lu)
+	}
+}
+
+// matchPrivacyRule reports whether r matches lu, with times in loc and the
+// places of the user. If r has a place, it is returned.
+func matchPrivacyRule(r storage.PrivacyRule, lu owntracks.LocationUpdate, loc *time.Location, places []storage.Place) (storage.Place, bool) {
+	if r.From != "" && !inSpan(r, lu.T.In(loc)) {
+		return storage.Place{}, false
+	}
+	if r.From == "" && len(r.Days) > 0 && !onDay(r.Days, lu.T.In(loc).Weekday()) {
+		return storage.Place{}, false
+	}
+	if r.Place == "" {
+		return storage.Place{}, true
+	}
+	for _, pl := range places {
+		if pl.Name == r.Place && geo.Distance(pl.Latitude, pl.Longitude, lu.Latitude, lu.Longitude) <= pl.Radius {
+			return pl, true
+		}
+	}
+	return storage.Place{}, false
+}
+
+// inSpan reports whether t lies in the daily span of r, on one of its days.
+// Rules are checked when they are stored, so their times are valid.
+func inSpan(r storage.PrivacyRule, t time.Time) bool {
+	from, _ := parseClock(r.From)
+	to, _ := parseClock(r.To)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	switch {
+	case from < to && clock >= from && clock < to:
+	case from > to && clock >= from:
+	case from > to && clock < to:
+		// the span started the day before
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return len(r.Days) == 0 || onDay(r.Days, day)
+}
+
+// onDay reports whether day is one of days.
+func onDay(days []string, day time.Weekday) bool {
+	for _, d := range days {
+		if weekdays[d] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// maskPosition returns lu without its details, at the center of place if
+// inPlace, and else on a coarse grid.
+func maskPosition(lu owntracks.LocationUpdate, inPlace bool, place storage.Place) owntracks.LocationUpdate {
+	if inPlace {
+		lu.Latitude, lu.Longitude = place.Latitude, place.Longitude
+		lu.Accuracy = max(lu.Accuracy, int(math.Ceil(place.Radius)))
+	} else {
+		lu.Latitude = math.Round(lu.Latitude*maskScale) / maskScale
+		lu.Longitude = math.Round(lu.Longitude*maskScale) / maskScale
+		lu.Accuracy = max(lu.Accuracy, maskAccuracy)
+	}
+	lu.Altitude, lu.Velocity, lu.Course = 0, 0, 0
+	lu.Description, lu.Geohash = "", ""
+	return lu
+}
@@ -0,0 +1,97 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"middleware"
+	"owntracks"
+)
+
+// Sink accepts the location updates received by a Protocol.
+type Sink func(lu owntracks.LocationUpdate) error
+
+// Protocol receives location updates from trackers. Every Protocol is either
+// a Listener or a Handler.
+type Protocol interface {
+	Name() string
+}
+
+// Listener is a Protocol that maintains its own connections.
+type Listener interface {
+	Protocol
+	// Listen starts receiving location updates and passes them to sink until
+	// done is closed. It returns once receiving has started.
+	Listen(sink Sink, done <-chan struct{}) error
+}
+
+// Handler is a Protocol that receives location updates over HTTP.
+type Handler interface {
+	Protocol
+	// RegisterRoutes registers the endpoints of the protocol with r, all
+	// received location updates are passed to sink.
+	RegisterRoutes(r *middleware.Router, sink Sink)
+}
+
+// Settings are passed to a Factory.
+type Settings struct {
+	Logger *log.Logger
+	// Options is the section of the config file for the protocol.
+	Options json.RawMessage
+}
+
+// A Factory creates a Protocol from its settings.
+type Factory func(s Settings) (Protocol, error)
+
+var (
+	protocolsMu sync.Mutex
+	protocols   = make(map[string]Factory)
+)
+
+// Register makes a protocol available under name.
+func Register(name string, f Factory) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if _, dup := protocols[name]; dup {
+		panic("ingest: Register called twice for protocol " + name)
+	}
+	protocols[name] = f
+}
+
+// Protocols returns the names of all registered protocols, sorted.
+func Protocols() []string {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	var names []string
+	for n := range protocols {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the protocol registered as name.
+func New(name string, s Settings) (Protocol, error) {
+	protocolsMu.Lock()
+	f, ok := protocols[name]
+	protocolsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("ingest: unknown protocol %q", name)
+	}
+	p, err := f(s)
+	if err != nil {
+		return nil, fmt.Errorf("ingest: %s: %v", name, err)
+	}
+	return p, nil
+}
+
+// decodeOptions decodes the options in s into v, if there are any.
+func decodeOptions(s Settings, v interface{}) error {
+	if len(s.Options) == 0 {
+		return nil
+	}
+	return json.Unmarshal(s.Options, v)
+}
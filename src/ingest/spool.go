@@ -0,0 +1,145 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+
+	"owntracks"
+	"storage"
+)
+
+// maxSpoolLine is the longest line of a spool file.
+const maxSpoolLine = 1 << 20
+
+// Spool keeps positions in an append-only file, one JSON object per line,
+// while they cannot be stored, e.g. while the database is down. Replay moves
+// them to the storage once it is available again.
+type Spool struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	n      int
+	logger *log.Logger
+}
+
+// OpenSpool opens the spool file at path, positions already in it are kept
+// for the next Replay. Positions dropped by Replay are logged to logger.
+func OpenSpool(path string, logger *log.Logger) (*Spool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{path: path, f: f, logger: logger}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxSpoolLine)
+	for sc.Scan() {
+		s.n++
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Len returns the number of positions in the spool.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// Add appends lu to the spool. It returns once lu is on disk.
+func (s *Spool) Add(lu owntracks.LocationUpdate) error {
+	b, err := json.Marshal(lu)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.n++
+	return nil
+}
+
+// Replay inserts the positions of the spool into store, in the order they
+// were added. Positions the store refuses as invalid are logged and
+// dropped. Replay stops at the first position that fails otherwise and
+// keeps it and all later ones. It returns the number of stored positions.
+func (s *Spool) Replay(store storage.Store) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 {
+		return 0, nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return 0, err
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	stored := 0
+	var i int
+	for ; i < len(lines); i++ {
+		var lu owntracks.LocationUpdate
+		// skip empty lines and lines cut off by a crash
+		if json.Unmarshal(lines[i], &lu) != nil {
+			continue
+		}
+		err = store.InsertPosition(lu)
+		if errors.Is(err, storage.ErrInvalidPosition) {
+			s.logger.Printf("Dropping spooled position of %s: %v", storage.DeviceName(lu), err)
+			err = nil
+			continue
+		}
+		if err != nil {
+			break
+		}
+		stored++
+	}
+	if kerr := s.keep(lines[i:]); kerr != nil && err == nil {
+		err = kerr
+	}
+	return stored, err
+}
+
+// keep replaces the content of the spool with lines.
+func (s *Spool) keep(lines [][]byte) error {
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, bytes.Join(lines, nil), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	s.n = 0
+	for _, l := range lines {
+		if len(bytes.TrimSpace(l)) > 0 {
+			s.n++
+		}
+	}
+	return nil
+}
+
+// Close closes the spool file.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,52 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+// refusingStore fails to store the positions of the trackers in fail.
+type refusingStore struct {
+	*storage.Memory
+	fail map[string]error
+}
+
+func (s refusingStore) InsertPosition(lu owntracks.LocationUpdate) error {
+	if err := s.fail[lu.TrackerID]; err != nil {
+		return err
+	}
+	return s.Memory.InsertPosition(lu)
+}
+
+func TestSpoolReplay(t *testing.T) {
+	s, err := OpenSpool(filepath.Join(t.TempDir(), "spool.jsonl"), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	now := time.Now()
+	for _, tracker := range []string{"a", "invalid", "b", "down", "c"} {
+		if err := s.Add(owntracks.LocationUpdate{T: now, User: "u", TrackerID: tracker}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := refusingStore{storage.NewMemory(), map[string]error{
+		"invalid": fmt.Errorf("bad: %w", storage.ErrInvalidPosition),
+		"down":    errors.New("database is down"),
+	}}
+	if n, err := s.Replay(store); n != 2 || err == nil || s.Len() != 2 {
+		t.Errorf("first replay stored %d, %v, left %d, want 2, the error of down, 2", n, err, s.Len())
+	}
+	delete(store.fail, "down")
+	if n, err := s.Replay(store); n != 2 || err != nil || s.Len() != 0 {
+		t.Errorf("second replay stored %d, %v, left %d, want 2, no error, 0", n, err, s.Len())
+	}
+}
@@ -0,0 +1,285 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"owntracks"
+)
+
+func init() {
+	Register("udp", func(s Settings) (Protocol, error) {
+		u := &UDP{Address: ":5005", Logger: s.Logger}
+		if err := decodeOptions(s, u); err != nil {
+			return nil, err
+		}
+		for token, device := range u.Tokens {
+			if user, tracker, ok := strings.Cut(device, "/"); !ok || user == "" || tracker == "" {
+				return nil, fmt.Errorf("device %q of token %q is not <user>/<tracker>", device, token)
+			}
+		}
+		return u, nil
+	})
+}
+
+// udpStats counts the datagrams of the UDP protocol. "lost" is derived from
+// gaps in the sequence numbers.
+var udpStats = expvar.NewMap("ingest_udp")
+
+// udpBinaryVersion is the first byte of binary datagrams.
+const udpBinaryVersion = 1
+
+// UDP receives positions as single datagrams, for trackers that cannot keep
+// a TCP connection, like ESP32 boards or LoRa gateways. Nothing is sent back.
+//
+// A datagram is either a line of CSV
+//
+//	token,seq,time,lat,lon[,alt,vel,cog,batt,acc]
+//
+// with time in seconds since the Unix epoch, or binary, big-endian:
+//
+//	uint8  1 (version)
+//	uint8  length of the token, followed by the token
+//	uint32 seq
+//	uint32 time
+//	int32  lat and lon in 1e-7 degrees
+//	int16  alt in [m]
+//	uint16 vel in [km/h], cog in [degree]
+//	uint8  batt in [%]
+//	uint16 acc in [m]
+//
+// seq is incremented by the tracker for every datagram.
+type UDP struct {
+	// Address is the address to listen on, like ":5005".
+	Address string
+	// Tokens maps the token of every tracker to its device, as
+	// "<user>/<tracker>".
+	Tokens map[string]string
+
+	Logger *log.Logger `json:"-"`
+	mu     sync.Mutex
+	seqs   map[string]*udpSeqs
+}
+
+// udpSeqWindow is the number of sequence numbers before the last one whose
+// reception is remembered. Numbers further back are taken as a restart of
+// the tracker.
+const udpSeqWindow = 1024
+
+// udpSeqs are the sequence numbers received from a tracker.
+type udpSeqs struct {
+	// last is the newest number, first the oldest one accounted for, all
+	// numbers in between were either received or counted as lost.
+	last, first uint32
+	// received has the bit seq%udpSeqWindow set for the received numbers
+	// of the window.
+	received [udpSeqWindow / 64]uint64
+}
+
+func (s *udpSeqs) has(seq uint32) bool {
+	i := seq % udpSeqWindow
+	return s.received[i/64]&(1<<(i%64)) != 0
+}
+
+func (s *udpSeqs) set(seq uint32, on bool) {
+	i := seq % udpSeqWindow
+	if on {
+		s.received[i/64] |= 1 << (i % 64)
+	} else {
+		s.received[i/64] &^= 1 << (i % 64)
+	}
+}
+
+func (u *UDP) Name() string {
+	return "udp"
+}
+
+// Listen receives datagrams until done is closed.
+func (u *UDP) Listen(sink Sink, done <-chan struct{}) error {
+	conn, err := net.ListenPacket("udp", u.Address)
+	if err != nil {
+		return err
+	}
+	u.Logger.Printf("Listening for UDP positions on %s", conn.LocalAddr())
+	go func() {
+		<-done
+		conn.Close()
+	}()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					u.Logger.Printf("UDP listener stopped: %v", err)
+				}
+				return
+			}
+			udpStats.Add("received", 1)
+			if err := u.handle(buf[:n], sink); err != nil {
+				u.Logger.Printf("Rejected UDP datagram from %s: %v", addr, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// handle decodes a datagram and passes its position to sink.
+func (u *UDP) handle(b []byte, sink Sink) error {
+	var token string
+	var seq uint32
+	var lu owntracks.LocationUpdate
+	var err error
+	if len(b) > 0 && b[0] == udpBinaryVersion {
+		token, seq, lu, err = decodeUDPBinary(b)
+	} else {
+		token, seq, lu, err = decodeUDPCSV(string(b))
+	}
+	if err != nil {
+		udpStats.Add("invalid", 1)
+		return err
+	}
+	device, ok := u.Tokens[token]
+	if !ok {
+		udpStats.Add("unknown_token", 1)
+		return errors.New("unknown token")
+	}
+	if !u.checkSeq(token, seq) {
+		udpStats.Add("duplicate", 1)
+		return nil
+	}
+	lu.User, lu.TrackerID, _ = strings.Cut(device, "/")
+	lu.ClientID = "udp"
+	lu.Trigger = owntracks.TimerBasedUpdate
+	if err := sink(lu); err != nil {
+		udpStats.Add("rejected", 1)
+		return err
+	}
+	udpStats.Add("accepted", 1)
+	return nil
+}
+
+// checkSeq records seq as received for token and counts skipped sequence
+// numbers as lost. It returns false for a repeated sequence number.
+// Sequence numbers wrap around, they are compared as serial numbers by RFC
+// 1982. Numbers more than udpSeqWindow below the last one are taken as a
+// restart of the tracker.
+func (u *UDP) checkSeq(token string, seq uint32) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.seqs == nil {
+		u.seqs = make(map[string]*udpSeqs)
+	}
+	s := u.seqs[token]
+	var diff int32
+	if s != nil {
+		diff = int32(seq - s.last)
+	}
+	if s == nil || diff <= -udpSeqWindow {
+		s = &udpSeqs{last: seq, first: seq}
+		s.set(seq, true)
+		u.seqs[token] = s
+		return true
+	}
+	switch {
+	case diff == 0:
+		return false
+	case diff > 0:
+		if diff > 1 {
+			udpStats.Add("lost", int64(diff-1))
+		}
+		// the bits of the numbers that left the window are reused
+		for n, i := s.last+1, int32(0); i < diff && i < udpSeqWindow; n, i = n+1, i+1 {
+			s.set(n, false)
+		}
+		s.last = seq
+		if int32(s.last-s.first) >= udpSeqWindow {
+			s.first = s.last - udpSeqWindow + 1
+		}
+	case s.has(seq):
+		return false
+	case int32(seq-s.first) >= 0:
+		// late arrival of a datagram counted as lost before
+		udpStats.Add("lost", -1)
+	}
+	s.set(seq, true)
+	return true
+}
+
+func decodeUDPCSV(s string) (string, uint32, owntracks.LocationUpdate, error) {
+	var lu owntracks.LocationUpdate
+	f := strings.Split(strings.TrimSpace(s), ",")
+	if len(f) < 5 {
+		return "", 0, lu, errors.New("too few fields")
+	}
+	seq, err := strconv.ParseUint(f[1], 10, 32)
+	if err != nil {
+		return "", 0, lu, fmt.Errorf("invalid seq %q", f[1])
+	}
+	sec, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil {
+		return "", 0, lu, fmt.Errorf("invalid time %q", f[2])
+	}
+	lu.T = time.Unix(sec, 0)
+	if lu.Latitude, err = strconv.ParseFloat(f[3], 64); err != nil {
+		return "", 0, lu, fmt.Errorf("invalid lat %q", f[3])
+	}
+	if lu.Longitude, err = strconv.ParseFloat(f[4], 64); err != nil {
+		return "", 0, lu, fmt.Errorf("invalid lon %q", f[4])
+	}
+	for i, p := range []*int{&lu.Altitude, &lu.Velocity, &lu.Course, &lu.Battery, &lu.Accuracy} {
+		if 5+i >= len(f) || f[5+i] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(f[5+i], 64)
+		if err != nil {
+			return "", 0, lu, fmt.Errorf("invalid field %d %q", 6+i, f[5+i])
+		}
+		*p = int(v + 0.5)
+	}
+	return f[0], uint32(seq), lu, nil
+}
+
+func decodeUDPBinary(b []byte) (string, uint32, owntracks.LocationUpdate, error) {
+	var lu owntracks.LocationUpdate
+	if len(b) < 2 {
+		return "", 0, lu, errors.New("datagram too short")
+	}
+	n := 2 + int(b[1])
+	if len(b) < n {
+		return "", 0, lu, errors.New("datagram too short")
+	}
+	token := string(b[2:n])
+	var p struct {
+		Seq      uint32
+		Time     uint32
+		Lat, Lon int32
+		Alt      int16
+		Vel, Cog uint16
+		Batt     uint8
+		Acc      uint16
+	}
+	if err := binary.Read(bytes.NewReader(b[n:]), binary.BigEndian, &p); err != nil {
+		return "", 0, lu, errors.New("datagram too short")
+	}
+	lu.T = time.Unix(int64(p.Time), 0)
+	lu.Latitude = float64(p.Lat) / 1e7
+	lu.Longitude = float64(p.Lon) / 1e7
+	lu.Altitude = int(p.Alt)
+	lu.Velocity = int(p.Vel)
+	lu.Course = int(p.Cog)
+	lu.Battery = int(p.Batt)
+	lu.Accuracy = int(p.Acc)
+	return token, p.Seq, lu, nil
+}
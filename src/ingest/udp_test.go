@@ -0,0 +1,53 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// udpBinary returns a binary datagram of token with the sequence number
+// seq at 50°N, 8°E.
+func udpBinary(token string, seq uint32) []byte {
+	b := []byte{udpBinaryVersion, byte(len(token))}
+	b = append(b, token...)
+	var p bytes.Buffer
+	binary.Write(&p, binary.BigEndian, struct {
+		Seq      uint32
+		Time     uint32
+		Lat, Lon int32
+		Alt      int16
+		Vel, Cog uint16
+		Batt     uint8
+		Acc      uint16
+	}{seq, 1760000000, 50e7, 8e7, 120, 30, 90, 80, 5})
+	return append(b, p.Bytes()...)
+}
+
+func TestDecodeUDPBinary(t *testing.T) {
+	token, seq, lu, err := decodeUDPBinary(udpBinary("abc", 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "abc" || seq != 7 || lu.Latitude != 50 || lu.Longitude != 8 || lu.T.Unix() != 1760000000 ||
+		lu.Altitude != 120 || lu.Velocity != 30 || lu.Course != 90 || lu.Battery != 80 || lu.Accuracy != 5 {
+		t.Errorf("decoded %q %d %+v", token, seq, lu)
+	}
+
+	long := string(bytes.Repeat([]byte{'t'}, 255))
+	for _, b := range [][]byte{
+		{udpBinaryVersion},
+		{udpBinaryVersion, 0xff},
+		{udpBinaryVersion, 0xfe, 'a'},
+		append([]byte{udpBinaryVersion, 0xff}, long[:254]...),
+		append([]byte{udpBinaryVersion, 0xff}, long...),
+		udpBinary("abc", 7)[:20],
+	} {
+		if _, _, _, err := decodeUDPBinary(b); err == nil {
+			t.Errorf("% x: no error", b)
+		}
+	}
+	if token, _, _, err := decodeUDPBinary(udpBinary(long, 1)); err != nil || token != long {
+		t.Errorf("token of 255 bytes: %q, %v", token, err)
+	}
+}
@@ -0,0 +1,197 @@
+package ingest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"middleware"
+	"owntracks"
+)
+
+func init() {
+	Register("ulogger", func(s Settings) (Protocol, error) {
+		u := &ULogger{TrackerID: "ulogger"}
+		if err := decodeOptions(s, u); err != nil {
+			return nil, err
+		}
+		for user := range u.Users {
+			if user == "" || strings.Contains(user, "/") {
+				return nil, fmt.Errorf("invalid user %q", user)
+			}
+		}
+		if u.TrackerID == "" || strings.Contains(u.TrackerID, "/") {
+			return nil, fmt.Errorf("invalid TrackerID %q", u.TrackerID)
+		}
+		u.key = make([]byte, 32)
+		if _, err := rand.Read(u.key); err != nil {
+			return nil, err
+		}
+		u.trackID.Store(time.Now().Unix())
+		return u, nil
+	})
+}
+
+// uLoggerCookie is the name of the session cookie of ULogger.
+const uLoggerCookie = "ulogger"
+
+// ULogger receives positions in the client protocol of µLogger, so that the
+// µLogger Android app only needs the URL of daisser instead of the one of
+// a µLogger server.
+//
+// The app POSTs forms to /client/index.php, whose parameter action is
+// "auth" to log in with the parameters user and pass, "addtrack" to start a
+// track, and "addpos" to add a position with the parameters time, lat, lon,
+// altitude, speed, bearing, accuracy and comment. The comment becomes the
+// description. daisser has no tracks, so all positions of a user go to the
+// same tracker, and photos are not stored.
+type ULogger struct {
+	// Users maps the users that may log in to their passwords.
+	Users map[string]string
+	// TrackerID is the tracker the positions of every user are stored for,
+	// "ulogger" by default.
+	TrackerID string
+
+	// key signs the session cookies, so sessions end on restart and the
+	// app logs in again.
+	key []byte
+	// trackID is the last id given to a track.
+	trackID atomic.Int64
+}
+
+func (u *ULogger) Name() string {
+	return "ulogger"
+}
+
+// RegisterRoutes registers /client/index.php with r.
+func (u *ULogger) RegisterRoutes(r *middleware.Router, sink Sink) {
+	r.HandleFunc("/client/index.php", func(w http.ResponseWriter, req *http.Request) {
+		u.serve(w, req, sink)
+	})
+}
+
+// uLoggerResponse is the JSON body of every response of ULogger.
+type uLoggerResponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message,omitempty"`
+	TrackID int64  `json:"trackid,omitempty"`
+}
+
+// uLoggerReply writes res with the given status code.
+func uLoggerReply(w http.ResponseWriter, status int, res uLoggerResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (u *ULogger) serve(w http.ResponseWriter, r *http.Request, sink Sink) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	v, err := requestValues(w, r)
+	if err != nil {
+		uLoggerReply(w, http.StatusBadRequest, uLoggerResponse{Error: true, Message: "Bad request body: " + err.Error()})
+		return
+	}
+	if v.Get("action") == "auth" {
+		user := v.Get("user")
+		pass, ok := u.Users[user]
+		if !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(v.Get("pass"))) != 1 {
+			uLoggerReply(w, http.StatusUnauthorized, uLoggerResponse{Error: true, Message: "Unauthorized"})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     uLoggerCookie,
+			Value:    base64.RawURLEncoding.EncodeToString([]byte(user)) + "." + u.sign(user),
+			Path:     middleware.Base(r) + "/client/",
+			HttpOnly: true,
+		})
+		uLoggerReply(w, http.StatusOK, uLoggerResponse{})
+		return
+	}
+	user, ok := u.session(r)
+	if !ok {
+		uLoggerReply(w, http.StatusUnauthorized, uLoggerResponse{Error: true, Message: "Unauthorized"})
+		return
+	}
+	if replayIdempotent(w, r, user+"/"+u.TrackerID) {
+		return
+	}
+	switch v.Get("action") {
+	case "addtrack":
+		uLoggerReply(w, http.StatusOK, uLoggerResponse{TrackID: u.trackID.Add(1)})
+	case "addpos":
+		lu := owntracks.LocationUpdate{
+			Trigger:     owntracks.AutoLocationUpdate,
+			User:        user,
+			ClientID:    "ulogger",
+			TrackerID:   u.TrackerID,
+			Description: v.Get("comment"),
+		}
+		var err1, err2, err3 error
+		lu.Latitude, err1 = strconv.ParseFloat(v.Get("lat"), 64)
+		lu.Longitude, err2 = strconv.ParseFloat(v.Get("lon"), 64)
+		lu.T, err3 = parseTimestamp(v.Get("time"))
+		if err1 != nil || err2 != nil || err3 != nil {
+			uLoggerReply(w, http.StatusBadRequest, uLoggerResponse{Error: true, Message: "Missing required parameter"})
+			return
+		}
+		// the app leaves out unknown values
+		if f, err := strconv.ParseFloat(v.Get("accuracy"), 64); err == nil {
+			lu.Accuracy = int(f + 0.5)
+		}
+		if f, err := strconv.ParseFloat(v.Get("altitude"), 64); err == nil {
+			lu.Altitude = int(f + 0.5)
+		}
+		if f, err := strconv.ParseFloat(v.Get("bearing"), 64); err == nil {
+			lu.Course = int(f + 0.5)
+		}
+		if f, err := strconv.ParseFloat(v.Get("speed"), 64); err == nil {
+			// [m/s]
+			lu.Velocity = int(f*3.6 + 0.5)
+		}
+		if err := sink(lu); err != nil {
+			uLoggerReply(w, http.StatusBadRequest, uLoggerResponse{Error: true, Message: "Rejected position: " + err.Error()})
+			return
+		}
+		uLoggerReply(w, http.StatusOK, uLoggerResponse{})
+	default:
+		uLoggerReply(w, http.StatusBadRequest, uLoggerResponse{Error: true, Message: "Unknown command"})
+	}
+}
+
+// sign returns the signature of the session of user.
+func (u *ULogger) sign(user string) string {
+	m := hmac.New(sha256.New, u.key)
+	m.Write([]byte(user))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// session returns the user of the session cookie of r, if it is valid.
+func (u *ULogger) session(r *http.Request) (string, bool) {
+	c, err := r.Cookie(uLoggerCookie)
+	if err != nil {
+		return "", false
+	}
+	name, sig, _ := strings.Cut(c.Value, ".")
+	user, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(u.sign(string(user)))) {
+		return "", false
+	}
+	// users removed from the config are logged out
+	if _, ok := u.Users[string(user)]; !ok {
+		return "", false
+	}
+	return string(user), true
+}
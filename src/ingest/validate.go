@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+
+	"owntracks"
+)
+
+// Limits for incoming location updates. Updates violating them are rejected
+// before they are stored.
+const (
+	// maxClockSkew is how far a timestamp may lie in the future.
+	maxClockSkew = 10 * time.Minute
+	// maxTextLength is the maximum length of any string field.
+	maxTextLength = 256
+)
+
+// minTimestamp is the earliest timestamp accepted. Earlier ones are usually
+// produced by trackers that have not received a GPS fix yet.
+var minTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Validate checks lu for plausibility. All ingested location updates have to
+// pass it.
+func Validate(lu owntracks.LocationUpdate, now time.Time) error {
+	// the ranges are negated, so that NaN is out of range
+	switch {
+	case !(lu.Latitude >= -90 && lu.Latitude <= 90):
+		return fmt.Errorf("latitude %v out of range", lu.Latitude)
+	case !(lu.Longitude >= -180 && lu.Longitude <= 180):
+		return fmt.Errorf("longitude %v out of range", lu.Longitude)
+	case lu.T.Before(minTimestamp):
+		return fmt.Errorf("timestamp %v too old", lu.T)
+	case lu.T.After(now.Add(maxClockSkew)):
+		return fmt.Errorf("timestamp %v in the future", lu.T)
+	case lu.Accuracy < 0:
+		return fmt.Errorf("negative accuracy %d", lu.Accuracy)
+	case lu.Battery < 0 || lu.Battery > 100:
+		return fmt.Errorf("battery level %d out of range", lu.Battery)
+	}
+	for _, f := range []string{lu.User, lu.ClientID, lu.TrackerID, lu.Description} {
+		if len(f) > maxTextLength {
+			return fmt.Errorf("field exceeds %d characters", maxTextLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package ingest
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"owntracks"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	valid := owntracks.LocationUpdate{T: now, User: "u", TrackerID: "t", Latitude: 50, Longitude: 8, Battery: 50}
+	if err := Validate(valid, now); err != nil {
+		t.Fatal(err)
+	}
+	for name, change := range map[string]func(*owntracks.LocationUpdate){
+		"latitude NaN":        func(lu *owntracks.LocationUpdate) { lu.Latitude = math.NaN() },
+		"longitude NaN":       func(lu *owntracks.LocationUpdate) { lu.Longitude = math.NaN() },
+		"latitude +Inf":       func(lu *owntracks.LocationUpdate) { lu.Latitude = math.Inf(1) },
+		"longitude -Inf":      func(lu *owntracks.LocationUpdate) { lu.Longitude = math.Inf(-1) },
+		"latitude too large":  func(lu *owntracks.LocationUpdate) { lu.Latitude = 90.5 },
+		"longitude too small": func(lu *owntracks.LocationUpdate) { lu.Longitude = -180.5 },
+		"too old":             func(lu *owntracks.LocationUpdate) { lu.T = minTimestamp.Add(-time.Second) },
+		"in the future":       func(lu *owntracks.LocationUpdate) { lu.T = now.Add(maxClockSkew + time.Second) },
+		"negative accuracy":   func(lu *owntracks.LocationUpdate) { lu.Accuracy = -1 },
+		"battery":             func(lu *owntracks.LocationUpdate) { lu.Battery = 101 },
+		"description":         func(lu *owntracks.LocationUpdate) { lu.Description = strings.Repeat("x", maxTextLength+1) },
+	} {
+		lu := valid
+		change(&lu)
+		if err := Validate(lu, now); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
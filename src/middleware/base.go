@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type baseKey struct{}
+
+// Base returns the URL base the client of r used, without trailing slash. It
+// is "" if the server is served at the root.
+func Base(r *http.Request) string {
+	b, _ := r.Context().Value(baseKey{}).(string)
+	return b
+}
+
+// StripBase serves h under every prefix in bases, like http.StripPrefix. The
+// longest matching prefix is removed from the path and made available by
+// Base. Requests outside of all bases are answered with 404.
+func StripBase(h http.Handler, bases []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		best := -1
+		for i, b := range bases {
+			if (r.URL.Path == b || strings.HasPrefix(r.URL.Path, b+"/") || b == "") && (best < 0 || len(b) > len(bases[best])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		b := bases[best]
+		ctx := context.WithValue(r.Context(), baseKey{}, b)
+		http.StripPrefix(b, h).ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,102 @@
+// Package middleware provides composable wrappers for HTTP handlers and a
+// Router that applies them to every route registered with it.
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps a handler to add behavior before or after it runs.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in all of m, so that m[0] is the outermost one.
+func Chain(h http.Handler, m ...Middleware) http.Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	return h
+}
+
+// Router registers handlers on a http.ServeMux with a chain of middleware.
+type Router struct {
+	mux        *http.ServeMux
+	middleware []Middleware
+	// patterns collects the registered patterns, if it is not nil.
+	patterns map[string]bool
+}
+
+// NewRouter returns a Router registering on mux, applying m to all handlers.
+func NewRouter(mux *http.ServeMux, m ...Middleware) *Router {
+	return &Router{mux: mux, middleware: m}
+}
+
+// Group returns a Router on the same mux, that applies m in addition to the
+// middleware of r.
+func (r *Router) Group(m ...Middleware) *Router {
+	all := append(append([]Middleware(nil), r.middleware...), m...)
+	return &Router{mux: r.mux, middleware: all, patterns: r.patterns}
+}
+
+// Recording returns a Router like r that adds the patterns registered with
+// it or its groups to patterns.
+func (r *Router) Recording(patterns map[string]bool) *Router {
+	return &Router{mux: r.mux, middleware: r.middleware, patterns: patterns}
+}
+
+// Handle registers h for pattern.
+func (r *Router) Handle(pattern string, h http.Handler) {
+	if r.patterns != nil {
+		r.patterns[pattern] = true
+	}
+	r.mux.Handle(pattern, Chain(h, r.middleware...))
+}
+
+// HandleFunc registers f for pattern.
+func (r *Router) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request)) {
+	r.Handle(pattern, http.HandlerFunc(f))
+}
+
+// statusWriter records the status code written to a http.ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Logging logs every request with its status code and duration to logger.
+func Logging(logger *log.Logger) Middleware {
+	return func(h http.Handler) http.Handler {
+		f := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(sw, r)
+			logger.Printf("%s %s %s %d %s", r.RemoteAddr, r.Method, r.URL.Path, sw.status, time.Since(start))
+		}
+		return http.HandlerFunc(f)
+	}
+}
+
+// Timeout aborts handlers that take longer than d with 503 Service
+// Unavailable.
+func Timeout(d time.Duration) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.TimeoutHandler(h, d, "Request timed out")
+	}
+}
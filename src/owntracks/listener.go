@@ -17,6 +17,7 @@ const DefaultTimeout time.Duration = 10 * time.Second
 const DefaultClientId = "daisser"
 const DefaultTopic = "owntracks/#"
 const DefaultPort = 1883
+const DefaultMaxPayload = 64 * 1024
 
 type UpdateEventTrigger int
 
@@ -39,6 +40,9 @@ type locationMessage struct {
 	Accuracy int     `json:"acc"`  // in [m]
 	Battery  int     `json:"batt"` // in percent
 	Desc     string  `json:"desc"` // Description of a waypoint
+	Altitude int     `json:"alt"`  // height above sea level in [m]
+	Velocity int     `json:"vel"`  // in [km/h]
+	Course   int     `json:"cog"`  // course over ground in degrees
 
 	// "p" ping, issued randomly by background task. Note, that the tst in a ping is that of the last location
 	// "c" circular region enter/leave event
@@ -63,7 +67,14 @@ type LocationUpdate struct {
 	Battery     int
 	Latitude    float64
 	Longitude   float64
+	Altitude    int
+	Velocity    int
+	Course      int
 	Description string
+	Geohash     string
+	// DeviceT is the timestamp sent by the device if T had to be corrected
+	// for the skew of its clock, and zero otherwise.
+	DeviceT time.Time `json:",omitzero"`
 }
 
 // Listener implements a MQTT client that listens for owntracks messages.
@@ -76,6 +87,18 @@ type Listener struct {
 	Timeout  time.Duration
 	ClientID string
 
+	// CAFile is a PEM file with the certificates used to verify the broker.
+	// If empty, the system roots are used.
+	CAFile string
+
+	// MaxPayload is the size in bytes above which messages are discarded
+	// without being parsed.
+	MaxPayload int
+
+	// Topics are the topic filters Connect subscribes to. If empty,
+	// DefaultTopic is used.
+	Topics []string
+
 	messages chan Message
 	client   *mqtt.Client
 }
@@ -135,12 +158,11 @@ func (l Listener) BrokerAddress() string {
 	return "tcp" + s
 }
 
-// Connect establishes the connection to the MQTT Broker and subscribes to the
-// owntracks topics. It returns a channel over which any received messages are
-// sent or the first error that was encountered.
-func (l *Listener) Connect() (<-chan Message, error) {
+// Dial establishes the connection to the MQTT Broker without subscribing to
+// any topics. This is sufficient for clients that only publish messages.
+func (l *Listener) Dial() error {
 	if l.client != nil && l.client.IsConnected() {
-		return nil, errors.New("Listener.Connect: already connected")
+		return errors.New("Listener.Dial: already connected")
 	}
 	if l.Timeout == 0 {
 		l.Timeout = DefaultTimeout
@@ -151,41 +173,68 @@ func (l *Listener) Connect() (<-chan Message, error) {
 	if l.ClientID == "" {
 		l.ClientID = DefaultClientId
 	}
-
-	l.messages = make(chan Message)
+	if l.MaxPayload == 0 {
+		l.MaxPayload = DefaultMaxPayload
+	}
 
 	// create a ClientOptions struct setting the broker address, clientid, turn
 	// off trace output and set the default message handler
 	opts := mqtt.NewClientOptions()
 	broker := fmt.Sprintf("%s:%d", l.Hostname, l.Port)
 	if l.UseTLS {
-		roots := x509.NewCertPool()
-		cacrt, _ := ioutil.ReadFile("/home/fabian/owntracks-ca.crt")
-		if ok := roots.AppendCertsFromPEM(cacrt); !ok {
-			return nil, errors.New("Listener.Connect: Could not read CA certificates")
+		var roots *x509.CertPool
+		if l.CAFile != "" {
+			roots = x509.NewCertPool()
+			cacrt, _ := ioutil.ReadFile(l.CAFile)
+			if ok := roots.AppendCertsFromPEM(cacrt); !ok {
+				return errors.New("Listener.Dial: Could not read CA certificates")
+			}
 		}
 		opts.SetTLSConfig(&tls.Config{RootCAs: roots})
 		opts.AddBroker("ssl://" + broker)
 	} else {
 		opts.AddBroker("tcp://" + broker)
 	}
-	opts.SetUsername("fabian")
-	opts.SetPassword("fabian")
+	opts.SetUsername(l.Username)
+	opts.SetPassword(l.Password)
 	opts.SetClientID(l.ClientID)
 	opts.SetDefaultPublishHandler(l.handleMessage)
 
 	l.client = mqtt.NewClient(opts)
 	t := l.client.Connect()
 	if !t.WaitTimeout(l.Timeout) {
-		return nil, errors.New("Listener.Connect: timeout")
+		return errors.New("Listener.Dial: timeout")
 	}
 	if t.Error() != nil {
-		return nil, fmt.Errorf("Listener.Connect: %v", t.Error())
+		return fmt.Errorf("Listener.Dial: %v", t.Error())
+	}
+	return nil
+}
+
+// topics returns the topic filters l subscribes to.
+func (l *Listener) topics() []string {
+	if len(l.Topics) == 0 {
+		return []string{DefaultTopic}
+	}
+	return l.Topics
+}
+
+// Connect establishes the connection to the MQTT Broker and subscribes to the
+// owntracks topics, or to l.Topics if set. It returns a channel over which any received messages are
+// sent or the first error that was encountered.
+func (l *Listener) Connect() (<-chan Message, error) {
+	l.messages = make(chan Message)
+	if err := l.Dial(); err != nil {
+		return nil, err
 	}
 
-	//subscribe to the owntrack topics and request messages to be delivered
+	//subscribe to the topics and request messages to be delivered
 	//at a maximum qos of one, wait for the receipt to confirm the subscription
-	t = l.client.Subscribe(DefaultTopic, 1, nil)
+	filters := make(map[string]byte)
+	for _, topic := range l.topics() {
+		filters[topic] = 1
+	}
+	t := l.client.SubscribeMultiple(filters, nil)
 	if !t.WaitTimeout(l.Timeout) {
 		return nil, errors.New("Listener.Connect: timeout during subscription")
 	}
@@ -195,22 +244,41 @@ func (l *Listener) Connect() (<-chan Message, error) {
 	return l.messages, nil
 }
 
-// HandleMessage sends msq over l.messages
+// Publish sends m to the broker with a QoS of one. The broker keeps the
+// message for future subscribers if retained is set. Publish may only be
+// called after Dial or Connect.
+func (l *Listener) Publish(m Message, retained bool) error {
+	t := l.client.Publish(m.Topic, 1, retained, m.Payload)
+	if !t.WaitTimeout(l.Timeout) {
+		return errors.New("Listener.Publish: timeout")
+	}
+	return t.Error()
+}
+
+// HandleMessage sends msq over l.messages, unless it is too large.
 func (l *Listener) handleMessage(client *mqtt.Client, msg mqtt.Message) {
+	if len(msg.Payload()) > l.MaxPayload {
+		return
+	}
 	l.messages <- Message{Topic: msg.Topic(), Payload: msg.Payload()}
 }
 
 // Disconnect closes the connection to the MQTT broker that was serving the owntracks info.
 func (l *Listener) Disconnect() error {
 	var err error
-	t := l.client.Unsubscribe(DefaultTopic)
-	if !t.WaitTimeout(l.Timeout) {
-		err = errors.New("Listener.Disconnect: timeout")
-	} else {
-		err = t.Error()
+	if l.messages != nil {
+		t := l.client.Unsubscribe(l.topics()...)
+		if !t.WaitTimeout(l.Timeout) {
+			err = errors.New("Listener.Disconnect: timeout")
+		} else {
+			err = t.Error()
+		}
 	}
 	l.client.Disconnect(250)
-	close(l.messages)
+	if l.messages != nil {
+		close(l.messages)
+		l.messages = nil
+	}
 	return err
 }
 
@@ -220,6 +288,27 @@ type Message struct {
 	Payload []byte
 }
 
+// NewLocationMessage returns lu as an OwnTracks location message, published
+// under the topic of its user and client.
+func NewLocationMessage(lu LocationUpdate) Message {
+	lm := locationMessage{
+		Type:      "location",
+		Lat:       lu.Latitude,
+		Lon:       lu.Longitude,
+		Epoch:     lu.T.Unix(),
+		Accuracy:  lu.Accuracy,
+		Battery:   lu.Battery,
+		Desc:      lu.Description,
+		Altitude:  lu.Altitude,
+		Velocity:  lu.Velocity,
+		Course:    lu.Course,
+		Trigger:   "a",
+		TrackerID: lu.TrackerID,
+	}
+	b, _ := json.Marshal(lm)
+	return Message{Topic: "owntracks/" + lu.User + "/" + lu.ClientID, Payload: b}
+}
+
 // ParseLocationUpdate tries to interpret m as a location update.
 func (m Message) ParseLocationUpdate() LocationUpdate {
 	var lm locationMessage
@@ -244,6 +333,9 @@ func (m Message) ParseLocationUpdate() LocationUpdate {
 		Battery:     lm.Battery,
 		Latitude:    lm.Lat,
 		Longitude:   lm.Lon,
+		Altitude:    lm.Altitude,
+		Velocity:    lm.Velocity,
+		Course:      lm.Course,
 		Description: lm.Desc,
 	}
 }
@@ -0,0 +1,276 @@
+package publish
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+	"time"
+
+	"geo"
+	"owntracks"
+	"storage"
+)
+
+const (
+	// mapSize is the width and height of the map of a bundle in pixels.
+	mapSize = 800
+	// mapMargin is the space in pixels around the tracks on the map.
+	mapMargin = 20
+)
+
+// trackColors are the colors of the tracks on the map, repeated for more
+// devices.
+var trackColors = []color.RGBA{
+	{0x33, 0x88, 0xff, 0xff},
+	{0xe4, 0x1a, 0x1c, 0xff},
+	{0x4d, 0xaf, 0x4a, 0xff},
+	{0x98, 0x4e, 0xa3, 0xff},
+	{0xff, 0x7f, 0x00, 0xff},
+}
+
+// encodeBundle returns a zip archive with the tracks as GPX, GeoJSON and
+// CSV, a map of them as PNG and an HTML summary, for keeping the complete
+// record of a trip. The files are in a directory called name.
+func encodeBundle(name string, tracks [][]owntracks.LocationUpdate, now time.Time) ([]byte, error) {
+	gpx, err := encodeGPX(name, tracks, now)
+	if err != nil {
+		return nil, err
+	}
+	geojson, err := encodeGeoJSON(tracks)
+	if err != nil {
+		return nil, err
+	}
+	csv, err := encodeCSV(tracks)
+	if err != nil {
+		return nil, err
+	}
+	var m bytes.Buffer
+	if err := png.Encode(&m, drawMap(tracks, mapSize)); err != nil {
+		return nil, err
+	}
+	summary, err := encodeSummary(name, tracks, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"index.html", summary},
+		{name + ".gpx", gpx},
+		{name + ".geojson", geojson},
+		{name + ".csv", csv},
+		{"map.png", m.Bytes()},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name + "/" + f.name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// encodeCSV returns the positions of the tracks as CSV with a header line.
+func encodeCSV(tracks [][]owntracks.LocationUpdate) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"user", "tracker", "time", "latitude", "longitude", "altitude", "accuracy", "velocity", "course", "battery"})
+	for _, t := range tracks {
+		for _, lu := range t {
+			w.Write([]string{
+				lu.User,
+				lu.TrackerID,
+				lu.T.UTC().Format(time.RFC3339),
+				strconv.FormatFloat(lu.Latitude, 'f', -1, 64),
+				strconv.FormatFloat(lu.Longitude, 'f', -1, 64),
+				strconv.Itoa(lu.Altitude),
+				strconv.Itoa(lu.Accuracy),
+				strconv.Itoa(lu.Velocity),
+				strconv.Itoa(lu.Course),
+				strconv.Itoa(lu.Battery),
+			})
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// drawMap returns an image of size x size pixels with the tracks drawn in
+// Web Mercator projection on a plain background. There are no map tiles,
+// so that exports do not depend on a tile server.
+func drawMap(tracks [][]owntracks.LocationUpdate, size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	project := func(lu owntracks.LocationUpdate) (float64, float64) {
+		lat := math.Max(-85, math.Min(85, lu.Latitude)) * math.Pi / 180
+		return lu.Longitude, -math.Log(math.Tan(math.Pi/4+lat/2)) * 180 / math.Pi
+	}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, t := range tracks {
+		for _, lu := range t {
+			x, y := project(lu)
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+	}
+	if math.IsInf(minX, 0) {
+		return img
+	}
+	scale := float64(size-2*mapMargin) / math.Max(math.Max(maxX-minX, maxY-minY), 1e-9)
+	// center the tracks along the shorter side
+	offX := (float64(size) - (maxX-minX)*scale) / 2
+	offY := (float64(size) - (maxY-minY)*scale) / 2
+	pixel := func(lu owntracks.LocationUpdate) (int, int) {
+		x, y := project(lu)
+		return int(offX + (x-minX)*scale), int(offY + (y-minY)*scale)
+	}
+	for i, t := range tracks {
+		c := trackColors[i%len(trackColors)]
+		x0, y0 := pixel(t[0])
+		for _, lu := range t[1:] {
+			x1, y1 := pixel(lu)
+			drawLine(img, x0, y0, x1, y1, c)
+			x0, y0 = x1, y1
+		}
+		// start and end
+		sx, sy := pixel(t[0])
+		drawDot(img, sx, sy, 4, color.RGBA{0x4d, 0xaf, 0x4a, 0xff})
+		drawDot(img, x0, y0, 4, color.RGBA{0xe4, 0x1a, 0x1c, 0xff})
+	}
+	return img
+}
+
+// drawLine draws a line from x0, y0 to x1, y1 that is 2 pixels wide.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		img.SetRGBA(x0+1, y0, c)
+		img.SetRGBA(x0, y0+1, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if 2*e >= dy {
+			e += dy
+			x0 += sx
+		}
+		if 2*e <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// drawDot draws a filled circle with radius r around x, y.
+func drawDot(img *image.RGBA, x, y, r int, c color.RGBA) {
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy <= r*r {
+				img.SetRGBA(x+dx, y+dy, c)
+			}
+		}
+	}
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// trackSummary holds the key figures of the track of one device.
+type trackSummary struct {
+	Device   string
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	Distance float64
+	MaxSpeed int
+	Points   int
+}
+
+var summaryTemplate = template.Must(template.New("summary").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Name }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{ .Name }}</h1>
+<p><img src="map.png" width="{{ .MapSize }}" height="{{ .MapSize }}" alt="Map of the tracks"></p>
+<table>
+<tr><th>Device</th><th>Start</th><th>End</th><th>Duration</th><th>Distance</th><th>Max. speed</th><th>Positions</th></tr>
+{{ range .Tracks }}<tr><td>{{ .Device }}</td><td>{{ .Start.Format "2006-01-02 15:04 MST" }}</td><td>{{ .End.Format "2006-01-02 15:04 MST" }}</td><td>{{ .Duration }}</td><td>{{ printf "%.1f" .Distance }} km</td><td>{{ .MaxSpeed }} km/h</td><td>{{ .Points }}</td></tr>
+{{ end }}</table>
+<p>Exported by daisser on {{ .Time.Format "2006-01-02 15:04 MST" }}. The positions are in the GPX, GeoJSON and CSV files next to this one.</p>
+</body>
+</html>
+`))
+
+// encodeSummary returns an HTML page with the map and the key figures of
+// the tracks.
+func encodeSummary(name string, tracks [][]owntracks.LocationUpdate, now time.Time) ([]byte, error) {
+	data := struct {
+		Name    string
+		Time    time.Time
+		MapSize int
+		Tracks  []trackSummary
+	}{Name: name, Time: now.UTC(), MapSize: mapSize}
+	for _, t := range tracks {
+		s := trackSummary{
+			Device:   storage.DeviceName(t[0]),
+			Start:    t[0].T.UTC(),
+			End:      t[len(t)-1].T.UTC(),
+			Duration: t[len(t)-1].T.Sub(t[0].T).Round(time.Minute),
+			Points:   len(t),
+		}
+		for i, lu := range t {
+			if i > 0 {
+				s.Distance += geo.Distance(t[i-1].Latitude, t[i-1].Longitude, lu.Latitude, lu.Longitude) / 1000
+			}
+			s.MaxSpeed = max(s.MaxSpeed, lu.Velocity)
+		}
+		data.Tracks = append(data.Tracks, s)
+	}
+	var b bytes.Buffer
+	if err := summaryTemplate.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
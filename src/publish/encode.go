@@ -0,0 +1,179 @@
+package publish
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+
+	"geo"
+	"gpkg"
+	"owntracks"
+	"shp"
+	"storage"
+)
+
+// encodeGeoJSON returns the tracks as a GeoJSON FeatureCollection of
+// LineStrings.
+func encodeGeoJSON(tracks [][]owntracks.LocationUpdate) ([]byte, error) {
+	type feature struct {
+		Type       string            `json:"type"`
+		Properties map[string]string `json:"properties"`
+		Geometry   struct {
+			Type        string       `json:"type"`
+			Coordinates [][2]float64 `json:"coordinates"`
+		} `json:"geometry"`
+	}
+	fc := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+	for _, t := range tracks {
+		var f feature
+		f.Type = "Feature"
+		f.Properties = map[string]string{
+			"User":    t[0].User,
+			"Tracker": t[0].TrackerID,
+			"Start":   t[0].T.UTC().Format(time.RFC3339),
+			"End":     t[len(t)-1].T.UTC().Format(time.RFC3339),
+		}
+		f.Geometry.Type = "LineString"
+		for _, lu := range t {
+			f.Geometry.Coordinates = append(f.Geometry.Coordinates, [2]float64{lu.Longitude, lu.Latitude})
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	return json.Marshal(fc)
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  int       `xml:"ele"`
+	Time time.Time `xml:"time"`
+}
+
+type gpxTrack struct {
+	Name   string     `xml:"name"`
+	Points []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+// encodeGPX returns the tracks as a GPX 1.1 document named name, with one
+// trk element per device.
+func encodeGPX(name string, tracks [][]owntracks.LocationUpdate, now time.Time) ([]byte, error) {
+	doc := struct {
+		XMLName xml.Name   `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+		Version string     `xml:"version,attr"`
+		Creator string     `xml:"creator,attr"`
+		Name    string     `xml:"metadata>name"`
+		Time    string     `xml:"metadata>time"`
+		Tracks  []gpxTrack `xml:"trk"`
+	}{Version: "1.1", Creator: "daisser", Name: name, Time: now.UTC().Format(time.RFC3339)}
+	for _, t := range tracks {
+		trk := gpxTrack{Name: storage.DeviceName(t[0])}
+		for _, lu := range t {
+			trk.Points = append(trk.Points, gpxPoint{lu.Latitude, lu.Longitude, lu.Altitude, lu.T.UTC()})
+		}
+		doc.Tracks = append(doc.Tracks, trk)
+	}
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// encodeGeoPackage returns the tracks as GeoPackage with a point layer of
+// the positions and a line layer of the tracks, for GIS programs like QGIS.
+func encodeGeoPackage(tracks [][]owntracks.LocationUpdate, now time.Time) ([]byte, error) {
+	col := func(name, typ string) gpkg.Column { return gpkg.Column{Name: name, Type: typ} }
+	positions := gpkg.Layer{
+		Name:         "positions",
+		Description:  "Positions of the devices",
+		GeometryType: gpkg.Point,
+		Columns: []gpkg.Column{
+			col("user", "TEXT"), col("tracker", "TEXT"), col("time", "DATETIME"),
+			col("altitude", "INTEGER"), col("accuracy", "INTEGER"), col("velocity", "INTEGER"),
+			col("course", "INTEGER"), col("battery", "INTEGER"),
+		},
+	}
+	lines := gpkg.Layer{
+		Name:         "tracks",
+		Description:  "Tracks of the devices",
+		GeometryType: gpkg.LineString,
+		Columns: []gpkg.Column{
+			col("user", "TEXT"), col("tracker", "TEXT"), col("start", "DATETIME"), col("end", "DATETIME"),
+			col("positions", "INTEGER"), col("distance_km", "DOUBLE"),
+		},
+	}
+	for _, t := range tracks {
+		coords := make([][2]float64, len(t))
+		distance := 0.0
+		for i, lu := range t {
+			coords[i] = [2]float64{lu.Longitude, lu.Latitude}
+			if i > 0 {
+				distance += geo.Distance(t[i-1].Latitude, t[i-1].Longitude, lu.Latitude, lu.Longitude) / 1000
+			}
+			positions.Features = append(positions.Features, gpkg.Feature{
+				Coordinates: coords[i : i+1],
+				Values:      []interface{}{lu.User, lu.TrackerID, lu.T, lu.Altitude, lu.Accuracy, lu.Velocity, lu.Course, lu.Battery},
+			})
+		}
+		// a line needs two points
+		if len(t) < 2 {
+			continue
+		}
+		lines.Features = append(lines.Features, gpkg.Feature{
+			Coordinates: coords,
+			Values:      []interface{}{t[0].User, t[0].TrackerID, t[0].T, t[len(t)-1].T, len(t), distance},
+		})
+	}
+	return gpkg.Encode([]gpkg.Layer{positions, lines}, now)
+}
+
+// encodeShapefile returns the tracks as zipped shapefiles, a point layer
+// of the positions and a polyline layer of the tracks.
+func encodeShapefile(tracks [][]owntracks.LocationUpdate, now time.Time) ([]byte, error) {
+	text := func(name string, length int) shp.Field { return shp.Field{Name: name, Type: 'C', Length: length} }
+	number := func(name string, length, decimals int) shp.Field {
+		return shp.Field{Name: name, Type: 'N', Length: length, Decimals: decimals}
+	}
+	positions := shp.Layer{
+		Name:      "positions",
+		ShapeType: shp.Point,
+		Fields: []shp.Field{
+			text("user", 64), text("tracker", 64), text("time", 20),
+			number("altitude", 6, 0), number("accuracy", 6, 0), number("velocity", 4, 0),
+			number("course", 3, 0), number("battery", 3, 0),
+		},
+	}
+	lines := shp.Layer{
+		Name:      "tracks",
+		ShapeType: shp.PolyLine,
+		Fields: []shp.Field{
+			text("user", 64), text("tracker", 64), text("start", 20), text("end", 20),
+			number("positions", 9, 0), number("distance", 10, 3),
+		},
+	}
+	for _, t := range tracks {
+		coords := make([][2]float64, len(t))
+		distance := 0.0
+		for i, lu := range t {
+			coords[i] = [2]float64{lu.Longitude, lu.Latitude}
+			if i > 0 {
+				distance += geo.Distance(t[i-1].Latitude, t[i-1].Longitude, lu.Latitude, lu.Longitude) / 1000
+			}
+			positions.Features = append(positions.Features, shp.Feature{
+				Coordinates: coords[i : i+1],
+				Values:      []interface{}{lu.User, lu.TrackerID, lu.T, lu.Altitude, lu.Accuracy, lu.Velocity, lu.Course, lu.Battery},
+			})
+		}
+		if len(t) < 2 {
+			continue
+		}
+		lines.Features = append(lines.Features, shp.Feature{
+			Coordinates: coords,
+			Values:      []interface{}{t[0].User, t[0].TrackerID, t[0].T, t[len(t)-1].T, len(t), distance},
+		})
+	}
+	return shp.Encode([]shp.Layer{positions, lines}, now)
+}
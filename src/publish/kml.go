@@ -0,0 +1,116 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+
+	"geo"
+	"owntracks"
+	"storage"
+)
+
+const (
+	// tourDuration is how long the flight along all tracks of a tour takes,
+	// in seconds.
+	tourDuration = 60
+	// tourStops is the number of positions a tour flies to at most.
+	tourStops = 300
+	// tourRange, tourTilt and tourFlyIn set up the camera of a tour: its
+	// distance to the track in [m], its tilt in degrees and the time of the
+	// flight to the start in seconds.
+	tourRange = 1200
+	tourTilt  = 60
+	tourFlyIn = 4
+)
+
+// EncodeKML returns the tracks as a KML document named name, with one
+// Placemark per device. With tour, the document also holds a gx:Tour that
+// flies along the tracks in Google Earth.
+func EncodeKML(name string, tracks [][]owntracks.LocationUpdate, tour bool) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">` + "\n")
+	b.WriteString("<Document>\n")
+	writeElement(&b, "name", name)
+	b.WriteString(`<Style id="track"><LineStyle><color>ff0000ff</color><width>4</width></LineStyle></Style>` + "\n")
+	for _, t := range tracks {
+		b.WriteString("<Placemark>\n")
+		writeElement(&b, "name", storage.DeviceName(t[0]))
+		b.WriteString("<styleUrl>#track</styleUrl>\n")
+		b.WriteString("<TimeSpan>")
+		writeElement(&b, "begin", t[0].T.UTC().Format("2006-01-02T15:04:05Z"))
+		writeElement(&b, "end", t[len(t)-1].T.UTC().Format("2006-01-02T15:04:05Z"))
+		b.WriteString("</TimeSpan>\n")
+		b.WriteString("<LineString><tessellate>1</tessellate><coordinates>\n")
+		for _, lu := range t {
+			fmt.Fprintf(&b, "%f,%f,%d\n", lu.Longitude, lu.Latitude, lu.Altitude)
+		}
+		b.WriteString("</coordinates></LineString>\n</Placemark>\n")
+	}
+	if tour {
+		writeTour(&b, name, tracks)
+	}
+	b.WriteString("</Document>\n</kml>\n")
+	return b.Bytes()
+}
+
+// writeTour writes a gx:Tour flying along the tracks one after the other.
+// The time of every leg is proportional to its length, so that the camera
+// moves at constant speed.
+func writeTour(b *bytes.Buffer, name string, tracks [][]owntracks.LocationUpdate) {
+	var stops []owntracks.LocationUpdate
+	var total float64
+	n := 0
+	for _, t := range tracks {
+		n += len(t)
+	}
+	step := int(math.Ceil(float64(n) / tourStops))
+	for _, t := range tracks {
+		for i := 0; i < len(t); i += step {
+			stops = append(stops, t[i])
+		}
+		if (len(t)-1)%step != 0 {
+			stops = append(stops, t[len(t)-1])
+		}
+	}
+	for i := 1; i < len(stops); i++ {
+		total += legLength(stops[i-1], stops[i])
+	}
+
+	b.WriteString("<gx:Tour>\n")
+	writeElement(b, "name", name)
+	b.WriteString("<gx:Playlist>\n")
+	heading := 0.0
+	for i, s := range stops {
+		// look ahead, the last stop keeps the heading of the last leg
+		if i+1 < len(stops) {
+			heading = geo.Bearing(s.Latitude, s.Longitude, stops[i+1].Latitude, stops[i+1].Longitude)
+		}
+		duration, mode := float64(tourFlyIn), "bounce"
+		if i > 0 {
+			duration, mode = 0, "smooth"
+			if total > 0 {
+				duration = tourDuration * legLength(stops[i-1], s) / total
+			}
+		}
+		fmt.Fprintf(b, "<gx:FlyTo><gx:duration>%.2f</gx:duration><gx:flyToMode>%s</gx:flyToMode>", duration, mode)
+		fmt.Fprintf(b, "<LookAt><longitude>%f</longitude><latitude>%f</latitude><altitude>0</altitude>", s.Longitude, s.Latitude)
+		fmt.Fprintf(b, "<heading>%.1f</heading><tilt>%d</tilt><range>%d</range>", heading, tourTilt, tourRange)
+		b.WriteString("<altitudeMode>relativeToGround</altitudeMode></LookAt></gx:FlyTo>\n")
+	}
+	b.WriteString("</gx:Playlist>\n</gx:Tour>\n")
+}
+
+// legLength returns the distance in [m] between a and b.
+func legLength(a, b owntracks.LocationUpdate) float64 {
+	return geo.Distance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+}
+
+// writeElement writes the element name with the escaped text.
+func writeElement(b *bytes.Buffer, name, text string) {
+	fmt.Fprintf(b, "<%s>", name)
+	xml.EscapeText(b, []byte(text))
+	fmt.Fprintf(b, "</%s>\n", name)
+}
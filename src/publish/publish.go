@@ -0,0 +1,229 @@
+// Package publish periodically writes exports of the stored tracks to a
+// target outside of daisser, so that static websites can embed them without
+// access to the API.
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"owntracks"
+	"storage"
+)
+
+// Job describes one export that is published periodically.
+type Job struct {
+	// File is the name of the published file, like "fabian-week.geojson".
+	File string
+	// Format is "geojson", "gpx", "kml", "kml-tour", which adds a tour
+	// flying along the tracks in Google Earth, "gpkg", a GeoPackage for GIS
+	// programs, "shp", zipped shapefiles, or "zip", an archive of GPX,
+	// GeoJSON, CSV, a map and an HTML summary.
+	Format string
+	// User and Tracker select the devices, empty ones select all.
+	User    string
+	Tracker string
+	// Last is the time span before each run that is exported, like "168h".
+	Last string
+	// Simplify is the tolerance in [m] by which the tracks are simplified,
+	// 0 exports all positions.
+	Simplify float64
+	// Every is the time between two runs, like "1h".
+	Every string
+	// Target is the directory the file is written to, or the http or https
+	// URL of a WebDAV collection, like a Nextcloud folder.
+	Target string
+	// WebDAV holds the settings of a WebDAV Target.
+	WebDAV WebDAV
+
+	last   time.Duration
+	every  time.Duration
+	target Target
+}
+
+// Target is where the files of a Job are published.
+type Target interface {
+	// Put stores data as the file name.
+	Put(name string, data []byte) error
+}
+
+// Publisher runs the publishing jobs.
+type Publisher struct {
+	store  storage.Store
+	jobs   []Job
+	logger *log.Logger
+}
+
+// NewPublisher checks the jobs and returns a Publisher that exports the
+// positions in store.
+func NewPublisher(store storage.Store, jobs []Job, logger *log.Logger) (*Publisher, error) {
+	p := &Publisher{store: store, logger: logger}
+	for i, j := range jobs {
+		if err := j.init(); err != nil {
+			return nil, fmt.Errorf("publish job %d: %v", i, err)
+		}
+		p.jobs = append(p.jobs, j)
+	}
+	return p, nil
+}
+
+// init checks the settings of j and parses them.
+func (j *Job) init() error {
+	if j.File == "" || j.File != filepath.Base(j.File) {
+		return fmt.Errorf("invalid File %q", j.File)
+	}
+	if _, ok := formats[j.Format]; !ok {
+		return fmt.Errorf("invalid Format %q, must be geojson, gpx, kml, kml-tour, gpkg, shp or zip", j.Format)
+	}
+	var err error
+	if j.last, err = time.ParseDuration(j.Last); err != nil || j.last <= 0 {
+		return fmt.Errorf("invalid Last %q", j.Last)
+	}
+	if j.every, err = time.ParseDuration(j.Every); err != nil || j.every < time.Minute {
+		return fmt.Errorf("invalid Every %q, must be at least 1m", j.Every)
+	}
+	if j.Simplify < 0 {
+		return errors.New("Simplify must not be negative")
+	}
+	j.target, err = j.newTarget()
+	return err
+}
+
+// newTarget returns the Target given by the settings of j.
+func (j *Job) newTarget() (Target, error) {
+	if j.Target == "" {
+		return nil, errors.New("Target is required")
+	}
+	if u, err := url.Parse(j.Target); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return newWebDAVTarget(u, j.WebDAV)
+	}
+	if fi, err := os.Stat(j.Target); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("Target %q is no directory", j.Target)
+	}
+	return dirTarget(j.Target), nil
+}
+
+// Run starts running every job right away and then periodically, until done
+// is closed.
+func (p *Publisher) Run(done <-chan struct{}) {
+	for _, j := range p.jobs {
+		go func(j Job) {
+			t := time.NewTicker(j.every)
+			defer t.Stop()
+			for {
+				if err := p.publish(j, time.Now()); err != nil {
+					p.logger.Printf("Publishing %s failed: %v", j.File, err)
+				}
+				select {
+				case <-done:
+					return
+				case <-t.C:
+				}
+			}
+		}(j)
+	}
+}
+
+// publish exports the positions selected by j as of now to its target.
+func (p *Publisher) publish(j Job, now time.Time) error {
+	tracks, err := storage.Tracks(p.store, storage.Query{
+		User:      j.User,
+		TrackerID: j.Tracker,
+		From:      now.Add(-j.last),
+		To:        now,
+	})
+	if err != nil {
+		return err
+	}
+	for i, t := range tracks {
+		tracks[i] = simplify(t, j.Simplify)
+	}
+	data, err := Encode(j.Format, strings.TrimSuffix(j.File, filepath.Ext(j.File)), tracks, now)
+	if err != nil {
+		return err
+	}
+	return j.target.Put(j.File, data)
+}
+
+// formats maps the export formats to their file extensions and content
+// types.
+var formats = map[string][2]string{
+	"geojson":  {".geojson", "application/geo+json"},
+	"gpx":      {".gpx", "application/gpx+xml"},
+	"kml":      {".kml", "application/vnd.google-earth.kml+xml"},
+	"kml-tour": {".kml", "application/vnd.google-earth.kml+xml"},
+	"gpkg":     {".gpkg", "application/geopackage+sqlite3"},
+	"shp":      {".shp.zip", "application/zip"},
+	"zip":      {".zip", "application/zip"},
+}
+
+// Formats returns the names of all export formats, sorted.
+func Formats() []string {
+	var l []string
+	for f := range formats {
+		l = append(l, f)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// FileType returns the file extension and the content type of format. ok
+// is false for unknown formats.
+func FileType(format string) (ext, contentType string, ok bool) {
+	f, ok := formats[format]
+	return f[0], f[1], ok
+}
+
+// Encode returns tracks in format, which is "geojson", "gpx", "kml",
+// "kml-tour", "gpkg", "shp" or "zip". name is the title of the document and
+// now its creation time.
+func Encode(format, name string, tracks [][]owntracks.LocationUpdate, now time.Time) ([]byte, error) {
+	switch format {
+	case "geojson":
+		return encodeGeoJSON(tracks)
+	case "gpx":
+		return encodeGPX(name, tracks, now)
+	case "kml", "kml-tour":
+		return EncodeKML(name, tracks, format == "kml-tour"), nil
+	case "gpkg":
+		return encodeGeoPackage(tracks, now)
+	case "shp":
+		return encodeShapefile(tracks, now)
+	case "zip":
+		return encodeBundle(name, tracks, now)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// dirTarget publishes to a local directory.
+type dirTarget string
+
+// Put writes the file through a temporary file, so that readers never see
+// a partial one.
+func (d dirTarget) Put(name string, data []byte) error {
+	f, err := os.CreateTemp(string(d), "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// CreateTemp creates the file only readable by its owner, but it is
+	// meant to be served
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(string(d), name))
+}
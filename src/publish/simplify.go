@@ -0,0 +1,59 @@
+package publish
+
+import (
+	"math"
+
+	"geo"
+	"owntracks"
+)
+
+// simplify returns the points of t that are needed to draw it with a
+// deviation of at most tolerance [m], using the Douglas-Peucker algorithm.
+// The kept points are unchanged, so they still carry their time and
+// altitude.
+func simplify(t []owntracks.LocationUpdate, tolerance float64) []owntracks.LocationUpdate {
+	if tolerance <= 0 || len(t) < 3 {
+		return t
+	}
+	keep := make([]bool, len(t))
+	keep[0], keep[len(t)-1] = true, true
+	type span struct{ first, last int }
+	stack := []span{{0, len(t) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		maxDist, maxIdx := 0.0, 0
+		for i := s.first + 1; i < s.last; i++ {
+			if d := crossTrackDistance(t[i], t[s.first], t[s.last]); d > maxDist {
+				maxDist, maxIdx = d, i
+			}
+		}
+		if maxDist > tolerance {
+			keep[maxIdx] = true
+			stack = append(stack, span{s.first, maxIdx}, span{maxIdx, s.last})
+		}
+	}
+	var s []owntracks.LocationUpdate
+	for i, k := range keep {
+		if k {
+			s = append(s, t[i])
+		}
+	}
+	return s
+}
+
+// crossTrackDistance returns the distance in [m] of p from the segment
+// between a and b, in an equirectangular projection, which is exact enough
+// for the short segments of a track.
+func crossTrackDistance(p, a, b owntracks.LocationUpdate) float64 {
+	k := math.Cos(a.Latitude * math.Pi / 180)
+	x := func(lu owntracks.LocationUpdate) float64 { return lu.Longitude * k }
+	px, py := x(p)-x(a), p.Latitude-a.Latitude
+	bx, by := x(b)-x(a), b.Latitude-a.Latitude
+	l := bx*bx + by*by
+	if l > 0 {
+		f := math.Max(0, math.Min(1, (px*bx+py*by)/l))
+		px, py = px-f*bx, py-f*by
+	}
+	return math.Hypot(px, py) * math.Pi / 180 * geo.EarthRadius
+}
@@ -0,0 +1,172 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// WebDAV holds the settings of a WebDAV target, like a Nextcloud folder.
+type WebDAV struct {
+	Username string
+	Password string
+	// ChunkSize is the size in bytes above which files are uploaded in
+	// chunks of that size. This needs a Nextcloud target URL below
+	// /remote.php/dav/files/<user>/. 0 always uploads files at once.
+	ChunkSize int
+	// Existing decides what happens to a file that already exists. With
+	// "replace", the default, it is overwritten, with "rename", it is kept
+	// under a name with the current time, and with "keep", the upload
+	// fails.
+	Existing string
+}
+
+// minChunkSize is the smallest chunk Nextcloud accepts, except for the last
+// one of a file.
+const minChunkSize = 5 << 20
+
+// newWebDAVTarget returns a Target uploading to the collection at u.
+func newWebDAVTarget(u *url.URL, o WebDAV) (Target, error) {
+	switch o.Existing {
+	case "":
+		o.Existing = "replace"
+	case "replace", "rename", "keep":
+	default:
+		return nil, fmt.Errorf("invalid WebDAV.Existing %q, must be replace, rename or keep", o.Existing)
+	}
+	t := &webdavTarget{WebDAV: o, client: &http.Client{Timeout: 5 * time.Minute}}
+	t.base = *u
+	if !strings.HasSuffix(t.base.Path, "/") {
+		t.base.Path += "/"
+	}
+	if o.ChunkSize > 0 {
+		if o.ChunkSize < minChunkSize {
+			return nil, fmt.Errorf("WebDAV.ChunkSize must be at least %d", minChunkSize)
+		}
+		const files = "/remote.php/dav/files/"
+		root, rest, ok := strings.Cut(t.base.Path, files)
+		user, _, _ := strings.Cut(rest, "/")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("chunked uploads need a Nextcloud URL below %s<user>/", files)
+		}
+		t.uploads = t.base
+		t.uploads.Path = root + "/remote.php/dav/uploads/" + user + "/"
+	}
+	return t, nil
+}
+
+type webdavTarget struct {
+	WebDAV
+	base    url.URL
+	uploads url.URL
+	client  *http.Client
+}
+
+func (t *webdavTarget) Put(name string, data []byte) error {
+	dest := t.base.JoinPath(name)
+	if t.Existing == "rename" {
+		ext := path.Ext(name)
+		version := strings.TrimSuffix(name, ext) + "." + time.Now().UTC().Format("20060102T150405Z") + ext
+		resp, err := t.do("MOVE", dest.String(), nil, map[string]string{
+			"Destination": t.base.JoinPath(version).String(),
+			"Overwrite":   "F",
+		})
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusNotFound && !success(resp) {
+			return fmt.Errorf("keeping %s as %s: %s", name, version, resp.Status)
+		}
+	}
+	if t.ChunkSize > 0 && len(data) > t.ChunkSize {
+		return t.putChunked(dest, data)
+	}
+	h := map[string]string{}
+	if t.Existing == "keep" {
+		h["If-None-Match"] = "*"
+	}
+	resp, err := t.do("PUT", dest.String(), data, h)
+	if err != nil {
+		return err
+	}
+	if !success(resp) {
+		return fmt.Errorf("uploading %s: %s", name, resp.Status)
+	}
+	return nil
+}
+
+// putChunked uploads data to dest with the chunked upload of Nextcloud.
+func (t *webdavTarget) putChunked(dest *url.URL, data []byte) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	dir := t.uploads.JoinPath("daisser-" + hex.EncodeToString(id))
+	h := map[string]string{"Destination": dest.String()}
+	resp, err := t.do("MKCOL", dir.String(), nil, h)
+	if err != nil {
+		return err
+	}
+	if !success(resp) {
+		return fmt.Errorf("starting upload of %s: %s", path.Base(dest.Path), resp.Status)
+	}
+	for n := 1; len(data) > 0; n++ {
+		size := t.ChunkSize
+		if size > len(data) {
+			size = len(data)
+		}
+		resp, err := t.do("PUT", dir.JoinPath(fmt.Sprintf("%05d", n)).String(), data[:size], h)
+		if err != nil {
+			return err
+		}
+		if !success(resp) {
+			t.do("DELETE", dir.String(), nil, nil)
+			return fmt.Errorf("uploading chunk %d of %s: %s", n, path.Base(dest.Path), resp.Status)
+		}
+		data = data[size:]
+	}
+	if t.Existing == "keep" {
+		h["Overwrite"] = "F"
+	}
+	resp, err = t.do("MOVE", dir.JoinPath(".file").String(), nil, h)
+	if err != nil {
+		return err
+	}
+	if !success(resp) {
+		t.do("DELETE", dir.String(), nil, nil)
+		return fmt.Errorf("finishing upload of %s: %s", path.Base(dest.Path), resp.Status)
+	}
+	return nil
+}
+
+// do sends a request with the body data and the headers h.
+func (t *webdavTarget) do(method, u string, data []byte, h map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if t.Username != "" {
+		req.SetBasicAuth(t.Username, t.Password)
+	}
+	for k, v := range h {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	return resp, nil
+}
+
+func success(resp *http.Response) bool {
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"api"
+	"auth"
+	"ingest"
+	"owntracks"
+	"publish"
+)
+
+// Config holds all settings of a Server. It is usually read from the
+// config.json file. Secrets like passwords can be given as references to
+// environment variables, files or commands, see ResolveSecrets.
+type Config struct {
+	MQTTHost       string
+	MQTTPort       uint16
+	MQTTUser       string
+	MQTTPassword   string
+	MQTTCAFile     string
+	UrlBase        string
+	DbDriver       string
+	DbFile         string
+	CacheWindow    Duration
+	Listen         string
+	StaticDir      string
+	AccessRules    []auth.AccessRule
+	TrustedProxies []string
+	AuthHeader     string
+	ReadTimeout    Duration
+	WriteTimeout   Duration
+	IdleTimeout    Duration
+	HandlerTimeout Duration
+	AccessLog      bool
+	// MapMatchURL is the base URL of an OSRM server used to snap tracks to
+	// the road network. Map matching is disabled if it is empty.
+	MapMatchURL     string
+	MapMatchProfile string
+	// PreferencesFile is where the preferences of the users are stored. They
+	// are lost on restart if it is empty.
+	PreferencesFile string
+	// DevicesFile is where the labels and flags of the devices are stored.
+	// They are lost on restart if it is empty.
+	DevicesFile string
+	// PlacesFile is where the places of the users are stored. They are lost
+	// on restart if it is empty.
+	PlacesFile string
+	// POIsFile is where the POIs of the users are stored. They are lost on
+	// restart if it is empty.
+	POIsFile string
+	// Protocols are the names of the enabled ingest protocols.
+	Protocols []string
+	// ProtocolOptions holds the settings of the protocols by name.
+	ProtocolOptions map[string]json.RawMessage
+	// Policies thin out the positions of chatty trackers and correct the
+	// timestamps of trackers with skewed clocks before they are stored.
+	// They are given by device name "<user>/<tracker>" or by
+	// protocol name.
+	Policies map[string]ingest.Policy
+	// Publish are the exports written periodically for static websites.
+	Publish []publish.Job
+	// RepublishPrefix is the MQTT topic tree accepted positions are
+	// republished to, as <prefix>/<user>/<tracker>, on the broker of the
+	// MQTT settings. Republishing is disabled if it is empty.
+	RepublishPrefix string
+	// RepublishRetained makes the broker keep the last republished
+	// position of every device for new subscribers.
+	RepublishRetained bool
+	// PollTokens maps the tokens that automation platforms like Zapier
+	// use for the /api/poll endpoints to the users whose data they get.
+	PollTokens map[string]string
+	// GeocodeURL is the base URL of a Nominatim server, or of a service
+	// with a compatible API, used by the address search of the map.
+	// Address search is disabled if it is empty.
+	GeocodeURL string
+	// GeocodeKey is the API key sent to the GeocodeURL service, so that it
+	// never has to be handed to browsers.
+	GeocodeKey string
+	// PlusCodes adds the Plus Code to position details and republished
+	// positions.
+	PlusCodes bool
+	// What3WordsKey is the what3words API key. If it is set, the
+	// what3words address is added to position details and republished
+	// positions.
+	What3WordsKey string
+	// What3WordsLanguage is the language of the what3words addresses, the
+	// default is "en".
+	What3WordsLanguage string
+	// RateLimits restrict how often clients may request the paths starting
+	// with their prefixes.
+	RateLimits []auth.RateLimit
+	// IdempotencyWindow is how long the HTTP ingest protocols remember the
+	// Idempotency-Key of requests, to answer retries with the original
+	// response. Idempotency keys are ignored if it is 0.
+	IdempotencyWindow Duration
+	// SpeedLimitURL is the interpreter endpoint of an Overpass server, used
+	// to compare recorded speeds with the speed limits in OpenStreetMap.
+	// Speeding reports are disabled if it is empty.
+	SpeedLimitURL string
+	// DeviceSetup is served as configuration file to new devices at
+	// /api/devices/<user>/<tracker>/config. The MQTT broker defaults to the
+	// one of the owntracks-mqtt protocol and the ids of the osmand protocol
+	// are added.
+	DeviceSetup api.DeviceSetup
+	// UrlBases are further prefixes the server is reachable under besides
+	// UrlBase, e.g. while moving it to another path. Redirects and cookies
+	// use the prefix of the request.
+	UrlBases []string
+	// TLSCertFile and TLSKeyFile make the HTTP listener serve HTTPS, with
+	// HTTP/2 for clients that support it.
+	TLSCertFile string
+	TLSKeyFile  string
+	// H2C enables HTTP/2 without TLS on the HTTP listener, for clients
+	// and proxies that speak it with prior knowledge.
+	H2C bool
+	// MaintenanceFile switches the server to maintenance mode while it
+	// exists, e.g. during backups. All requests but those of the ingest
+	// protocols are answered with 503, and received positions are queued
+	// in memory and stored when the file is removed. The file may contain
+	// the expected duration, like "10m", for the Retry-After header.
+	MaintenanceFile string
+	// SpoolFile is where positions are kept while they cannot be stored,
+	// e.g. while the database is down, and during maintenance. They are
+	// stored once the database is available again. Without SpoolFile,
+	// such positions are rejected, and during maintenance only kept in
+	// memory.
+	SpoolFile string
+	// ExportDir keeps the files of the export jobs of /api/exports until
+	// their download links expire. Export jobs are disabled if it is empty.
+	ExportDir string
+	// PasswordCost is the bcrypt cost of password hashes, between 4 and 31.
+	// Each step doubles the time to check a password. 0 selects the bcrypt
+	// default of 10.
+	PasswordCost int
+	// DeviceStatsFile is where the counters of the positions every device
+	// sent are written to every minute, see
+	// /api/devices/<user>/<tracker>/stats. They start over on restart if it
+	// is empty.
+	DeviceStatsFile string
+
+	// Logger receives all log output of the server.
+	Logger *log.Logger `json:"-"`
+}
+
+// OwnTracksListener returns the settings of the OwnTracks MQTT connection
+// given by the MQTT fields of c.
+func (c Config) OwnTracksListener() owntracks.Listener {
+	return owntracks.Listener{
+		Hostname: c.MQTTHost,
+		Port:     c.MQTTPort,
+		Username: c.MQTTUser,
+		Password: c.MQTTPassword,
+		CAFile:   c.MQTTCAFile,
+		UseTLS:   true,
+		ClientID: "daisser-server",
+	}
+}
+
+// DefaultConfig returns a Config with the defaults for all settings.
+func DefaultConfig() Config {
+	return Config{
+		DbDriver:          "memory",
+		CacheWindow:       Duration{24 * time.Hour},
+		Listen:            "fastcgi",
+		Protocols:         []string{"owntracks-mqtt"},
+		StaticDir:         "static",
+		ReadTimeout:       Duration{30 * time.Second},
+		WriteTimeout:      Duration{60 * time.Second},
+		IdleTimeout:       Duration{120 * time.Second},
+		HandlerTimeout:    Duration{30 * time.Second},
+		IdempotencyWindow: Duration{10 * time.Minute},
+		ExportDir:         filepath.Join(os.TempDir(), "daisser-exports"),
+	}
+}
+
+// Duration is a time.Duration that is stored as a string like "30s" in the
+// config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"owntracks"
+)
+
+const (
+	// maintenancePoll is how often the MaintenanceFile is checked.
+	maintenancePoll = 5 * time.Second
+	// maintenanceRetryAfter is sent as Retry-After if the MaintenanceFile
+	// does not give the expected duration.
+	maintenanceRetryAfter = 5 * time.Minute
+	// maxMaintenanceQueue is the number of positions queued during
+	// maintenance, further positions are rejected so that trackers retry.
+	maxMaintenanceQueue = 100000
+)
+
+var errMaintenanceQueueFull = errors.New("maintenance queue is full")
+
+// maintenance holds the state of the maintenance mode and the positions
+// received meanwhile, unless they are spooled.
+type maintenance struct {
+	mu         sync.Mutex
+	active     bool
+	retryAfter time.Duration
+	queue      []owntracks.LocationUpdate
+}
+
+// inMaintenance reports whether the server is in maintenance mode, and the
+// time clients should wait.
+func (s *Server) inMaintenance() (bool, time.Duration) {
+	s.maintenance.mu.Lock()
+	defer s.maintenance.mu.Unlock()
+	return s.maintenance.active, s.maintenance.retryAfter
+}
+
+// checkMaintenance enters maintenance mode if the MaintenanceFile exists and
+// leaves it otherwise. On leaving, the queued positions are stored.
+func (s *Server) checkMaintenance() {
+	if s.config.MaintenanceFile == "" {
+		return
+	}
+	b, err := os.ReadFile(s.config.MaintenanceFile)
+	active := err == nil
+	retryAfter := maintenanceRetryAfter
+	if d, err := time.ParseDuration(strings.TrimSpace(string(b))); err == nil && d > 0 {
+		retryAfter = d
+	}
+
+	m := &s.maintenance
+	m.mu.Lock()
+	leaving := m.active && !active
+	if active && !m.active {
+		s.logger.Printf("Entering maintenance mode, %s exists", s.config.MaintenanceFile)
+	}
+	m.active, m.retryAfter = active, retryAfter
+	queue := m.queue
+	if leaving {
+		m.queue = nil
+	}
+	m.mu.Unlock()
+	if !leaving {
+		return
+	}
+	// requests are served again while the queue is stored
+	s.logger.Printf("Leaving maintenance mode, storing %d queued positions", len(queue))
+	for _, lu := range queue {
+		err := s.store.InsertPosition(lu)
+		if err != nil && s.spool != nil {
+			s.logger.Printf("Storing queued position of %s/%s failed, spooling it: %v", lu.User, lu.TrackerID, err)
+			err = s.spool.Add(lu)
+		}
+		if err != nil {
+			s.logger.Printf("Storing queued position of %s/%s failed: %v", lu.User, lu.TrackerID, err)
+		}
+	}
+	s.replaySpool()
+}
+
+// watchMaintenance checks the MaintenanceFile until the server is closed.
+func (s *Server) watchMaintenance() {
+	if s.config.MaintenanceFile == "" {
+		return
+	}
+	go func() {
+		t := time.NewTicker(maintenancePoll)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-t.C:
+				s.checkMaintenance()
+			}
+		}
+	}()
+}
+
+// queuePosition queues lu if the server is in maintenance mode and reports
+// whether it did. With a spool, lu is queued there.
+func (s *Server) queuePosition(lu owntracks.LocationUpdate) (bool, error) {
+	m := &s.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.active {
+		return false, nil
+	}
+	if s.spool != nil {
+		return true, s.spool.Add(lu)
+	}
+	if len(m.queue) >= maxMaintenanceQueue {
+		return true, errMaintenanceQueueFull
+	}
+	m.queue = append(m.queue, lu)
+	return true, nil
+}
+
+// maintenanceFilter answers all requests but those of the ingest protocols
+// with 503 Service Unavailable during maintenance.
+func (s *Server) maintenanceFilter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		active, retryAfter := s.inMaintenance()
+		if active && !s.isIngest(r) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			s.errorPage(w, r, http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isIngest reports whether r goes to a route of an ingest protocol.
+func (s *Server) isIngest(r *http.Request) bool {
+	_, pattern := s.mux.Handler(r)
+	return s.ingestPatterns[pattern]
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	"middleware"
+)
+
+// The map page can be installed as a Progressive Web App. The web app
+// manifest and the service worker are generated, so that they always match
+// the URL base of the request and the files in StaticDir.
+
+type manifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+type manifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	StartURL        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	BackgroundColor string         `json:"background_color"`
+	ThemeColor      string         `json:"theme_color"`
+	Icons           []manifestIcon `json:"icons"`
+}
+
+// manifestIconSizes are the sizes of the favicon-<size>.png files in
+// assets/img.
+var manifestIconSizes = []int{76, 120, 152, 196}
+
+// serveManifest serves the web app manifest of the map page.
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request) {
+	m := manifest{
+		Name:            "daisser",
+		ShortName:       "daisser",
+		StartURL:        middleware.Base(r) + "/",
+		Scope:           middleware.Base(r) + "/",
+		Display:         "standalone",
+		BackgroundColor: "#ffffff",
+		ThemeColor:      "#000000",
+	}
+	for _, size := range manifestIconSizes {
+		sz := strconv.Itoa(size)
+		m.Icons = append(m.Icons, manifestIcon{
+			Src:   middleware.Base(r) + "/assets/img/favicon-" + sz + ".png",
+			Sizes: sz + "x" + sz,
+			Type:  "image/png",
+		})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Write(b)
+}
+
+// serviceWorker caches the static assets on installation and serves them
+// from the cache. The map page and the latest positions are fetched from the
+// network and served from the cache only while offline. The cache name
+// contains the start time of the server, so that every restart replaces
+// stale assets.
+var serviceWorker = template.Must(template.New("sw.js").Parse(`const CACHE = "daisser-{{.Version}}";
+const ASSETS = {{.Assets}};
+const NETWORK_FIRST = ["", "api/last", "api/positions"];
+
+self.addEventListener("install", event => {
+  event.waitUntil(caches.open(CACHE).then(cache => cache.addAll(ASSETS)));
+});
+
+self.addEventListener("activate", event => {
+  event.waitUntil(caches.keys().then(keys => Promise.all(
+    keys.filter(key => key !== CACHE).map(key => caches.delete(key))
+  )));
+});
+
+self.addEventListener("fetch", event => {
+  if (event.request.method !== "GET") {
+    return;
+  }
+  const path = new URL(event.request.url).pathname.substring(new URL(self.registration.scope).pathname.length);
+  if (NETWORK_FIRST.includes(path)) {
+    event.respondWith(fetch(event.request).then(response => {
+      const copy = response.clone();
+      caches.open(CACHE).then(cache => cache.put(event.request, copy));
+      return response;
+    }).catch(() => caches.match(event.request)));
+    return;
+  }
+  event.respondWith(caches.match(event.request).then(cached => cached || fetch(event.request)));
+});
+`))
+
+// serveServiceWorker serves the service worker of the map page.
+func (s *Server) serveServiceWorker(w http.ResponseWriter, r *http.Request) {
+	assets := []string{"./"}
+	root := filepath.Join(s.config.StaticDir, "assets")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.config.StaticDir, path)
+		if err != nil {
+			return err
+		}
+		assets = append(assets, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		s.logger.Printf("Error listing the assets for the service worker: %v", err)
+	}
+	list, _ := json.Marshal(assets)
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	serviceWorker.Execute(w, struct {
+		Version int64
+		Assets  string
+	}{s.startTime.Unix(), string(list)})
+}
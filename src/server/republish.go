@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"owntracks"
+)
+
+// RepublishedPosition is the JSON schema of the positions republished to
+// MQTT, under the topic <RepublishPrefix>/<user>/<tracker>.
+type RepublishedPosition struct {
+	User    string    `json:"user"`
+	Tracker string    `json:"tracker"`
+	Client  string    `json:"client"`
+	Time    time.Time `json:"time"`
+	// Latitude and Longitude are in degrees.
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	// Accuracy and Altitude are in [m], Velocity in [km/h], Course in
+	// degrees and Battery in percent.
+	Accuracy int `json:"acc"`
+	Altitude int `json:"alt"`
+	Velocity int `json:"vel"`
+	Course   int `json:"cog"`
+	Battery  int `json:"batt"`
+	// PlusCode and What3Words are only set if enabled in the config.
+	PlusCode   string `json:"pluscode,omitempty"`
+	What3Words string `json:"w3w,omitempty"`
+}
+
+// checkRepublishPrefix makes sure that republished positions are not
+// received again by the OwnTracks listener.
+func checkRepublishPrefix(prefix string) error {
+	if prefix == "owntracks" || strings.HasPrefix(prefix, "owntracks/") {
+		return errors.New("RepublishPrefix must not be below owntracks/")
+	}
+	if strings.ContainsAny(prefix, "+#") {
+		return errors.New("RepublishPrefix must not contain wildcards")
+	}
+	return nil
+}
+
+// republish connects to the MQTT broker of the config and publishes every
+// accepted position to it until the server is closed. Positions are dropped
+// while the broker does not keep up.
+func (s *Server) republish() error {
+	l := s.config.OwnTracksListener()
+	l.ClientID = "daisser-republish"
+	if err := l.Dial(); err != nil {
+		return err
+	}
+	s.logger.Printf("Republishing positions to %s under %s/", l.BrokerAddress(), s.config.RepublishPrefix)
+	updates, cancel := s.api.Broker.Subscribe("")
+	go func() {
+		defer l.Disconnect()
+		defer cancel()
+		for {
+			select {
+			case <-s.done:
+				return
+			case lu, ok := <-updates:
+				if !ok {
+					return
+				}
+				if err := l.Publish(s.republishedMessage(lu), s.config.RepublishRetained); err != nil {
+					s.logger.Printf("Republishing position of %s/%s failed: %v", lu.User, lu.TrackerID, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Server) republishedMessage(lu owntracks.LocationUpdate) owntracks.Message {
+	rp := RepublishedPosition{
+		User:      lu.User,
+		Tracker:   lu.TrackerID,
+		Client:    lu.ClientID,
+		Time:      lu.T.UTC(),
+		Latitude:  lu.Latitude,
+		Longitude: lu.Longitude,
+		Accuracy:  lu.Accuracy,
+		Altitude:  lu.Altitude,
+		Velocity:  lu.Velocity,
+		Course:    lu.Course,
+		Battery:   lu.Battery,
+	}
+	rp.PlusCode, rp.What3Words = s.api.LocationCodes(lu.Latitude, lu.Longitude)
+	b, _ := json.Marshal(rp)
+	return owntracks.Message{
+		Topic:   s.config.RepublishPrefix + "/" + lu.User + "/" + lu.TrackerID,
+		Payload: b,
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+// ResolveSecrets replaces the secret references among the settings of c by
+// their values, so that passwords, API keys and DSNs need not be kept in
+// the config file. A reference is a string value of one of the forms
+//
+//	env:NAME         the environment variable NAME
+//	file:PATH        the content of the file at PATH, like a Docker secret
+//	cmd:COMMAND ARGS the output of the command, like "pass show mqtt"
+//
+// A trailing line break of files and command output is removed. All string
+// settings are resolved, also in lists, maps and ProtocolOptions.
+func (c *Config) ResolveSecrets() error {
+	return resolveValue(reflect.ValueOf(c).Elem())
+}
+
+// resolveValue resolves the secret references in v, which must be settable.
+func resolveValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := resolveSecret(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				if err := resolveValue(f); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Slice:
+		if raw, ok := v.Interface().(json.RawMessage); ok {
+			b, err := resolveJSON(raw)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			if err := resolveValue(e); err != nil {
+				return err
+			}
+			nk := reflect.New(k.Type()).Elem()
+			nk.Set(k)
+			if err := resolveValue(nk); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, reflect.Value{})
+			v.SetMapIndex(nk, e)
+		}
+	}
+	return nil
+}
+
+// resolveJSON resolves the secret references among the strings of the JSON
+// document raw.
+func resolveJSON(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 || !strings.Contains(string(raw), ":") {
+		return raw, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	var resolve func(v interface{}) (interface{}, error)
+	resolve = func(v interface{}) (interface{}, error) {
+		var err error
+		switch v := v.(type) {
+		case string:
+			return resolveSecret(v)
+		case []interface{}:
+			for i := range v {
+				if v[i], err = resolve(v[i]); err != nil {
+					return nil, err
+				}
+			}
+		case map[string]interface{}:
+			for k := range v {
+				if v[k], err = resolve(v[k]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return v, nil
+	}
+	doc, err := resolve(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// resolveSecret returns the value of the secret reference s, or s itself if
+// it is no reference. Errors name the reference but never the secret.
+func resolveSecret(s string) (string, error) {
+	kind, ref, ok := strings.Cut(s, ":")
+	if !ok {
+		return s, nil
+	}
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("secret %q: environment variable not set", s)
+		}
+		return v, nil
+	case "file":
+		b, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %v", s, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case "cmd":
+		args := strings.Fields(ref)
+		if len(args) == 0 {
+			return "", fmt.Errorf("secret %q: no command", s)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		b, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %v", s, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return s, nil
+}
@@ -0,0 +1,584 @@
+// Package server combines the parts of daisser into a HTTP server that can
+// run on its own, as a FastCGI process, or be embedded into other programs.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"api"
+	"auth"
+	"i18n"
+	"ingest"
+	"middleware"
+	"owntracks"
+	"publish"
+	"storage"
+)
+
+// shutdownGrace is the least time running requests get to finish on
+// shutdown, also if the handlers have no timeout.
+const shutdownGrace = 10 * time.Second
+
+// Server is the primary datastructure for daisser. Internally it combines a
+// HTTP server or FastCGI process with an Owntracks listener
+type Server struct {
+	config    Config
+	logger    *log.Logger
+	mux       *http.ServeMux
+	handler   http.Handler
+	done      chan struct{}
+	closeOnce sync.Once
+	// running counts the calls of Run that have not returned, Close waits
+	// for them before it closes the storage. runMu orders them with
+	// closing done.
+	running   sync.WaitGroup
+	runMu     sync.Mutex
+	startTime time.Time
+	store     storage.Store
+	prefs     *storage.PreferenceStore
+	protocols []ingest.Protocol
+	// ingestPatterns are the patterns of the routes of the protocols.
+	ingestPatterns map[string]bool
+	policies       *ingest.Policies
+	privacy        *ingest.Privacy
+	publisher      *publish.Publisher
+	auth           *auth.Authenticator
+	api            *api.API
+
+	maintenance maintenance
+	spool       *ingest.Spool
+	stats       *storage.DeviceStatsStore
+
+	cachedTemplates map[string]*template.Template
+	cachedMutex     sync.Mutex
+}
+
+// New sets up a Server for c. The returned Server serves HTTP requests right
+// away, but only receives location updates after calling Listen or Run.
+func New(c Config) (*Server, error) {
+	if c.StaticDir == "" {
+		c.StaticDir = "static"
+	}
+	if c.Logger == nil {
+		c.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if c.DbDriver == "" {
+		c.DbDriver = "memory"
+	}
+	store, err := storage.Open(c.DbDriver, c.DbFile)
+	if err != nil {
+		return nil, err
+	}
+	if c.CacheWindow.Duration > 0 {
+		if store, err = storage.NewCache(store, c.CacheWindow.Duration); err != nil {
+			return nil, err
+		}
+	}
+	prefs, err := storage.OpenPreferences(c.PreferencesFile)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := storage.OpenDeviceInfos(c.DevicesFile)
+	if err != nil {
+		return nil, err
+	}
+	places, err := storage.OpenPlaces(c.PlacesFile)
+	if err != nil {
+		return nil, err
+	}
+	pois, err := storage.OpenPOIs(c.POIsFile)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := storage.OpenDeviceStats(c.DeviceStatsFile)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := ingest.NewPolicies(c.Policies)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := store.Devices()
+	if err != nil {
+		return nil, err
+	}
+	policies.Seed(stored)
+	s := &Server{
+		config:          c,
+		logger:          c.Logger,
+		mux:             http.NewServeMux(),
+		done:            make(chan struct{}),
+		startTime:       time.Now(),
+		store:           store,
+		prefs:           prefs,
+		policies:        policies,
+		privacy:         &ingest.Privacy{Preferences: prefs, Places: places},
+		stats:           stats,
+		ingestPatterns:  make(map[string]bool),
+		cachedTemplates: make(map[string]*template.Template),
+	}
+	if err := auth.CheckPasswordCost(c.PasswordCost); err != nil {
+		return nil, err
+	}
+	s.auth = &auth.Authenticator{
+		Header:       c.AuthHeader,
+		PasswordCost: c.PasswordCost,
+		Logger:       s.logger,
+	}
+	s.api = &api.API{
+		Store:       s.store,
+		Logger:      s.logger,
+		NotFound:    s.NotFound,
+		Preferences: s.prefs,
+		DeviceInfos: devices,
+		PlaceStore:  places,
+		POIStore:    pois,
+		Broker:      api.NewBroker(),
+		PollTokens:  c.PollTokens,
+		Stats:       stats,
+	}
+	s.api.Import = s.sink("gpx")
+	if c.RepublishPrefix != "" {
+		if err := checkRepublishPrefix(c.RepublishPrefix); err != nil {
+			return nil, err
+		}
+	}
+	if s.publisher, err = publish.NewPublisher(store, c.Publish, s.logger); err != nil {
+		return nil, err
+	}
+	if c.ExportDir != "" {
+		if s.api.Exports, err = api.NewExports(c.ExportDir); err != nil {
+			return nil, err
+		}
+	}
+	if c.MapMatchURL != "" {
+		s.api.Matcher = api.NewMatcher(c.MapMatchURL, c.MapMatchProfile)
+	}
+	if c.GeocodeURL != "" {
+		s.api.Geocoder = api.NewGeocoder(c.GeocodeURL, c.GeocodeKey)
+	}
+	if c.SpeedLimitURL != "" {
+		s.api.SpeedLimits = api.NewSpeedLimits(c.SpeedLimitURL)
+	}
+	s.api.PlusCodes = c.PlusCodes
+	if c.What3WordsKey != "" {
+		s.api.What3Words = api.NewWhat3Words(c.What3WordsKey, c.What3WordsLanguage)
+	}
+
+	var mw []middleware.Middleware
+	if c.AccessLog {
+		mw = append(mw, middleware.Logging(s.logger))
+	}
+	mw = append(mw, middleware.Compress)
+	// streams must not be cut off by the handler timeout
+	stream := middleware.NewRouter(s.mux, mw...)
+	if c.HandlerTimeout.Duration > 0 {
+		mw = append(mw, middleware.Timeout(c.HandlerTimeout.Duration))
+	}
+	root := middleware.NewRouter(s.mux, mw...)
+	protected := root.Group(s.auth.Middleware)
+
+	// default access
+	protected.HandleFunc("/", s.DefaultHandle)
+	s.api.RegisterRoutes(protected)
+	protected.Handle("/debug/vars", expvar.Handler())
+	protected.HandleFunc("/debug/info", s.serveDebugInfo)
+	s.api.RegisterGrafanaRoutes(protected)
+	s.api.RegisterImportRoutes(stream.Group(s.auth.Middleware))
+	s.api.RegisterPollRoutes(root)
+	// downloads are streamed and may be resumed
+	s.api.RegisterDownloadRoutes(stream)
+	s.auth.RegisterRoutes(root)
+	root.Handle("/assets/", http.FileServer(http.Dir(c.StaticDir)))
+	root.HandleFunc("/login", s.serveLogin)
+	stream.HandleFunc("/live/", s.serveLive)
+	root.HandleFunc("/manifest.webmanifest", s.serveManifest)
+	root.HandleFunc("/sw.js", s.serveServiceWorker)
+
+	ingestRoutes := root.Recording(s.ingestPatterns)
+	if c.IdempotencyWindow.Duration > 0 {
+		ingestRoutes = ingestRoutes.Group(ingest.NewIdempotency(c.IdempotencyWindow.Duration).Middleware)
+	}
+	for _, name := range c.Protocols {
+		p, err := ingest.New(name, ingest.Settings{
+			Logger:  s.logger,
+			Options: s.protocolOptions(name),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if h, ok := p.(ingest.Handler); ok {
+			h.RegisterRoutes(ingestRoutes, s.sink(name))
+		}
+		s.protocols = append(s.protocols, p)
+		s.api.Protocols = append(s.api.Protocols, p.Name())
+		switch p.(type) {
+		case *ingest.OwnTracksMQTT, *ingest.MQTTJSON:
+			s.api.MQTT = true
+		}
+	}
+	s.api.DeviceSetup = deviceSetup(c, s.protocols)
+
+	h, err := auth.AccessFilter(s.mux, c.AccessRules, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	if h, err = auth.RateLimiter(h, c.RateLimits, s.logger); err != nil {
+		return nil, err
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return nil, errors.New("TLSCertFile and TLSKeyFile must be set together")
+	}
+	if c.AuthHeader != "" && len(c.TrustedProxies) == 0 {
+		return nil, errors.New("AuthHeader requires TrustedProxies to be set")
+	}
+	if h, err = auth.TrustProxies(h, c.TrustedProxies); err != nil {
+		return nil, err
+	}
+	h = s.maintenanceFilter(h)
+	if c.UrlBase != "" || len(c.UrlBases) > 0 {
+		bases := []string{strings.TrimSuffix(c.UrlBase, "/")}
+		for _, b := range c.UrlBases {
+			bases = append(bases, strings.TrimSuffix(b, "/"))
+		}
+		m := http.NewServeMux()
+		m.Handle("/", middleware.StripBase(h, bases))
+		h = m
+	}
+	s.handler = h
+	if c.SpoolFile != "" {
+		if s.spool, err = ingest.OpenSpool(c.SpoolFile, s.logger); err != nil {
+			return nil, err
+		}
+		if n := s.spool.Len(); n > 0 {
+			s.logger.Printf("%d positions wait in the spool %s", n, c.SpoolFile)
+		}
+	}
+	s.checkMaintenance()
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Close stops receiving location updates, makes Run return and closes the
+// storage once Run has finished the running requests.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.runMu.Lock()
+		close(s.done)
+		s.runMu.Unlock()
+		s.api.Broker.Close()
+		s.running.Wait()
+		if err := s.store.Close(); err != nil {
+			s.logger.Printf("Error closing the storage: %v", err)
+		}
+		if s.spool != nil {
+			s.spool.Close()
+		}
+		if err := s.stats.Flush(); err != nil {
+			s.logger.Printf("Error writing the device stats: %v", err)
+		}
+	})
+}
+
+// T returns a html/template. All compiled templates are cached.
+// The template must compile, otherwise this method will panic.
+func (s *Server) T(name string) *template.Template {
+	s.cachedMutex.Lock()
+	defer s.cachedMutex.Unlock()
+
+	if t, ok := s.cachedTemplates[name]; ok {
+		return t
+	}
+
+	t := template.Must(template.New(name).Funcs(template.FuncMap{
+		"T": i18n.T,
+	}).ParseFiles(
+		filepath.Join(s.config.StaticDir, name),
+	))
+	s.cachedTemplates[name] = t
+
+	return t
+}
+
+// templateData is passed to the templates run by runTemplate.
+type templateData struct {
+	// Lang is the language of the page, texts are translated with
+	// {{ T .Lang "key" }}.
+	Lang        string
+	Flashes     []string
+	Preferences storage.Preferences
+	// Status is the status code of the response.
+	Status int
+	// Base is the URL base of the request.
+	Base string
+}
+
+// runTemplate executes the template named name on w.
+func (s *Server) runTemplate(w http.ResponseWriter, r *http.Request, name string) {
+	s.renderTemplate(w, r, name, http.StatusOK)
+}
+
+// renderTemplate executes the template named name on w, with the status
+// code. If that fails, the error page is sent instead.
+func (s *Server) renderTemplate(w http.ResponseWriter, r *http.Request, name string, code int) {
+	prefs := s.prefs.Get(auth.User(r))
+	data := templateData{
+		Lang:        i18n.Negotiate(prefs.Language, r.Header.Get("Accept-Language")),
+		Preferences: prefs,
+		Status:      code,
+		Base:        middleware.Base(r),
+	}
+	for _, f := range auth.Flashes(w, r) {
+		data.Flashes = append(data.Flashes, i18n.T(data.Lang, f))
+	}
+	w.Header().Set("Content-Language", data.Lang)
+	w.Header().Add("Vary", "Accept-Language")
+	buf := new(bytes.Buffer)
+	if err := s.T(name).Execute(buf, data); err != nil {
+		s.logger.Printf("Error executing template %s: %v", name, err)
+		if name == "error.html" {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		s.renderTemplate(w, r, "error.html", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	buf.WriteTo(w)
+}
+
+func (s *Server) serveLogin(w http.ResponseWriter, r *http.Request) {
+	s.runTemplate(w, r, "signin.html")
+}
+
+func (s *Server) serveMap(w http.ResponseWriter, r *http.Request) {
+	s.runTemplate(w, r, "bootleaf.html")
+}
+
+// serveLive serves the page of a live share at /live/<token> and its event
+// stream at /live/<token>/events.
+func (s *Server) serveLive(w http.ResponseWriter, r *http.Request) {
+	token, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/live/"), "/")
+	sh, ok := s.api.LiveShare(token)
+	switch {
+	case !ok:
+		s.NotFound(w, r)
+	case sub == "events":
+		s.api.LiveEvents(w, r, sh)
+	case sub == "":
+		s.runTemplate(w, r, "live.html")
+	default:
+		s.NotFound(w, r)
+	}
+}
+
+// NotFound answers requests for unknown paths.
+func (s *Server) NotFound(w http.ResponseWriter, r *http.Request) {
+	s.logger.Printf("404 Not found: %s %s", r.Method, r.URL.Path)
+	s.errorPage(w, r, http.StatusNotFound)
+}
+
+// errorPage replies with the status code, as JSON error to requests of the
+// API and as error page otherwise.
+func (s *Server) errorPage(w http.ResponseWriter, r *http.Request, code int) {
+	if isAPIRequest(r) {
+		api.Error(w, http.StatusText(code), code)
+		return
+	}
+	// static directories of older versions lack the template
+	if _, err := os.Stat(filepath.Join(s.config.StaticDir, "error.html")); err != nil {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	s.renderTemplate(w, r, "error.html", code)
+}
+
+// isAPIRequest reports whether r is meant for a program rather than a
+// browser.
+func isAPIRequest(r *http.Request) bool {
+	for _, prefix := range []string{"/api/", "/ingest/", "/grafana/", "/debug/"} {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// serveDebugInfo serves the uptime and the working directory of the server,
+// for authenticated users only.
+func (s *Server) serveDebugInfo(w http.ResponseWriter, r *http.Request) {
+	pwd, _ := os.Getwd()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Started at %s\nRunning for %s\n", s.startTime.String(), time.Since(s.startTime))
+	fmt.Fprintf(w, "cwd: %s\n", pwd)
+}
+
+func (s *Server) DefaultHandle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		s.serveMap(w, r)
+	} else {
+		s.NotFound(w, r)
+	}
+	if _, err := os.Stat("killfile"); !os.IsNotExist(err) {
+		s.logger.Println("found fillfile, quitting now")
+		os.Remove("killfile")
+		// Close waits for the running requests, this one included
+		go s.Close()
+	}
+}
+
+// protocolOptions returns the options for the ingest protocol name. The
+// options of "owntracks-mqtt" default to the MQTT settings of the config.
+func (s *Server) protocolOptions(name string) json.RawMessage {
+	if o, ok := s.config.ProtocolOptions[name]; ok || name != "owntracks-mqtt" {
+		return o
+	}
+	o, _ := json.Marshal(s.config.OwnTracksListener())
+	return o
+}
+
+// deviceSetup completes the DeviceSetup of c with the settings of the
+// protocols.
+func deviceSetup(c Config, protocols []ingest.Protocol) *api.DeviceSetup {
+	setup := c.DeviceSetup
+	setup.OsmAndIDs = make(map[string]string)
+	for _, p := range protocols {
+		switch p := p.(type) {
+		case *ingest.OwnTracksMQTT:
+			if setup.MQTTHost == "" {
+				setup.MQTTHost = p.Listener.Hostname
+				setup.MQTTPort = p.Listener.Port
+				setup.MQTTTLS = p.Listener.CAFile != ""
+			}
+		case *ingest.OsmAnd:
+			for id, device := range p.Devices {
+				setup.OsmAndIDs[device] = id
+			}
+		}
+	}
+	return &setup
+}
+
+// sink returns the ingest.Sink of the protocol with the given name. The
+// policies apply first, so that privacy rules see corrected timestamps.
+func (s *Server) sink(protocol string) ingest.Sink {
+	return s.policies.Filter(protocol, s.privacy.Filter(s.accept))
+}
+
+// accept stores the positions that passed the policies and privacy rules.
+func (s *Server) accept(lu owntracks.LocationUpdate) error {
+	name := storage.DeviceName(lu)
+	lu, err := ingest.Prepare(lu)
+	if err == nil {
+		err = s.storePosition(lu)
+	}
+	if err != nil {
+		if lu.User != "" {
+			s.stats.Rejected(name, time.Now(), err)
+		}
+		return err
+	}
+	s.stats.Accepted(name, time.Now())
+	s.api.Broker.Publish(lu)
+	return nil
+}
+
+// Listen starts all ingest protocols that maintain their own connections.
+func (s *Server) Listen() error {
+	for _, p := range s.protocols {
+		if l, ok := p.(ingest.Listener); ok {
+			if err := l.Listen(s.sink(p.Name()), s.done); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run receives location updates, publishes the configured exports and serves
+// HTTP requests on the address given in the config, or as FastCGI process if
+// that is "fastcgi". With TLS, HTTP/2 is negotiated, without only if H2C is
+// enabled. It returns when the server is closed or serving fails.
+func (s *Server) Run() error {
+	s.runMu.Lock()
+	select {
+	case <-s.done:
+		s.runMu.Unlock()
+		return nil
+	default:
+	}
+	s.running.Add(1)
+	s.runMu.Unlock()
+	defer s.running.Done()
+
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	s.publisher.Run(s.done)
+	s.watchMaintenance()
+	s.watchSpool()
+	s.watchStats()
+	if s.config.RepublishPrefix != "" {
+		if err := s.republish(); err != nil {
+			return err
+		}
+	}
+
+	errc := make(chan error, 1)
+	if s.config.Listen == "fastcgi" {
+		go func() {
+			errc <- fcgi.Serve(nil, s)
+		}()
+		select {
+		case <-s.done:
+			return nil
+		case err := <-errc:
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Addr:         s.config.Listen,
+		Handler:      s,
+		ReadTimeout:  s.config.ReadTimeout.Duration,
+		WriteTimeout: s.config.WriteTimeout.Duration,
+		IdleTimeout:  s.config.IdleTimeout.Duration,
+		Protocols:    new(http.Protocols),
+	}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(s.config.H2C)
+	go func() {
+		if s.config.TLSCertFile != "" {
+			errc <- srv.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
+			return
+		}
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case <-s.done:
+		ctx, cancel := context.WithTimeout(context.Background(), max(s.config.HandlerTimeout.Duration, shutdownGrace))
+		defer cancel()
+		return srv.Shutdown(ctx)
+	case err := <-errc:
+		return err
+	}
+}
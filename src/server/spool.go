@@ -0,0 +1,66 @@
+package server
+
+import (
+	"time"
+
+	"owntracks"
+)
+
+// spoolRetry is how often storing the spooled positions is retried.
+const spoolRetry = 10 * time.Second
+
+// storePosition stores lu, or queues it during maintenance. With a spool,
+// lu is spooled if storing fails, or if earlier positions are still
+// spooled, so that the order is kept.
+func (s *Server) storePosition(lu owntracks.LocationUpdate) error {
+	if queued, err := s.queuePosition(lu); queued {
+		return err
+	}
+	if s.spool == nil {
+		return s.store.InsertPosition(lu)
+	}
+	if s.spool.Len() == 0 {
+		err := s.store.InsertPosition(lu)
+		if err == nil {
+			return nil
+		}
+		s.logger.Printf("Storing position of %s/%s failed, spooling it: %v", lu.User, lu.TrackerID, err)
+	}
+	return s.spool.Add(lu)
+}
+
+// replaySpool stores the spooled positions.
+func (s *Server) replaySpool() {
+	if s.spool == nil || s.spool.Len() == 0 {
+		return
+	}
+	n, err := s.spool.Replay(s.store)
+	if n > 0 {
+		s.logger.Printf("Stored %d spooled positions, %d left", n, s.spool.Len())
+	}
+	if err != nil {
+		s.logger.Printf("Storing spooled positions failed: %v", err)
+	}
+}
+
+// watchSpool retries storing the spooled positions outside of maintenance
+// until the server is closed.
+func (s *Server) watchSpool() {
+	if s.spool == nil {
+		return
+	}
+	go func() {
+		t := time.NewTicker(spoolRetry)
+		defer t.Stop()
+		for {
+			if active, _ := s.inMaintenance(); !active {
+				s.replaySpool()
+			}
+			select {
+			case <-s.done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
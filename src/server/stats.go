@@ -0,0 +1,28 @@
+package server
+
+import "time"
+
+// statsFlush is how often the device stats are written to their file.
+const statsFlush = time.Minute
+
+// watchStats writes the device stats periodically until the server is
+// closed, which writes them a last time.
+func (s *Server) watchStats() {
+	if s.config.DeviceStatsFile == "" {
+		return
+	}
+	go func() {
+		t := time.NewTicker(statsFlush)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-t.C:
+				if err := s.stats.Flush(); err != nil {
+					s.logger.Printf("Error writing the device stats: %v", err)
+				}
+			}
+		}
+	}()
+}
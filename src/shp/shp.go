@@ -0,0 +1,228 @@
+// Package shp writes vector layers as ESRI Shapefiles, for GIS systems that
+// accept nothing else. Only points and polylines in WGS 84 are supported,
+// which suits positions and tracks. The attributes are stored in dBase III
+// files encoded as UTF-8.
+package shp
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Shape types of a Layer.
+const (
+	Point    = 1
+	PolyLine = 3
+)
+
+// prj is the coordinate system WGS 84 in the WKT dialect of ESRI.
+const prj = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// Field is an attribute of the features of a Layer. Type is 'C' for text
+// and 'N' for numbers, Length the width of the values, and Decimals the
+// digits after the decimal point of numbers.
+type Field struct {
+	Name     string
+	Type     byte
+	Length   int
+	Decimals int
+}
+
+// Feature is a feature of a Layer. Coordinates are longitude and latitude,
+// one pair for points. Values holds the attributes in the order of the
+// fields, they are strings or time.Time for text fields and int or float64
+// for numbers.
+type Feature struct {
+	Coordinates [][2]float64
+	Values      []interface{}
+}
+
+// Layer is a shapefile of features with the same shape type and fields.
+type Layer struct {
+	Name      string
+	ShapeType int
+	Fields    []Field
+	Features  []Feature
+}
+
+// Encode returns a zip archive with the files .shp, .shx, .dbf, .prj and
+// .cpg of every layer, created at now.
+func Encode(layers []Layer, now time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, l := range layers {
+		if l.Name == "" || strings.ContainsAny(l.Name, `/\`) {
+			return nil, fmt.Errorf("shp: invalid layer name %q", l.Name)
+		}
+		if l.ShapeType != Point && l.ShapeType != PolyLine {
+			return nil, fmt.Errorf("shp: unsupported shape type %d", l.ShapeType)
+		}
+		shp, shx := l.shapes()
+		dbf, err := l.table(now)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range []struct {
+			ext  string
+			data []byte
+		}{
+			{".shp", shp}, {".shx", shx}, {".dbf", dbf}, {".prj", []byte(prj)}, {".cpg", []byte("UTF-8")},
+		} {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: l.Name + f.ext, Method: zip.Deflate, Modified: now})
+			if err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(f.data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// shapes returns the main file and the index file of l.
+func (l Layer) shapes() (shp, shx []byte) {
+	box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	var records [][]byte
+	for _, f := range l.Features {
+		fbox := bounds(f.Coordinates)
+		box[0], box[1] = math.Min(box[0], fbox[0]), math.Min(box[1], fbox[1])
+		box[2], box[3] = math.Max(box[2], fbox[2]), math.Max(box[3], fbox[3])
+
+		r := binary.LittleEndian.AppendUint32(nil, uint32(l.ShapeType))
+		if l.ShapeType == PolyLine {
+			for _, v := range fbox {
+				r = binary.LittleEndian.AppendUint64(r, math.Float64bits(v))
+			}
+			r = binary.LittleEndian.AppendUint32(r, 1) // parts
+			r = binary.LittleEndian.AppendUint32(r, uint32(len(f.Coordinates)))
+			r = binary.LittleEndian.AppendUint32(r, 0) // start of the part
+		}
+		for _, c := range f.Coordinates {
+			r = binary.LittleEndian.AppendUint64(r, math.Float64bits(c[0]))
+			r = binary.LittleEndian.AppendUint64(r, math.Float64bits(c[1]))
+		}
+		records = append(records, r)
+	}
+	if len(records) == 0 {
+		box = [4]float64{}
+	}
+
+	// lengths and offsets are counted in 16-bit words
+	size := 100
+	for _, r := range records {
+		size += 8 + len(r)
+	}
+	shp = l.header(size, box)
+	shx = l.header(100+8*len(records), box)
+	for i, r := range records {
+		shx = binary.BigEndian.AppendUint32(shx, uint32(len(shp)/2))
+		shx = binary.BigEndian.AppendUint32(shx, uint32(len(r)/2))
+		shp = binary.BigEndian.AppendUint32(shp, uint32(i+1))
+		shp = binary.BigEndian.AppendUint32(shp, uint32(len(r)/2))
+		shp = append(shp, r...)
+	}
+	return shp, shx
+}
+
+// header returns the header of a main or index file of l with size bytes.
+func (l Layer) header(size int, box [4]float64) []byte {
+	h := make([]byte, 100)
+	binary.BigEndian.PutUint32(h[0:], 9994)
+	binary.BigEndian.PutUint32(h[24:], uint32(size/2))
+	binary.LittleEndian.PutUint32(h[28:], 1000)
+	binary.LittleEndian.PutUint32(h[32:], uint32(l.ShapeType))
+	for i, v := range box {
+		binary.LittleEndian.PutUint64(h[36+8*i:], math.Float64bits(v))
+	}
+	return h
+}
+
+// bounds returns the bounding box minimum x, minimum y, maximum x, maximum y
+// of coords.
+func bounds(coords [][2]float64) [4]float64 {
+	box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, c := range coords {
+		box[0], box[1] = math.Min(box[0], c[0]), math.Min(box[1], c[1])
+		box[2], box[3] = math.Max(box[2], c[0]), math.Max(box[3], c[1])
+	}
+	return box
+}
+
+// table returns the dBase file with the attributes of l.
+func (l Layer) table(now time.Time) ([]byte, error) {
+	recordSize := 1
+	for _, f := range l.Fields {
+		if len(f.Name) > 10 || f.Name == "" {
+			return nil, fmt.Errorf("shp: invalid field name %q, must have 1 to 10 bytes", f.Name)
+		}
+		if (f.Type != 'C' && f.Type != 'N') || f.Length < 1 || f.Length > 254 {
+			return nil, fmt.Errorf("shp: invalid field %s", f.Name)
+		}
+		recordSize += f.Length
+	}
+	headerSize := 32 + 32*len(l.Fields) + 1
+
+	b := make([]byte, 32, headerSize+recordSize*len(l.Features)+1)
+	b[0] = 0x03 // dBase III without memo
+	b[1], b[2], b[3] = byte(now.Year()-1900), byte(now.Month()), byte(now.Day())
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(l.Features)))
+	binary.LittleEndian.PutUint16(b[8:], uint16(headerSize))
+	binary.LittleEndian.PutUint16(b[10:], uint16(recordSize))
+	for _, f := range l.Fields {
+		d := make([]byte, 32)
+		copy(d, f.Name)
+		d[11] = f.Type
+		d[16], d[17] = byte(f.Length), byte(f.Decimals)
+		b = append(b, d...)
+	}
+	b = append(b, 0x0d)
+
+	for i, feature := range l.Features {
+		if len(feature.Values) != len(l.Fields) {
+			return nil, fmt.Errorf("shp: feature %d of %s has %d values for %d fields", i, l.Name, len(feature.Values), len(l.Fields))
+		}
+		b = append(b, ' ') // not deleted
+		for j, f := range l.Fields {
+			b = append(b, value(f, feature.Values[j])...)
+		}
+	}
+	return append(b, 0x1a), nil
+}
+
+// value returns v formatted for the field f.
+func value(f Field, v interface{}) []byte {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case time.Time:
+		s = v.UTC().Format(time.RFC3339)
+	case int:
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', f.Decimals, 64)
+	}
+	if f.Type == 'N' {
+		if len(s) > f.Length {
+			return bytes.Repeat([]byte{'*'}, f.Length)
+		}
+		return []byte(strings.Repeat(" ", f.Length-len(s)) + s)
+	}
+	// cut text at a character boundary
+	for len(s) > f.Length {
+		_, n := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-n]
+	}
+	return []byte(s + strings.Repeat(" ", f.Length-len(s)))
+}
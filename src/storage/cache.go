@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"owntracks"
+)
+
+// Cache is a Store that keeps the positions of the last Window in memory in
+// front of another Store. Inserted positions are written to the backend and
+// appended to the cache. Queries that lie completely within the window as
+// well as the lists of users and devices are answered from memory.
+type Cache struct {
+	Backend Store
+	Window  time.Duration
+
+	mu      sync.RWMutex
+	devices map[string]Device
+	recent  map[string][]owntracks.LocationUpdate
+}
+
+// NewCache returns a Cache of the positions of the last window in front of
+// backend.
+func NewCache(backend Store, window time.Duration) (*Cache, error) {
+	c := &Cache{
+		Backend: backend,
+		Window:  window,
+		devices: make(map[string]Device),
+		recent:  make(map[string][]owntracks.LocationUpdate),
+	}
+	devices, err := backend.Devices()
+	if err != nil {
+		return nil, err
+	}
+	from := time.Now().Add(-window)
+	for _, d := range devices {
+		k := Key(d.Last)
+		c.devices[k] = d
+		q := d.Query()
+		q.From = from
+		if c.recent[k], err = backend.QueryPositions(q); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Cache) InsertPosition(lu owntracks.LocationUpdate) error {
+	if err := c.Backend.InsertPosition(lu); err != nil {
+		return err
+	}
+	k := Key(lu)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d, ok := c.devices[k]; !ok || !lu.T.Before(d.Last.T) {
+		c.devices[k] = Device{User: lu.User, TrackerID: lu.TrackerID, Last: lu}
+	}
+	from := time.Now().Add(-c.Window)
+	if lu.T.Before(from) {
+		return nil
+	}
+	h := c.recent[k]
+	i := sort.Search(len(h), func(i int) bool { return h[i].T.After(lu.T) })
+	h = append(h, owntracks.LocationUpdate{})
+	copy(h[i+1:], h[i:])
+	h[i] = lu
+	// drop what has fallen out of the window
+	j := sort.Search(len(h), func(j int) bool { return !h[j].T.Before(from) })
+	c.recent[k] = h[j:]
+	return nil
+}
+
+// QueryPositions answers q from memory if q.From lies within the window, and
+// from the backend otherwise.
+func (c *Cache) QueryPositions(q Query) ([]owntracks.LocationUpdate, error) {
+	if q.From.IsZero() || q.From.Before(time.Now().Add(-c.Window)) {
+		return c.Backend.QueryPositions(q)
+	}
+	devices, _ := c.Devices()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var l []owntracks.LocationUpdate
+	for _, d := range devices {
+		if (q.User != "" && q.User != d.User) || (q.TrackerID != "" && q.TrackerID != d.TrackerID) {
+			continue
+		}
+		h := c.recent[Key(d.Last)]
+		i := sort.Search(len(h), func(i int) bool { return !h[i].T.Before(q.From) })
+		for _, lu := range h[i:] {
+			if !q.To.IsZero() && lu.T.After(q.To) {
+				break
+			}
+			if strings.HasPrefix(lu.Geohash, q.Geohash) {
+				l = append(l, lu)
+			}
+		}
+	}
+	return downsample(l, q.Every), nil
+}
+
+func (c *Cache) Users() ([]string, error) {
+	devices, _ := c.Devices()
+	seen := make(map[string]bool)
+	var users []string
+	for _, d := range devices {
+		if !seen[d.User] {
+			seen[d.User] = true
+			users = append(users, d.User)
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+func (c *Cache) Devices() ([]Device, error) {
+	c.mu.RLock()
+	devices := make([]Device, 0, len(c.devices))
+	for _, d := range c.devices {
+		devices = append(devices, d)
+	}
+	c.mu.RUnlock()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name() < devices[j].Name() })
+	return devices, nil
+}
+
+func (c *Cache) Close() error {
+	return c.Backend.Close()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import "sync"
+
+// DeviceInfo holds the settings of a device that are not part of its
+// positions.
+type DeviceInfo struct {
+	// Label is shown instead of the device name if it is not empty.
+	Label string `json:"label"`
+	Icon  string `json:"icon"`
+	Color string `json:"color"`
+	// Group tags devices that belong together, e.g. the phones of a family.
+	Group string `json:"group"`
+	// Archived devices are hidden from live views, their positions are
+	// still available.
+	Archived bool `json:"archived"`
+}
+
+// DeviceInfoStore keeps the DeviceInfo of every device in a JSON file.
+type DeviceInfoStore struct {
+	mu    sync.Mutex
+	path  string
+	infos map[string]DeviceInfo
+}
+
+// OpenDeviceInfos loads the device infos stored at path. A missing file is
+// created on the first change. If path is empty, the infos are only kept in
+// memory.
+func OpenDeviceInfos(path string) (*DeviceInfoStore, error) {
+	ds := &DeviceInfoStore{path: path, infos: make(map[string]DeviceInfo)}
+	if path == "" {
+		return ds, nil
+	}
+	if err := readJSONFile(path, &ds.infos); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Get returns the info of the device with the given name, as returned by
+// Device.Name. Devices without info get the zero DeviceInfo.
+func (ds *DeviceInfoStore) Get(name string) DeviceInfo {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.infos[name]
+}
+
+// Set stores di as the info of the device with the given name.
+func (ds *DeviceInfoStore) Set(name string, di DeviceInfo) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	old, existed := ds.infos[name]
+	ds.infos[name] = di
+	if err := ds.save(); err != nil {
+		if existed {
+			ds.infos[name] = old
+		} else {
+			delete(ds.infos, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the info of the device with the given name.
+func (ds *DeviceInfoStore) Delete(name string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	old, existed := ds.infos[name]
+	if !existed {
+		return nil
+	}
+	delete(ds.infos, name)
+	if err := ds.save(); err != nil {
+		ds.infos[name] = old
+		return err
+	}
+	return nil
+}
+
+// save writes the current infos to the file of ds.
+func (ds *DeviceInfoStore) save() error {
+	if ds.path == "" {
+		return nil
+	}
+	return writeJSONFile(ds.path, ds.infos)
+}
+
+// Rename moves the info of the device named old to the device named new,
+// unless new has an info of its own.
+func (ds *DeviceInfoStore) Rename(old, new string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	di, ok := ds.infos[old]
+	if !ok {
+		return nil
+	}
+	_, exists := ds.infos[new]
+	if !exists {
+		ds.infos[new] = di
+	}
+	delete(ds.infos, old)
+	if err := ds.save(); err != nil {
+		if !exists {
+			delete(ds.infos, new)
+		}
+		ds.infos[old] = di
+		return err
+	}
+	return nil
+}
+
+// Names returns the names of all devices that have an info.
+func (ds *DeviceInfoStore) Names() []string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var names []string
+	for n := range ds.infos {
+		names = append(names, n)
+	}
+	return names
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"geo"
+	"owntracks"
+)
+
+func init() {
+	Register("file", OpenFile)
+}
+
+// File is a Store that appends every position as a line of JSON to a file
+// and serves queries from memory. It is registered as driver "file", the data
+// source name is the path of the file.
+type File struct {
+	*Memory
+	mu sync.Mutex
+	f  *os.File
+	// end is the size of the file up to the last complete line.
+	end int64
+}
+
+// OpenFile opens the position file at path, creating it if necessary, and
+// loads the positions stored in it. Positions stored without a geohash get
+// one on loading.
+func OpenFile(path string) (Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("storage: file driver needs a path")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMemory()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var lu owntracks.LocationUpdate
+		if err := json.Unmarshal(sc.Bytes(), &lu); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("storage: %s:%d: %v", path, line, err)
+		}
+		if lu.Geohash == "" {
+			lu.Geohash = geo.Geohash(lu.Latitude, lu.Longitude, geo.GeohashPrecision)
+		}
+		m.InsertPosition(lu)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{Memory: m, f: f, end: fi.Size()}, nil
+}
+
+// InsertPosition appends lu to the file before adding it to memory. If
+// writing fails, the file is cut back to its last complete line, so that
+// later positions can be stored and the file can still be loaded.
+func (f *File) InsertPosition(lu owntracks.LocationUpdate) error {
+	b, err := json.Marshal(lu)
+	if err != nil {
+		return fmt.Errorf("storage: %w: %v", ErrInvalidPosition, err)
+	}
+	b = append(b, '\n')
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.f.Write(b); err != nil {
+		f.f.Truncate(f.end)
+		return err
+	}
+	f.end += int64(len(b))
+	return f.Memory.InsertPosition(lu)
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
+
+// RewriteFile applies fn to every position in the position file at path and
+// atomically replaces the file with the result. fn reports whether it changed
+// the position, RewriteFile returns the number of changed positions. The file
+// must not be opened by a running File store at the same time.
+func RewriteFile(path string, fn func(lu *owntracks.LocationUpdate) bool) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, 1024*1024)
+	changed := 0
+	for line := 1; sc.Scan(); line++ {
+		var lu owntracks.LocationUpdate
+		if err := json.Unmarshal(sc.Bytes(), &lu); err != nil {
+			tmp.Close()
+			return 0, fmt.Errorf("storage: %s:%d: %v", path, line, err)
+		}
+		if !fn(&lu) {
+			w.Write(sc.Bytes())
+			w.WriteByte('\n')
+			continue
+		}
+		changed++
+		b, err := json.Marshal(lu)
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+		w.Write(b)
+		w.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return changed, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"owntracks"
+)
+
+func TestFileRecoversFromWriteErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "positions.jsonl")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := s.(*File)
+	lu := owntracks.LocationUpdate{T: time.Now(), User: "u", TrackerID: "t", Latitude: 50, Longitude: 8}
+	if err := f.InsertPosition(lu); err != nil {
+		t.Fatal(err)
+	}
+	// writing to a read-only handle fails like a full disk
+	w := f.f
+	if f.f, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.InsertPosition(lu); err == nil {
+		t.Fatal("no error writing to a read-only file")
+	}
+	f.f.Close()
+	f.f = w
+	lu.T = lu.T.Add(time.Minute)
+	if err := f.InsertPosition(lu); err != nil {
+		t.Fatalf("error after a failed write: %v", err)
+	}
+	f.Close()
+
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if all, _ := s.QueryPositions(Query{}); len(all) != 2 {
+		t.Errorf("loaded %d positions, want 2", len(all))
+	}
+}
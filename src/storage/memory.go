@@ -0,0 +1,117 @@
+// Package storage keeps the location updates received by daisser. The
+// backends are implemented as drivers behind the Store interface.
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"owntracks"
+)
+
+func init() {
+	Register("memory", func(string) (Store, error) { return NewMemory(), nil })
+}
+
+// MaxHistory is the number of location updates kept per tracker by Memory.
+const MaxHistory = 10000
+
+// Key returns the key under which the updates of the tracker that sent lu are
+// stored. User names contain no slash, so every tracker has its own key.
+func Key(lu owntracks.LocationUpdate) string {
+	return DeviceName(lu)
+}
+
+// DeviceName returns the human-readable name of the tracker that sent lu.
+func DeviceName(lu owntracks.LocationUpdate) string {
+	return lu.User + "/" + lu.TrackerID
+}
+
+// Memory is a Store that keeps the recent history of every tracker in
+// memory. It is registered as driver "memory" and ignores the data source
+// name.
+type Memory struct {
+	mu      sync.RWMutex
+	history map[string][]owntracks.LocationUpdate
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{history: make(map[string][]owntracks.LocationUpdate)}
+}
+
+// InsertPosition inserts lu into the history of its tracker.
+func (m *Memory) InsertPosition(lu owntracks.LocationUpdate) error {
+	k := Key(lu)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// trackers may deliver buffered updates late, keep the history sorted
+	h := m.history[k]
+	i := sort.Search(len(h), func(i int) bool { return h[i].T.After(lu.T) })
+	h = append(h, owntracks.LocationUpdate{})
+	copy(h[i+1:], h[i:])
+	h[i] = lu
+	if len(h) > MaxHistory {
+		h = h[len(h)-MaxHistory:]
+	}
+	m.history[k] = h
+	return nil
+}
+
+func (m *Memory) QueryPositions(q Query) ([]owntracks.LocationUpdate, error) {
+	devices, _ := m.Devices()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var l []owntracks.LocationUpdate
+	for _, d := range devices {
+		if (q.User != "" && q.User != d.User) || (q.TrackerID != "" && q.TrackerID != d.TrackerID) {
+			continue
+		}
+		h := m.history[Key(d.Last)]
+		i := 0
+		if !q.From.IsZero() {
+			i = sort.Search(len(h), func(i int) bool { return !h[i].T.Before(q.From) })
+		}
+		for _, lu := range h[i:] {
+			if !q.To.IsZero() && lu.T.After(q.To) {
+				break
+			}
+			if strings.HasPrefix(lu.Geohash, q.Geohash) {
+				l = append(l, lu)
+			}
+		}
+	}
+	return downsample(l, q.Every), nil
+}
+
+func (m *Memory) Users() ([]string, error) {
+	m.mu.RLock()
+	seen := make(map[string]bool)
+	var users []string
+	for _, h := range m.history {
+		if u := h[0].User; !seen[u] {
+			seen[u] = true
+			users = append(users, u)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Strings(users)
+	return users, nil
+}
+
+func (m *Memory) Devices() ([]Device, error) {
+	m.mu.RLock()
+	devices := make([]Device, 0, len(m.history))
+	for _, h := range m.history {
+		last := h[len(h)-1]
+		devices = append(devices, Device{User: last.User, TrackerID: last.TrackerID, Last: last})
+	}
+	m.mu.RUnlock()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name() < devices[j].Name() })
+	return devices, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
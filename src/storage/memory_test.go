@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"owntracks"
+)
+
+func TestMemoryKeepsDevicesApart(t *testing.T) {
+	now := time.Now()
+	cache, err := NewCache(NewMemory(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Store{NewMemory(), cache} {
+		for _, lu := range []owntracks.LocationUpdate{
+			{T: now, User: "ab", TrackerID: "c", Latitude: 1},
+			{T: now.Add(time.Second), User: "a", TrackerID: "bc", Latitude: 2},
+		} {
+			if err := s.InsertPosition(lu); err != nil {
+				t.Fatal(err)
+			}
+		}
+		devices, err := s.Devices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 2 {
+			t.Errorf("%T: %d devices, want ab/c and a/bc", s, len(devices))
+		}
+		for _, d := range devices {
+			if l, _ := s.QueryPositions(d.Query()); len(l) != 1 {
+				t.Errorf("%T: %d positions of %s, want 1", s, len(l), d.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pborman/uuid"
+)
+
+// Place is a named circular area of a user, like home or the gym.
+type Place struct {
+	ID        string  `json:"id"`
+	User      string  `json:"user"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Radius is the radius of the place in [m].
+	Radius   float64 `json:"radius"`
+	Category string  `json:"category"`
+}
+
+// PlaceStore keeps the places of all users in a JSON file.
+type PlaceStore struct {
+	mu     sync.Mutex
+	path   string
+	places map[string]Place
+}
+
+// OpenPlaces loads the places stored at path. A missing file is created on
+// the first change. If path is empty, the places are only kept in memory.
+func OpenPlaces(path string) (*PlaceStore, error) {
+	ps := &PlaceStore{path: path, places: make(map[string]Place)}
+	if path == "" {
+		return ps, nil
+	}
+	if err := readJSONFile(path, &ps.places); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// List returns the places of user, sorted by name.
+func (ps *PlaceStore) List(user string) []Place {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	l := []Place{}
+	for _, p := range ps.places {
+		if p.User == user {
+			l = append(l, p)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+// Get returns the place with the given id.
+func (ps *PlaceStore) Get(id string) (Place, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.places[id]
+	return p, ok
+}
+
+// Put stores p, replacing the place with the same ID. If p.ID is empty, p
+// is added with a new ID. The stored place is returned.
+func (ps *PlaceStore) Put(p Place) (Place, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p.ID == "" {
+		p.ID = uuid.New()
+	}
+	old, existed := ps.places[p.ID]
+	ps.places[p.ID] = p
+	if err := ps.save(); err != nil {
+		if existed {
+			ps.places[p.ID] = old
+		} else {
+			delete(ps.places, p.ID)
+		}
+		return p, err
+	}
+	return p, nil
+}
+
+// Delete removes the place with the given id.
+func (ps *PlaceStore) Delete(id string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	old, existed := ps.places[id]
+	if !existed {
+		return nil
+	}
+	delete(ps.places, id)
+	if err := ps.save(); err != nil {
+		ps.places[id] = old
+		return err
+	}
+	return nil
+}
+
+// save writes the current places to the file of ps.
+func (ps *PlaceStore) save() error {
+	if ps.path == "" {
+		return nil
+	}
+	return writeJSONFile(ps.path, ps.places)
+}
+
+// ReassignUser gives all places of user old to user new.
+func (ps *PlaceStore) ReassignUser(old, new string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var changed []string
+	for id, p := range ps.places {
+		if p.User == old {
+			p.User = new
+			ps.places[id] = p
+			changed = append(changed, id)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := ps.save(); err != nil {
+		for _, id := range changed {
+			p := ps.places[id]
+			p.User = old
+			ps.places[id] = p
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pborman/uuid"
+)
+
+// POI is a point of interest a user pinned on their map, like a campsite or
+// a parking spot. Unlike a Place, it is not matched against positions.
+type POI struct {
+	ID        string  `json:"id"`
+	User      string  `json:"user"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Icon      string  `json:"icon"`
+	Notes     string  `json:"notes"`
+}
+
+// POIStore keeps the POIs of all users in a JSON file.
+type POIStore struct {
+	mu   sync.Mutex
+	path string
+	pois map[string]POI
+}
+
+// OpenPOIs loads the POIs stored at path. A missing file is created on the
+// first change. If path is empty, the POIs are only kept in memory.
+func OpenPOIs(path string) (*POIStore, error) {
+	ps := &POIStore{path: path, pois: make(map[string]POI)}
+	if path == "" {
+		return ps, nil
+	}
+	if err := readJSONFile(path, &ps.pois); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// List returns the POIs of user, sorted by name.
+func (ps *POIStore) List(user string) []POI {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	l := []POI{}
+	for _, p := range ps.pois {
+		if p.User == user {
+			l = append(l, p)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+// Get returns the POI with the given id.
+func (ps *POIStore) Get(id string) (POI, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.pois[id]
+	return p, ok
+}
+
+// Put stores p, replacing the POI with the same ID. If p.ID is empty, p is
+// added with a new ID. The stored POI is returned.
+func (ps *POIStore) Put(p POI) (POI, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p.ID == "" {
+		p.ID = uuid.New()
+	}
+	old, existed := ps.pois[p.ID]
+	ps.pois[p.ID] = p
+	if err := ps.save(); err != nil {
+		if existed {
+			ps.pois[p.ID] = old
+		} else {
+			delete(ps.pois, p.ID)
+		}
+		return p, err
+	}
+	return p, nil
+}
+
+// Delete removes the POI with the given id.
+func (ps *POIStore) Delete(id string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	old, existed := ps.pois[id]
+	if !existed {
+		return nil
+	}
+	delete(ps.pois, id)
+	if err := ps.save(); err != nil {
+		ps.pois[id] = old
+		return err
+	}
+	return nil
+}
+
+// save writes the current POIs to the file of ps.
+func (ps *POIStore) save() error {
+	if ps.path == "" {
+		return nil
+	}
+	return writeJSONFile(ps.path, ps.pois)
+}
+
+// ReassignUser gives all POIs of user old to user new.
+func (ps *POIStore) ReassignUser(old, new string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var changed []string
+	for id, p := range ps.pois {
+		if p.User == old {
+			p.User = new
+			ps.pois[id] = p
+			changed = append(changed, id)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := ps.save(); err != nil {
+		for _, id := range changed {
+			p := ps.pois[id]
+			p.User = old
+			ps.pois[id] = p
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import "sync"
+
+// Unit systems for Preferences.Units.
+const (
+	Metric   = "metric"
+	Imperial = "imperial"
+)
+
+// Preferences are the settings a user can change for themselves.
+type Preferences struct {
+	// Units is either Metric or Imperial.
+	Units string `json:"units"`
+	// Timezone is an IANA time zone name, times are shown in UTC if empty.
+	Timezone string `json:"timezone"`
+	// Center and Zoom give the initial view of the map. Center is
+	// [latitude, longitude].
+	Center [2]float64 `json:"center"`
+	Zoom   int        `json:"zoom"`
+	// VisibleUsers restricts the live view to these users. All users are
+	// shown if it is empty.
+	VisibleUsers []string `json:"visibleUsers"`
+	// Language is the language of the pages, it is negotiated with the
+	// browser if empty.
+	Language string `json:"language"`
+	// Notifications enables or disables notifications by their name.
+	Notifications map[string]bool `json:"notifications"`
+	// PrivacyRules pause tracking: positions of the devices of the user that
+	// match one of them are discarded or masked before they are stored.
+	PrivacyRules []PrivacyRule `json:"privacyRules"`
+}
+
+// Actions of a PrivacyRule.
+const (
+	// Discard drops the position.
+	Discard = "discard"
+	// Mask stores the position with the center of the place of the rule,
+	// or with coarse coordinates if it has none, and without details like
+	// altitude, velocity and description.
+	Mask = "mask"
+)
+
+// PrivacyRule selects positions by the time of day and the place they were
+// recorded at. A rule without any condition matches all positions.
+type PrivacyRule struct {
+	// Action is Discard or Mask.
+	Action string `json:"action"`
+	// From and To limit the rule to the daily span between them, like
+	// "22:00" and "06:00", in the time zone of the Timezone preference.
+	// Spans may cross midnight.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Days limits the rule to the weekdays, like "sat" and "sun", on which
+	// the span starts.
+	Days []string `json:"days,omitempty"`
+	// Place limits the rule to positions inside the place of the user
+	// with this name.
+	Place string `json:"place,omitempty"`
+}
+
+// DefaultPreferences are the preferences of users that did not change any.
+var DefaultPreferences = Preferences{
+	Units:  Metric,
+	Center: [2]float64{50, 8.56},
+	Zoom:   10,
+}
+
+// PreferenceStore keeps the Preferences of every user in a JSON file.
+type PreferenceStore struct {
+	mu    sync.Mutex
+	path  string
+	prefs map[string]Preferences
+}
+
+// OpenPreferences loads the preferences stored at path. A missing file is
+// created on the first change. If path is empty, the preferences are only
+// kept in memory.
+func OpenPreferences(path string) (*PreferenceStore, error) {
+	ps := &PreferenceStore{path: path, prefs: make(map[string]Preferences)}
+	if path == "" {
+		return ps, nil
+	}
+	if err := readJSONFile(path, &ps.prefs); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Get returns the preferences of user, or DefaultPreferences if user did not
+// store any.
+func (ps *PreferenceStore) Get(user string) Preferences {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p, ok := ps.prefs[user]; ok {
+		return p
+	}
+	return DefaultPreferences
+}
+
+// Set stores p as the preferences of user.
+func (ps *PreferenceStore) Set(user string, p Preferences) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	old, existed := ps.prefs[user]
+	ps.prefs[user] = p
+	if err := ps.save(); err != nil {
+		if existed {
+			ps.prefs[user] = old
+		} else {
+			delete(ps.prefs, user)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the current preferences to the file of ps.
+func (ps *PreferenceStore) save() error {
+	if ps.path == "" {
+		return nil
+	}
+	return writeJSONFile(ps.path, ps.prefs)
+}
+
+// RenameUser moves the preferences of user old to user new, unless new has
+// preferences of their own.
+func (ps *PreferenceStore) RenameUser(old, new string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.prefs[old]
+	if !ok {
+		return nil
+	}
+	_, exists := ps.prefs[new]
+	if !exists {
+		ps.prefs[new] = p
+	}
+	delete(ps.prefs, old)
+	if err := ps.save(); err != nil {
+		if !exists {
+			delete(ps.prefs, new)
+		}
+		ps.prefs[old] = p
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// intervalWeight is the weight of a new interval in the moving average of
+// DeviceStats.AverageIntervalS.
+const intervalWeight = 0.1
+
+// DeviceStats are the counters of the positions a device sent, for finding
+// out why a device stopped reporting.
+type DeviceStats struct {
+	// Day is the local day of the server Today counts, like "2026-10-16".
+	Day string `json:"day"`
+	// Today is the number of positions accepted on Day.
+	Today int `json:"today"`
+	// Total is the number of all accepted positions.
+	Total int `json:"total"`
+	// Rejected is the number of positions that were not stored, because
+	// they were invalid or the storage failed.
+	Rejected int `json:"rejected"`
+	// LastReceived is when the last position was accepted.
+	LastReceived time.Time `json:"lastReceived,omitzero"`
+	// AverageIntervalS is the moving average of the time between accepted
+	// positions in [s], weighting recent intervals most.
+	AverageIntervalS float64 `json:"averageIntervalS"`
+	// LastError is why the last rejected position was rejected, at
+	// LastErrorTime.
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime,omitzero"`
+}
+
+// DeviceStatsStore keeps the DeviceStats of every device in memory and
+// writes them to a JSON file on Flush.
+type DeviceStatsStore struct {
+	mu    sync.Mutex
+	path  string
+	stats map[string]DeviceStats
+	dirty bool
+}
+
+// OpenDeviceStats loads the device stats stored at path. If path is empty,
+// the stats are only kept in memory.
+func OpenDeviceStats(path string) (*DeviceStatsStore, error) {
+	ss := &DeviceStatsStore{path: path, stats: make(map[string]DeviceStats)}
+	if path == "" {
+		return ss, nil
+	}
+	if err := readJSONFile(path, &ss.stats); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Get returns the stats of the device with the given name as of now.
+func (ss *DeviceStatsStore) Get(name string, now time.Time) DeviceStats {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.stats[name].on(now)
+}
+
+// on returns st with Today counting the day of now.
+func (st DeviceStats) on(now time.Time) DeviceStats {
+	if day := now.Format("2006-01-02"); st.Day != day {
+		st.Day, st.Today = day, 0
+	}
+	return st
+}
+
+// Accepted counts a position of the device with the given name that was
+// accepted at now.
+func (ss *DeviceStatsStore) Accepted(name string, now time.Time) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	st := ss.stats[name].on(now)
+	if !st.LastReceived.IsZero() && now.After(st.LastReceived) {
+		interval := now.Sub(st.LastReceived).Seconds()
+		if st.AverageIntervalS == 0 {
+			st.AverageIntervalS = interval
+		} else {
+			st.AverageIntervalS += intervalWeight * (interval - st.AverageIntervalS)
+		}
+	}
+	st.Today++
+	st.Total++
+	st.LastReceived = now
+	ss.stats[name] = st
+	ss.dirty = true
+}
+
+// Rejected counts a position of the device with the given name that was
+// rejected at now because of err.
+func (ss *DeviceStatsStore) Rejected(name string, now time.Time, err error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	st := ss.stats[name].on(now)
+	st.Rejected++
+	st.LastError = err.Error()
+	st.LastErrorTime = now
+	ss.stats[name] = st
+	ss.dirty = true
+}
+
+// Flush writes the stats to the file of ss if they changed since the last
+// Flush.
+func (ss *DeviceStatsStore) Flush() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.path == "" || !ss.dirty {
+		return nil
+	}
+	if err := writeJSONFile(ss.path, ss.stats); err != nil {
+		return err
+	}
+	ss.dirty = false
+	return nil
+}
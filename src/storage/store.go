@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"owntracks"
+)
+
+// Store is implemented by all storage backends. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	// InsertPosition stores lu.
+	InsertPosition(lu owntracks.LocationUpdate) error
+	// QueryPositions returns all positions matching q, sorted by device and
+	// time.
+	QueryPositions(q Query) ([]owntracks.LocationUpdate, error)
+	// Users returns the names of all users that sent positions, sorted.
+	Users() ([]string, error)
+	// Devices returns all devices that sent positions, sorted by name.
+	Devices() ([]Device, error)
+	// Close releases all resources of the store.
+	Close() error
+}
+
+// ErrInvalidPosition is wrapped by the errors of stores that refuse a
+// position itself, instead of failing to store it. Storing it again fails
+// again.
+var ErrInvalidPosition = errors.New("invalid position")
+
+// Query selects positions from a Store. Empty fields match everything.
+type Query struct {
+	User      string
+	TrackerID string
+	From      time.Time
+	To        time.Time
+	// Geohash selects the positions whose geohash starts with it.
+	Geohash string
+	// Every keeps only the last position of every device in each interval
+	// of this length, counted from the Unix epoch. 0 keeps all positions.
+	Every time.Duration
+}
+
+// Device is a tracker of a user.
+type Device struct {
+	User      string
+	TrackerID string
+	// Last is the latest position the device sent.
+	Last owntracks.LocationUpdate
+}
+
+// Name returns the human-readable name of d.
+func (d Device) Name() string {
+	return d.User + "/" + d.TrackerID
+}
+
+// Query returns the Query selecting all positions of d.
+func (d Device) Query() Query {
+	return Query{User: d.User, TrackerID: d.TrackerID}
+}
+
+// A Driver opens a Store from a driver-specific data source name.
+type Driver func(dsn string) (Store, error)
+
+var (
+	driversMu sync.Mutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a storage driver available under name.
+func Register(name string, d Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if _, dup := drivers[name]; dup {
+		panic("storage: Register called twice for driver " + name)
+	}
+	drivers[name] = d
+}
+
+// Drivers returns the names of all registered drivers, sorted.
+func Drivers() []string {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	var names []string
+	for n := range drivers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the Store of the driver registered as name.
+func Open(name, dsn string) (Store, error) {
+	driversMu.Lock()
+	d, ok := drivers[name]
+	driversMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("storage: unknown driver %q", name)
+	}
+	return d(dsn)
+}
+
+// downsample returns the last position of every device in each interval
+// of length every of l, which is sorted by device and time. l is changed.
+func downsample(l []owntracks.LocationUpdate, every time.Duration) []owntracks.LocationUpdate {
+	if every <= 0 {
+		return l
+	}
+	bucket := func(t time.Time) int64 { return t.UnixNano() / int64(every) }
+	n := 0
+	for i, lu := range l {
+		if i+1 < len(l) && Key(l[i+1]) == Key(lu) && bucket(l[i+1].T) == bucket(lu.T) {
+			continue
+		}
+		l[n] = lu
+		n++
+	}
+	return l[:n]
+}
+
+// Tracks queries the positions of every device matching q and returns them
+// grouped by device.
+func Tracks(s Store, q Query) ([][]owntracks.LocationUpdate, error) {
+	devices, err := s.Devices()
+	if err != nil {
+		return nil, err
+	}
+	var tracks [][]owntracks.LocationUpdate
+	for _, d := range devices {
+		if (q.User != "" && q.User != d.User) || (q.TrackerID != "" && q.TrackerID != d.TrackerID) {
+			continue
+		}
+		dq := q
+		dq.User, dq.TrackerID = d.User, d.TrackerID
+		t, err := s.QueryPositions(dq)
+		if err != nil {
+			return nil, err
+		}
+		if len(t) > 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks, nil
+}